@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aaronkoerner/telegrafPlugins/eip"
+	"github.com/influxdata/telegraf/config"
+)
+
+/*
+eipctl looks at a controller from the command line, with the same
+client the Telegraf plugin uses:
+	eipctl discover
+	eipctl -host 10.0.0.5 identity
+	eipctl -host 10.0.0.5 taglist -type DINT -match 'Line1*' -format csv
+	eipctl -host 10.0.0.5 read -n 10 Values[0]
+	eipctl -host 10.0.0.5 read -watch 1s Temp Count
+	eipctl -host 10.0.0.5 write Setpoint 12.5
+	eipctl -host 10.0.0.5 time set now
+	eipctl -host 10.0.0.5 modules
+Connection flags go before the command, the command's own after it.
+-json prints JSON instead of tables: one document per command, or one
+line per tag and read when watching
+*/
+
+const usage = `usage: eipctl [flags] <command> [command flags] [arguments]
+
+commands:
+  discover                       find devices with a List Identity broadcast
+  identity                       show the identity of the controller
+  taglist                        list the tags of the controller
+  read [-n count] [-watch interval] tag...
+                                 read tags, once or over and over
+  write tag value...             write values, one per element from tag on
+  time get | time set [time|now] show or set the controller clock
+  modules                        list the modules in the controller's chassis
+
+flags:
+`
+
+var errUsage = errors.New("usage")
+
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	host string
+	port uint
+	slot uint
+	route string
+	timeout time.Duration
+	unconnected bool
+	json bool
+	verbose bool
+
+	plc *eip.PLC
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(_run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func _run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	/*
+	Everything main does, with the output where the tests can see it
+	Returns the exit status: 1 for errors, 2 for bad usage
+	*/
+	c := &cli{stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("eipctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.host, "host", "", "address of the controller, or of the Ethernet module in front of it")
+	flags.UintVar(&c.port, "port", 44818, "EtherNet/IP port")
+	flags.UintVar(&c.slot, "slot", 0, "backplane slot of the controller")
+	flags.StringVar(&c.route, "route", "", "route to the controller as port,link pairs, overrides -slot")
+	flags.DurationVar(&c.timeout, "timeout", 5*time.Second, "how long to wait for each reply")
+	flags.BoolVar(&c.unconnected, "unconnected", false, "use unconnected messages only, no Forward Open")
+	flags.BoolVar(&c.json, "json", false, "print JSON")
+	flags.BoolVar(&c.verbose, "v", false, "log what the client does to stderr")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"discover": c._discover,
+		"identity": c._identity,
+		"taglist": c._taglist,
+		"read": c._read,
+		"write": c._write,
+		"time": c._time,
+		"modules": c._modules,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "eipctl: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+	err := command(ctx, flags.Args()[1:])
+	if c.plc != nil {
+		c.plc.Close()
+	}
+	switch {
+	case errors.Is(err, errUsage):
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "eipctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (c *cli)_connect() (*eip.PLC, error) {
+	if len(c.host) == 0 {
+		fmt.Fprintln(c.stderr, "eipctl: -host is required")
+		return nil, errUsage
+	}
+	if c.port == 0 || c.port > 0xFFFF || c.slot > 0xFF {
+		fmt.Fprintln(c.stderr, "eipctl: invalid -port or -slot")
+		return nil, errUsage
+	}
+	level := eip.LogWarn
+	if c.verbose {
+		level = eip.LogDebug
+	}
+	c.plc = &eip.PLC{
+		IPAddress: c.host,
+		Port: uint16(c.port),
+		ProcessorSlot: byte(c.slot),
+		Route: c.route,
+		Unconnected: c.unconnected,
+		Timeout: config.Duration(c.timeout),
+		Logger: eip.NewStdLogger(log.New(c.stderr, "", log.LstdFlags), level),
+	}
+	if err := c.plc.Init(); err != nil {
+		c.plc = nil
+		return nil, err
+	}
+	return c.plc, nil
+}
+
+func (c *cli)_flags(name string, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: eipctl %s %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func (c *cli)_discover(ctx context.Context, args []string) error {
+	flags := c._flags("discover", "[-address broadcast] [-wait duration]")
+	address := flags.String("address", "", "where to send List Identity, the limited broadcast by default")
+	wait := flags.Duration("wait", 2*time.Second, "how long to wait for answers")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	ctx, cancel := context.WithTimeout(ctx, *wait)
+	defer cancel()
+	found, err := eip.DiscoverContext(ctx, *address)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c._printJSON(found)
+	}
+	w := c._table("Address", "Product", "Revision", "Serial", "Type", "Vendor")
+	for _, id := range found {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%08X\t%s\t%s\n", id.IPAddress, id.ProductName, id.Revision, id.SerialNumber,
+			_deviceType(id.DeviceType), _vendor(id.VendorID))
+	}
+	return w.Flush()
+}
+
+func (c *cli)_identity(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		fmt.Fprintln(c.stderr, "usage: eipctl identity")
+		return errUsage
+	}
+	plc, err := c._connect()
+	if err != nil {
+		return err
+	}
+	id, err := plc.GetIdentityContext(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c._printJSON(id)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Product:\t%s\n", id.ProductName)
+	fmt.Fprintf(w, "Revision:\t%s\n", id.Revision)
+	fmt.Fprintf(w, "Serial:\t%08X\n", id.SerialNumber)
+	fmt.Fprintf(w, "Vendor:\t%s\n", _vendor(id.VendorID))
+	fmt.Fprintf(w, "Type:\t%s\n", _deviceType(id.DeviceType))
+	fmt.Fprintf(w, "Product code:\t%d\n", id.ProductCode)
+	fmt.Fprintf(w, "Status:\t0x%04X\n", id.Status)
+	fmt.Fprintf(w, "State:\t%d\n", id.State)
+	fmt.Fprintf(w, "Address:\t%s\n", id.IPAddress)
+	return w.Flush()
+}
+
+func (c *cli)_taglist(ctx context.Context, args []string) error {
+	flags := c._flags("taglist", "[-type name] [-match pattern] [-system] [-format table|csv|json|yaml]")
+	typeName := flags.String("type", "", "only tags of this type, DINT or a UDT name")
+	match := flags.String("match", "", "only tags whose path matches this pattern, like Line1* or Program:Main.*")
+	system := flags.Bool("system", false, "include system tags")
+	format := flags.String("format", "table", "table, csv, json or yaml")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if _, err := path.Match(*match, ""); err != nil {
+		fmt.Fprintf(c.stderr, "eipctl: -match: %v\n", err)
+		return errUsage
+	}
+	if c.json && *format == "table" {
+		*format = "json"
+	}
+	if *format != "table" && *format != "csv" && *format != "json" && *format != "yaml" {
+		fmt.Fprintf(c.stderr, "eipctl: unknown format %q\n", *format)
+		return errUsage
+	}
+	plc, err := c._connect()
+	if err != nil {
+		return err
+	}
+	tags, err := plc.InventoryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var selected []eip.TagInfo
+	for _, t := range tags {
+		if t.System && !*system {
+			continue
+		}
+		if len(*typeName) > 0 && !strings.EqualFold(t.Type, *typeName) {
+			continue
+		}
+		//# names aren't case sensitive in Logix
+		if ok, _ := path.Match(strings.ToUpper(*match), strings.ToUpper(t.Path)); len(*match) > 0 && !ok {
+			continue
+		}
+		selected = append(selected, t)
+	}
+	if *format != "table" {
+		return eip.WriteInventory(c.stdout, eip.ExportFormat(*format), selected)
+	}
+	w := c._table("Tag", "Type", "Access")
+	for _, t := range selected {
+		typ := t.Type
+		if len(t.Dimensions) > 0 {
+			typ += "[" + _joinInts(t.Dimensions) + "]"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Path, typ, t.Access)
+	}
+	return w.Flush()
+}
+
+type readResult struct {
+	Time time.Time `json:"time"`
+	Tag string `json:"tag"`
+	Value interface{} `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *cli)_read(ctx context.Context, args []string) error {
+	flags := c._flags("read", "[-n count] [-watch interval] tag...")
+	count := flags.Int("n", 1, "elements to read, from the one the tag names on; one tag only")
+	watch := flags.Duration("watch", 0, "read again at this interval until interrupted")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	tags := flags.Args()
+	if len(tags) == 0 || *count < 1 || (*count > 1 && len(tags) > 1) || *watch < 0 {
+		flags.Usage()
+		return errUsage
+	}
+	plc, err := c._connect()
+	if err != nil {
+		return err
+	}
+	if *watch == 0 {
+		results := c._readOnce(ctx, plc, tags, *count)
+		if err := c._printResults(results, false); err != nil {
+			return err
+		}
+		return _failed(results)
+	}
+
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for {
+		results := c._readOnce(ctx, plc, tags, *count)
+		if ctx.Err() != nil {
+			//# interrupted in the middle of the read, that's how a watch ends
+			return nil
+		}
+		if err := c._printResults(results, true); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *cli)_readOnce(ctx context.Context, plc *eip.PLC, tags []string, count int) []readResult {
+	/*
+	One tag is a Read, so arrays can be asked for, several go in a
+	MultiRead and fail one by one
+	*/
+	now := time.Now()
+	if len(tags) == 1 {
+		r := readResult{Time: now, Tag: tags[0]}
+		values, err := plc.ReadContext(ctx, tags[0], count)
+		switch {
+		case err != nil:
+			r.Error = err.Error()
+		case count == 1 && len(values) == 1:
+			r.Value = values[0]
+		default:
+			r.Value = values
+		}
+		return []readResult{r}
+	}
+	results := make([]readResult, len(tags))
+	responses, err := plc.MultiReadContext(ctx, tags)
+	for i, tag := range tags {
+		results[i] = readResult{Time: now, Tag: tag}
+		switch {
+		case err != nil && (i >= len(responses) || responses[i].Err == nil):
+			results[i].Error = err.Error()
+		case responses[i].Err != nil:
+			results[i].Error = responses[i].Err.Error()
+		default:
+			results[i].Value = responses[i].Value
+		}
+	}
+	return results
+}
+
+func (c *cli)_printResults(results []readResult, watching bool) error {
+	if c.json {
+		if !watching {
+			return c._printJSON(results)
+		}
+		//# one line each, so a watch can be piped into something that reads JSON lines
+		enc := json.NewEncoder(c.stdout)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		if watching {
+			fmt.Fprintf(w, "%s\t", r.Time.Format("15:04:05.000"))
+		}
+		if len(r.Error) > 0 {
+			fmt.Fprintf(w, "%s\terror: %s\n", r.Tag, r.Error)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", r.Tag, _formatValue(r.Value))
+		}
+	}
+	return w.Flush()
+}
+
+func (c *cli)_write(ctx context.Context, args []string) error {
+	/*
+	The values go in as typed, the client parses them for the tag's
+	type. What the tag holds afterwards is read back and printed
+	*/
+	flags := c._flags("write", "tag value...")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return errUsage
+	}
+	tag := flags.Arg(0)
+	values := make([]interface{}, flags.NArg()-1)
+	for i, v := range flags.Args()[1:] {
+		values[i] = v
+	}
+	plc, err := c._connect()
+	if err != nil {
+		return err
+	}
+	if err := plc.WriteContext(ctx, tag, values...); err != nil {
+		return err
+	}
+	results := c._readOnce(ctx, plc, []string{tag}, len(values))
+	if err := c._printResults(results, false); err != nil {
+		return err
+	}
+	return _failed(results)
+}
+
+func (c *cli)_time(ctx context.Context, args []string) error {
+	if len(args) == 0 || (args[0] == "get" && len(args) > 1) || (args[0] == "set" && len(args) > 2) ||
+		(args[0] != "get" && args[0] != "set") {
+		fmt.Fprintln(c.stderr, "usage: eipctl time get | time set [RFC 3339 time|now]")
+		return errUsage
+	}
+	if args[0] == "set" {
+		t := time.Now()
+		if len(args) == 2 && args[1] != "now" {
+			var err error
+			if t, err = time.Parse(time.RFC3339Nano, args[1]); err != nil {
+				fmt.Fprintf(c.stderr, "eipctl: %v\n", err)
+				return errUsage
+			}
+		}
+		plc, err := c._connect()
+		if err != nil {
+			return err
+		}
+		if err := plc.SetPLCTimeContext(ctx, t); err != nil {
+			return err
+		}
+	}
+	plc := c.plc
+	if plc == nil {
+		var err error
+		if plc, err = c._connect(); err != nil {
+			return err
+		}
+	}
+	plcTime, err := plc.GetPLCTimeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c._printJSON(map[string]interface{}{
+			"time": plcTime,
+			"offset_seconds": plcTime.Sub(time.Now()).Seconds(),
+		})
+	}
+	fmt.Fprintf(c.stdout, "%s (%s from this host)\n", plcTime.Format(time.RFC3339Nano),
+		plcTime.Sub(time.Now()).Round(time.Millisecond))
+	return nil
+}
+
+func (c *cli)_modules(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		fmt.Fprintln(c.stderr, "usage: eipctl modules")
+		return errUsage
+	}
+	plc, err := c._connect()
+	if err != nil {
+		return err
+	}
+	modules, err := plc.GetModulesContext(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c._printJSON(modules)
+	}
+	w := c._table("Slot", "Product", "Revision", "Serial", "Type", "Vendor")
+	for _, m := range modules {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%08X\t%s\t%s\n", m.Slot, m.ProductName, m.Revision, m.SerialNumber,
+			_deviceType(m.DeviceType), _vendor(m.VendorID))
+	}
+	return w.Flush()
+}
+
+func (c *cli)_table(columns ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return w
+}
+
+func (c *cli)_printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func _failed(results []readResult) error {
+	//# the errors were printed with the values, the exit status still has to say
+	failed := 0
+	for _, r := range results {
+		if len(r.Error) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tags failed", failed, len(results))
+	}
+	return nil
+}
+
+func _formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []interface{}:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = _formatValue(e)
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func _joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ",")
+}
+
+//# the ones found in a Logix chassis, the rest are shown by number
+var deviceTypes = map[uint16]string{
+	0x00: "Generic Device",
+	0x07: "General Purpose Discrete I/O",
+	0x0A: "General Purpose Analog I/O",
+	0x0C: "Communications Adapter",
+	0x0E: "Programmable Logic Controller",
+	0x10: "Position Controller",
+	0x13: "DC Drive",
+	0x22: "Managed Switch",
+	0x2B: "Generic Device (keyable)",
+}
+
+func _deviceType(code uint16) string {
+	if name, ok := deviceTypes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
+
+func _vendor(id uint16) string {
+	if id == 1 {
+		return "Rockwell Automation/Allen-Bradley"
+	}
+	return fmt.Sprintf("%d", id)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaronkoerner/telegrafPlugins/eip/eipsim"
+)
+
+func newSim(t *testing.T) (*eipsim.Server, []string) {
+	sim := eipsim.New()
+	sim.Modules = map[int]eipsim.Identity{
+		1: {VendorID: 1, DeviceType: 0x0C, Major: 11, Minor: 2, SerialNumber: 0x1111, ProductName: "1756-EN2T/D"},
+	}
+	if err := sim.AddType(eipsim.Type{Name: "Motor", Members: []eipsim.Member{
+		{Name: "Speed", Type: "REAL"},
+		{Name: "Running", Type: "BOOL"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []eipsim.Tag{
+		{Name: "Temp", Type: "REAL", Value: 21.5},
+		{Name: "Count", Type: "DINT", Value: 42},
+		{Name: "Values", Type: "DINT", Dims: []int{5}, Value: []int{1, 2, 3, 4, 5}},
+		{Name: "M1", Type: "Motor", Value: map[string]interface{}{"Speed": 10.0}},
+		{Name: "Program:Main.Step", Type: "DINT", Value: 3},
+	} {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr, err := sim.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	host, port, _ := net.SplitHostPort(addr.String())
+	return sim, []string{"-host", host, "-port", port}
+}
+
+func run(t *testing.T, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	status := _run(ctx, args, &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	_, conn := newSim(t)
+
+	for _, c := range []struct {
+		args []string
+		contains []string
+	}{
+		{[]string{"identity"}, []string{"Product:", "1756-L83E/B", "33.011", "00C0FFEE", "Programmable Logic Controller"}},
+		{[]string{"taglist"}, []string{"Tag", "Values", "DINT[5]", "M1", "Motor", "Program:Main.Step", "Read/Write"}},
+		{[]string{"taglist", "-type", "Motor"}, []string{"M1"}},
+		{[]string{"taglist", "-format", "csv", "-match", "m*"}, []string{"M1,Motor,,true,false,Read/Write", "M1.Speed,REAL"}},
+		{[]string{"read", "Temp"}, []string{"Temp", "21.5"}},
+		{[]string{"read", "-n", "3", "Values[1]"}, []string{"Values[1]", "[2 3 4]"}},
+		{[]string{"read", "Temp", "Count"}, []string{"Temp", "21.5", "Count", "42"}},
+		{[]string{"write", "Values[3]", "40", "50"}, []string{"Values[3]", "[40 50]"}},
+		{[]string{"write", "Count.0", "false"}, []string{"Count.0", "false"}},
+		{[]string{"time", "set", "2024-01-02T03:04:05Z"}, []string{"2024-01-02T03:04:05"}},
+		{[]string{"modules"}, []string{"Slot", "1756-EN2T/D", "11.002", "1756-L83E/B"}},
+	} {
+		status, stdout, stderr := run(t, append(conn, c.args...)...)
+		if status != 0 {
+			t.Errorf("%v: exit status %d: %s", c.args, status, stderr)
+			continue
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(stdout, s) {
+				t.Errorf("%v: %q missing from\n%s", c.args, s, stdout)
+			}
+		}
+	}
+	if _, stdout, _ := run(t, append(conn, "taglist", "-type", "Motor")...); strings.Contains(stdout, "Temp") {
+		t.Errorf("-type Motor listed other tags:\n%s", stdout)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	_, conn := newSim(t)
+
+	status, stdout, stderr := run(t, append(conn, "-json", "read", "Temp", "Missing")...)
+	if status != 1 {
+		t.Errorf("a tag that failed should exit with 1, got %d: %s", status, stderr)
+	}
+	var results []readResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("%v:\n%s", err, stdout)
+	}
+	if len(results) != 2 || results[0].Value != 21.5 || len(results[1].Error) == 0 {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	status, stdout, _ = run(t, append(conn, "-json", "modules")...)
+	var modules []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &modules); status != 0 || err != nil {
+		t.Fatalf("%d %v:\n%s", status, err, stdout)
+	}
+	if len(modules) != 2 || modules[0]["slot"] != 0.0 || modules[1]["product_name"] != "1756-EN2T/D" {
+		t.Errorf("unexpected modules %v", modules)
+	}
+
+	status, stdout, _ = run(t, append(conn, "-json", "taglist")...)
+	var tags []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &tags); status != 0 || err != nil || len(tags) != 5 {
+		t.Errorf("%d %v:\n%s", status, err, stdout)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	_, conn := newSim(t)
+	var stdout, stderr bytes.Buffer
+	//# cancelled like an interrupt would, a deadline would also bound the reads
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(250*time.Millisecond, cancel)
+	status := _run(ctx, append(conn, "-json", "read", "-watch", "50ms", "Count"), &stdout, &stderr)
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("expected a line per read, got\n%s", stdout.String())
+	}
+	for _, line := range lines {
+		var r readResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Tag != "Count" || r.Value != 42.0 {
+			t.Errorf("unexpected line %q: %v", line, err)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	sim, _ := newSim(t)
+	addr, err := sim.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr := run(t, "discover", "-address", addr.String(), "-wait", "200ms")
+	if status != 0 || !strings.Contains(stdout, "127.0.0.1") || !strings.Contains(stdout, "1756-L83E/B") {
+		t.Errorf("exit status %d: %s\n%s", status, stderr, stdout)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"identity"},
+		{"-host", "127.0.0.1", "read"},
+		{"-host", "127.0.0.1", "read", "-n", "2", "A", "B"},
+		{"-host", "127.0.0.1", "write", "Count"},
+		{"-host", "127.0.0.1", "time", "set", "yesterday"},
+		{"-host", "127.0.0.1", "taglist", "-format", "xml"},
+	} {
+		if status, _, _ := run(t, args...); status != 2 {
+			t.Errorf("%v: expected exit status 2, got %d", args, status)
+		}
+	}
+}
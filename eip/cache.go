@@ -0,0 +1,285 @@
+package eip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+With CacheDir set, what was learned about the controller survives a
+restart: the tag list, the data types found by reading tags (KnownTags)
+and the template definitions are kept as JSON, one file per controller
+	<CacheDir>/eip_<serial number>.json
+The serial is the controller's, asked for over the route, since List
+Identity answers for the bridge when there is one in front of it.
+The file also holds the project identity, the change detection
+attributes of the controller object, and is only used for the same
+project. It is loaded on the first connection and used straight away,
+while a second session reads the tag list again in the background;
+when that differs, the cached types and templates are dropped too
+Without a project identity (Micro800 has no change detection) there's
+no telling a stale file from a good one, so nothing is cached
+*/
+
+const revalidateTimeout = 5*time.Minute
+
+type diskCache struct {
+	Serial uint32 `json:"serial"`
+	Project string `json:"project"`
+	Saved time.Time `json:"saved"`
+	TagList []LGXTag `json:"tag_list"`
+	ProgramNames []string `json:"program_names"`
+	KnownTags map[string]cachedType `json:"known_tags"`
+	Templates map[uint16]*Template `json:"templates"`
+}
+
+type cachedType struct {
+	DataType byte `json:"data_type"`
+	DataLen int `json:"data_len"`
+}
+
+func (plc *PLC)_cacheFile(serial uint32) string {
+	return filepath.Join(plc.CacheDir, fmt.Sprintf("eip_%08X.json", serial))
+}
+
+func (plc *PLC)_loadCache() {
+	/*
+	Called once the first session is up, fills the caches from disk
+	when the file is for this controller and project
+	*/
+	plc.cacheLoaded = true
+	project, err := plc._projectIdentity()
+	if err != nil {
+		plc._noChangeDetection(err)
+	}
+	plc.cacheMu.Lock()
+	plc.project = project
+	plc.cacheMu.Unlock()
+	if len(project) == 0 {
+		plc._log().Infof("%s: no project identity, not caching", plc._logContext())
+		return
+	}
+	id, err := plc._controllerIdentity()
+	if err == nil && id.SerialNumber == 0 {
+		err = fmt.Errorf("serial number 0")
+	}
+	if err != nil {
+		plc._log().Warnf("%s: no controller serial number to find the cache by: %v", plc._logContext(), err)
+		return
+	}
+	serial := id.SerialNumber
+	file := plc._cacheFile(serial)
+	plc.cacheMu.Lock()
+	plc.cacheSerial = serial
+	plc.cacheMu.Unlock()
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	var cache diskCache
+	if err == nil {
+		err = json.Unmarshal(data, &cache)
+	}
+	if err != nil {
+		plc._log().Warnf("%s: ignoring the cache: %v", plc._logContext("file", file), err)
+		return
+	}
+	if cache.Serial != serial || cache.Project != project {
+		plc._log().Infof("%s: the cache is for another project", plc._logContext("file", file))
+		return
+	}
+
+	plc.cacheMu.Lock()
+	plc.TagList = cache.TagList
+	plc.ProgramNames = cache.ProgramNames
+	if plc.TagList != nil {
+		plc.symbols = _symbolTable(plc.TagList)
+	}
+	for name, t := range cache.KnownTags {
+		plc.KnownTags[name] = TagMap{dataType: t.DataType, dataLen: t.DataLen}
+	}
+	for instance, tmpl := range cache.Templates {
+		plc.templates[instance] = tmpl
+	}
+	plc.cacheMu.Unlock()
+	plc._log().Infof("%s: loaded %d tags, %d types and %d templates saved %v", plc._logContext("file", file),
+		len(cache.TagList), len(cache.KnownTags), len(cache.Templates), cache.Saved.Format(time.RFC3339))
+
+	if cache.TagList != nil {
+		plc._startRevalidation(cache.TagList)
+	}
+}
+
+func (plc *PLC)_saveCache() error {
+	/*
+	Writes the caches out when they changed since the last save,
+	through a temporary file so a reader never sees half of it
+	*/
+	if len(plc.CacheDir) == 0 {
+		return nil
+	}
+	plc.saveMu.Lock()
+	defer plc.saveMu.Unlock()
+
+	plc.cacheMu.Lock()
+	if !plc.cacheDirty || plc.cacheSerial == 0 {
+		plc.cacheMu.Unlock()
+		return nil
+	}
+	file := plc._cacheFile(plc.cacheSerial)
+	cache := diskCache{
+		Serial: plc.cacheSerial,
+		Project: plc.project,
+		Saved: time.Now(),
+		TagList: plc.TagList,
+		ProgramNames: plc.ProgramNames,
+		KnownTags: make(map[string]cachedType),
+		Templates: make(map[uint16]*Template),
+	}
+	for name, t := range plc.KnownTags {
+		cache.KnownTags[name] = cachedType{DataType: t.dataType, DataLen: t.dataLen}
+	}
+	for instance, tmpl := range plc.templates {
+		cache.Templates[instance] = tmpl
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	plc.cacheDirty = false
+	plc.cacheMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(plc.CacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(plc.CacheDir, ".eip_cache_*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (plc *PLC)_startRevalidation(cached []LGXTag) {
+	/*
+	Reads the tag list over a session of its own so reads carry on
+	meanwhile. Everything the background needs from plc is taken here,
+	while the caller holds the connection lock
+	*/
+	scan := &PLC{
+		IPAddress: plc.IPAddress,
+		Port: plc.Port,
+		ProcessorSlot: plc.ProcessorSlot,
+		Route: plc.Route,
+		Micro800: plc.Micro800,
+		Unconnected: plc.Unconnected,
+		UnconnectedFallback: plc.UnconnectedFallback,
+		LocalAddress: plc.LocalAddress,
+		Timeout: plc.Timeout,
+		Dialer: plc.Dialer,
+		Logger: plc._log(),
+	}
+	logContext := plc._logContext()
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	plc.cacheMu.Lock()
+	plc.stopRevalidation = cancel
+	plc.cacheMu.Unlock()
+	plc.revalidation.Add(1)
+	go func() {
+		defer plc.revalidation.Done()
+		defer cancel()
+		plc._revalidate(ctx, scan, logContext, cached)
+	}()
+}
+
+func (plc *PLC)_revalidate(ctx context.Context, scan *PLC, logContext string, cached []LGXTag) {
+	/*
+	Replaces the cached tag list when the controller's differs
+	*/
+	if err := scan.Init(); err != nil {
+		plc._log().Warnf("%s: revalidating the cache: %v", logContext, err)
+		return
+	}
+	defer scan.Close()
+	tagList, err := scan.GetTagListContext(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			plc._log().Warnf("%s: revalidating the cache: %v", logContext, err)
+		}
+		return
+	}
+	if reflect.DeepEqual(tagList, cached) {
+		plc._log().Debugf("%s: the cached tag list is current", logContext)
+		return
+	}
+
+	plc._log().Infof("%s: the cached tag list was stale, %d tags now", logContext, len(tagList))
+	plc.cacheMu.Lock()
+	plc.TagList = tagList
+	plc.ProgramNames = scan.ProgramNames
+	plc.symbols = _symbolTable(tagList)
+	plc.KnownTags = _currentTypes(plc.KnownTags, cached, tagList)
+	plc.templates = make(map[uint16]*Template)
+	plc.cacheDirty = true
+	plc.cacheMu.Unlock()
+	if err := plc._saveCache(); err != nil {
+		plc._log().Warnf("%s: saving the cache: %v", logContext, err)
+	}
+}
+
+func _currentTypes(knownTags map[string]TagMap, old []LGXTag, current []LGXTag) map[string]TagMap {
+	/*
+	Keeps the types of tags whose symbol type didn't change, a read in
+	progress may be counting on them. Templates can change behind the
+	same instance, those all go
+	*/
+	symbolTypes := func(tagList []LGXTag) map[string]uint16 {
+		types := make(map[string]uint16)
+		for _, t := range tagList {
+			types[strings.ToUpper(t.TagName)] = t.SymbolType
+		}
+		return types
+	}
+	before, after := symbolTypes(old), symbolTypes(current)
+	kept := make(map[string]TagMap)
+	for name, t := range knownTags {
+		path, err := ParseTagPath(name)
+		if err != nil {
+			continue
+		}
+		root := strings.ToUpper(path.Root())
+		if was, ok := before[root]; ok && after[root] == was {
+			kept[name] = t
+		}
+	}
+	return kept
+}
+
+func (plc *PLC)_waitRevalidation(cancel bool) {
+	plc.cacheMu.Lock()
+	stop := plc.stopRevalidation
+	plc.cacheMu.Unlock()
+	if cancel && stop != nil {
+		stop()
+	}
+	plc.revalidation.Wait()
+}
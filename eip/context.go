@@ -0,0 +1,101 @@
+package eip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*
+Every public method has a Context variant. The context of the call in
+progress is kept on the PLC while it holds the lock, like Offset, and
+bounds the socket deadlines: a context that is cancelled or runs out
+unblocks the read or write that's waiting on the controller
+*/
+
+const defaultTimeout = 2*time.Second
+const defaultGatherTimeout = 10*time.Second
+
+func (plc *PLC)_withContext(ctx context.Context) func() {
+	/*
+	Takes the lock for one call, use as
+		defer plc._withContext(ctx)()
+	*/
+	plc.mu.Lock()
+	plc.ctx = ctx
+	return func() {
+		plc.ctx = nil
+		plc.mu.Unlock()
+	}
+}
+
+func (plc *PLC)_context() context.Context {
+	if plc.ctx == nil {
+		return context.Background()
+	}
+	return plc.ctx
+}
+
+func (plc *PLC)_deadline() time.Time {
+	/*
+	Timeout per request, cut short by the context's deadline
+	*/
+	timeout := time.Duration(plc.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := plc._context().Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+func _watchContext(ctx context.Context, conn net.Conn) func() {
+	/*
+	Moves the socket deadline into the past when ctx is done, which
+	makes the blocked Read or Write return right away
+	Returns the function that stops watching
+	*/
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func _contextError(op string, err error) error {
+	/*
+	A deadline that ran out is a timeout like any other, but callers
+	can still tell it apart with errors.Is(err, context.DeadlineExceeded)
+	*/
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s: %w", ErrTimeout, op, err)
+	}
+	return fmt.Errorf("eip: %s: %w", op, err)
+}
+
+func (plc *PLC)_gatherTimeout(now time.Time) time.Duration {
+	/*
+	Telegraf doesn't tell inputs their interval, so unless GatherTimeout
+	is set, a collection gets as long as it's been since the previous one
+	*/
+	if plc.GatherTimeout > 0 {
+		return time.Duration(plc.GatherTimeout)
+	}
+	timeout := defaultGatherTimeout
+	if !plc.lastGather.IsZero() && now.After(plc.lastGather) {
+		timeout = now.Sub(plc.lastGather)
+	}
+	plc.lastGather = now
+	return timeout
+}
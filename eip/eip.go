@@ -2,16 +2,21 @@ package eip
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"strconv"
 	"net"
+	"sync"
 	"time"
 	
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/plugins/inputs"
 )
 
@@ -19,13 +24,30 @@ type PLC struct {
 	TagsToRead []string `toml:"TagsToRead"`
 	IPAddress string `toml:"IPAddress"`
 	ProcessorSlot byte `toml:"ProcessorSlot"`
-	Micro800 bool
+	Route string `toml:"Route"`
+	Protocol string `toml:"Protocol"`
+	Micro800 bool `toml:"Micro800"`
+	Unconnected bool `toml:"Unconnected"`
+	UnconnectedFallback bool `toml:"UnconnectedFallback"`
+	LocalAddress string `toml:"LocalAddress"`
+	Timeout config.Duration `toml:"Timeout"`
+	GatherTimeout config.Duration `toml:"GatherTimeout"`
+	ReconnectMinDelay config.Duration `toml:"ReconnectMinDelay"`
+	ReconnectMaxDelay config.Duration `toml:"ReconnectMaxDelay"`
+	Dialer Dialer `toml:"-"`
+	Window int `toml:"Window"`
+	SymbolInstances bool `toml:"SymbolInstances"`
+	CacheDir string `toml:"CacheDir"`
+	ChangeDetectionInterval config.Duration `toml:"ChangeDetectionInterval"`
+	Log telegraf.Logger `toml:"-"` //# set by Telegraf
+	Logger Logger `toml:"-"` //# for the standalone client, used when Log isn't set
 	Port uint16
 	VendorID uint16
 	Context uint64
 	ContextPointer uint32
 	Socket net.Conn
 	SocketConnected bool
+	ForwardOpened bool
 	OTNetworkConnectionID uint32
 	SessionHandle uint32
 	SessionRegistered bool
@@ -34,10 +56,36 @@ type PLC struct {
 	SequenceCounter uint16
 	Offset uint16
 	KnownTags map[string]TagMap
+	templates map[uint16]*Template
 	TagList []LGXTag
 	ProgramNames []string
+	symbols map[string]LGXTag //# TagList by upper case tag name
 	StructIdentifier uint16
 	CIPTypes map[byte]CIPTypesStruct
+	Identity Identity
+	Reconnects int //# sessions recovered after being lost
+	pccc *PCCC
+	//# mu serializes everything that goes over the connection, only
+	//# one request can be outstanding and Offset/SequenceCounter
+	//# belong to it. cacheMu guards KnownTags, templates, TagList,
+	//# ProgramNames, symbols and the disk cache state
+	mu sync.Mutex
+	cacheMu sync.RWMutex
+	ctx context.Context //# context of the call holding mu
+	lastGather time.Time
+	sessionLost bool //# the last session died instead of being closed
+	failures int //# connection attempts failed in a row
+	nextAttempt time.Time
+	cacheLoaded bool //# the disk cache was looked at, once per PLC
+	cacheDirty bool //# the caches changed since they were saved
+	cacheSerial uint32 //# controller the disk cache is for
+	project string //# change detection attributes, see _projectIdentity
+	saveMu sync.Mutex
+	revalidation sync.WaitGroup
+	stopRevalidation context.CancelFunc
+	changeDetection bool //# the controller has the change detection attributes, until it says otherwise
+	lastProjectCheck time.Time
+	projectEvents []projectEvent //# changes not reported by Gather yet
 }
 
 var PLCConfig = `
@@ -47,6 +95,46 @@ var PLCConfig = `
 	"tag3"]
   IPAddress = "192.168.14.169"
   ProcessSlot = 3
+  ## Route from the module at IPAddress to the controller as port,link
+  ## pairs, overrides ProcessorSlot. Example: controller in slot 3 of a
+  ## remote chassis reached through the Ethernet module at 192.168.5.10
+  # Route = "1,0,2,192.168.5.10,1,3"
+  ## Micro800 controllers are detected automatically, set this to force it
+  # Micro800 = false
+  ## Send every request as an unconnected message instead of opening
+  ## a class 3 connection, or only do so when the Forward Open fails
+  # Unconnected = false
+  # UnconnectedFallback = false
+  ## "logix" for tag based controllers, "pccc" for SLC 500, PLC-5 and
+  ## MicroLogix, where TagsToRead are data table addresses like N7:0
+  # Protocol = "logix"
+  ## Source address to connect from when the collector has more than
+  ## one interface
+  # LocalAddress = "10.0.0.5"
+  ## How long to wait for each reply from the controller
+  # Timeout = "2s"
+  ## Upper bound for a whole collection, by default the time since the
+  ## previous collection, which is the collection interval
+  # GatherTimeout = "0s"
+  ## When the controller goes away the client reconnects, waiting
+  ## twice as long after every failed attempt, up to the max delay
+  # ReconnectMinDelay = "500ms"
+  # ReconnectMaxDelay = "30s"
+  ## How many requests can be waiting for a reply at once over the
+  ## connection, more hides the round trip time on slow links
+  # Window = 1
+  ## Address tags by their symbol instance instead of their name, which
+  ## fits more tags in each request. Needs the tag list, which is read
+  ## on the first collection and again when the project changes; the
+  ## project is then checked every collection, not every
+  ## ChangeDetectionInterval
+  # SymbolInstances = false
+  ## Keep the tag list, tag types and structure definitions in this
+  ## directory so a restart doesn't have to read them all again
+  # CacheDir = "/var/lib/telegraf/eip"
+  ## How often to check whether the project was changed or downloaded,
+  ## which flushes the caches and emits a project_changed metric
+  # ChangeDetectionInterval = "1m"
 `
 
 func (plc *PLC) SampleConfig() string {
@@ -58,16 +146,57 @@ func (plc *PLC) Description() string {
 }
 
 func (plc *PLC) Gather(acc telegraf.Accumulator) error {
-	values := plc.MultiRead(plc.TagsToRead)
+	var responses []Response
+	var err error
 
-	fields := make(map[string]interface{})
-	tags := make(map[string]string)
-	
-	for n, t := range plc.TagsToRead {
-		fields["value"] = values[n]
-		tags["TagName"] = t
+	//# a collection that's still running when the next one is due is cut short
+	plc.mu.Lock()
+	timeout := plc._gatherTimeout(time.Now())
+	if plc.pccc == nil && plc.Protocol == "pccc" {
+		plc.pccc = &PCCC{PLC: plc}
+	}
+	plc.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if plc.Protocol == "pccc" {
+		var values []interface{}
+		values, err = plc.pccc.MultiReadContext(ctx, plc.TagsToRead)
+		for n, v := range values {
+			r := Response{TagName: plc.TagsToRead[n], Value: v}
+			if e, ok := v.(error); ok {
+				r.Value, r.Err = nil, e
+			}
+			responses = append(responses, r)
+		}
+	} else {
+		plc._pollProject(ctx)
+		if plc.SymbolInstances {
+			plc._loadSymbols(ctx)
+		}
+		responses, err = plc.MultiReadContext(ctx, plc.TagsToRead)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, r := range responses {
+		if r.Err != nil {
+			acc.AddError(r.Err)
+			continue
+		}
+		fields := map[string]interface{}{"value": r.Value}
+		tags := map[string]string{"TagName": r.TagName}
 		acc.AddFields("eip", fields, tags)
 	}
+	for _, e := range plc._takeProjectEvents() {
+		fields := map[string]interface{}{"detected_by": e.detectedBy}
+		tags := map[string]string{"controller": plc.IPAddress}
+		acc.AddFields("project_changed", fields, tags, e.at)
+	}
+	if err := plc._saveCache(); err != nil {
+		plc._log().Warnf("%s: saving the cache: %v", plc._logContext(), err)
+	}
 
 	return nil
 }
@@ -282,6 +411,7 @@ var cipErrorCodes = map[uint16]string{
 	 0x2A: "Group 2 only server general failure",
 	 0x2B: "Unknown Modbus error",
 	 0x2C: "Attribute not gettable",
+	 0xFF: "General error",
 }
 
 type TagMap struct {
@@ -298,13 +428,67 @@ type CIPTypesStruct struct {
 type LGXTag struct {
 	InstanceID uint32
 	DataType byte
+	SymbolType uint16 //# all of it, for structures bits 0-11 are the template instance
 	BitPosition byte
 	ArrayDims byte
+	Dimensions []int //# size of each of the ArrayDims dimensions, BOOL arrays count DWORDs
+	ElementSize int //# bytes of one element
+	ExternalAccess Access
+	TemplateInstance uint16 //# template of a structure, 0 for atomic types
 	IsStruct bool
 	IsSystem bool
 	TagName string
 }
 
+//# External Access of a tag (Symbol Object attribute 10)
+type Access byte
+
+const (
+	AccessReadWrite Access = 0
+	AccessReadOnly Access = 2
+	AccessNone Access = 3
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessReadWrite:
+		return "Read/Write"
+	case AccessReadOnly:
+		return "Read Only"
+	case AccessNone:
+		return "None"
+	}
+	return fmt.Sprintf("Access(%d)", byte(a))
+}
+
+func (t LGXTag) Readable() bool {
+	return t.ExternalAccess != AccessNone
+}
+
+func (t LGXTag) Elements() int {
+	/*
+	Elements of the whole tag, 1 when it isn't an array, bits for a
+	BOOL array
+	*/
+	n := 1
+	for _, d := range t.Dimensions {
+		n *= d
+	}
+	if t.DataType == 0xD3 && len(t.Dimensions) > 0 {
+		n *= 32
+	}
+	return n
+}
+
+type Response struct {
+	TagName string
+	Value interface{} //# nil when Err is set
+	CIPType byte //# type code the value came with
+	Elements int
+	Status byte //# general status of the tag's own reply, or of its packet when that failed
+	Err error
+}
+
 type RegSession struct {
 	EIPCommand uint16 //#(H)Register Session Command   (Vol 2 2-3.2)
 	EIPLength uint16 //#(H)Lenght of Payload		  (2-3.3)
@@ -362,6 +546,18 @@ type CIPForwardClose struct {
    CIPOriginatorSerialNumber uint32
 }
 
+type UnconnectedSend struct {
+   CIPService byte
+   CIPPathSize byte
+   CIPClassType byte
+   CIPClass byte
+   CIPInstanceType byte
+   CIPInstance byte
+   CIPPriority byte
+   CIPTimeoutTicks byte
+   CIPMessageSize uint16
+}
+
 type EIPSendRRDataHeader struct {
 //'<HHIIQIIHHHHHH',
 	EIPCommand uint16
@@ -419,12 +615,23 @@ type Attribute struct {
 	TimeAttribute uint16
 }
 
-func (plc *PLC)Init() {
-	plc.IPAddress = "192.168.14.169"
-	plc.ProcessorSlot = 3
-	plc.Micro800 = false
-	plc.Port = 44818
-	plc.VendorID = 0x1337
+func (plc *PLC)Init() error {
+	/*
+	Fills in the defaults for anything that wasn't configured
+	Telegraf calls this once the config is loaded
+	*/
+	plc.mu.Lock()
+	defer plc.mu.Unlock()
+	return plc._init()
+}
+
+func (plc *PLC)_init() error {
+	if plc.Port == 0 {
+		plc.Port = 44818
+	}
+	if plc.VendorID == 0 {
+		plc.VendorID = 0x1337
+	}
 	plc.Context = 0x00
 	plc.ContextPointer = 0
 	//plc.Socket = socket.socket()
@@ -437,7 +644,15 @@ func (plc *PLC)Init() {
 	plc.OriginatorSerialNumber = 42
 	plc.SequenceCounter = 1
 	plc.Offset = 0
+	plc.sessionLost = false
+	plc.failures = 0
+	plc.nextAttempt = time.Time{}
+	plc.changeDetection = plc.Protocol != "pccc"
+	plc.projectEvents = nil
+	plc.cacheMu.Lock()
 	plc.KnownTags = make(map[string]TagMap)
+	plc.templates = make(map[uint16]*Template)
+	plc.cacheMu.Unlock()
 	plc.StructIdentifier = 0x0fCE
 	plc.CIPTypes = make(map[byte]CIPTypesStruct)
 	plc.CIPTypes[160] = CIPTypesStruct{dataLen: 0, dataType: "STRUCT", format: 'B'}
@@ -455,164 +670,279 @@ func (plc *PLC)Init() {
 	plc.CIPTypes[211] = CIPTypesStruct{dataLen: 4, dataType: "DWORD", format: 'I'}
 	plc.CIPTypes[218] = CIPTypesStruct{dataLen: 0, dataType: "STRING", format: 'B'}
 
+	if plc.Protocol != "" && plc.Protocol != "logix" && plc.Protocol != "pccc" {
+		return fmt.Errorf("eip: unknown protocol %q", plc.Protocol)
+	}
+	if _, err := ParseRoute(plc.Route); err != nil {
+		return err
+	}
+	if _, err := plc._dialer(); err != nil {
+		return err
+	}
+	return nil
 }
 
-func (plc *PLC)_readTag(tag string, elements uint16) []interface{} {
-	var result []interface{}
+func (plc *PLC)_readTag(tag string, elements uint16) ([]interface{}, error) {
 	plc.Offset = 0
 	
-	if !plc._connect(){
-		return nil
+	if err := plc._connect(); err != nil {
+		return nil, err
 	}
 	
-	var tagData []byte
-	var readRequest []byte
-	var eipHeader []byte
-	var status uint16
-	var err string
-
-	t,b,i := _tagNameParser(tag, 0)
-	plc._initialRead(t, b)
+	path, err := ParseTagPath(tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := plc._checkSymbol(path, int(elements)); err != nil {
+		return nil, err
+	}
+	if err := plc._initialRead(path); err != nil {
+		return nil, err
+	}
 
-	datatype := plc.KnownTags[b].dataType
+	datatype := plc._knownTag(path._base().String()).dataType
 	bitCount := plc.CIPTypes[datatype].dataLen * 8
+	isBoolArray := datatype == 211 && !plc.Micro800
+	count := elements
 	
-	if datatype == 211 {
-		//# bool array
-		tagData = plc._buildTagIOI(tag, true)
-		words := _getWordCount(uint32(i), elements, bitCount)
-		readRequest = plc._addReadIOI(tagData, words)
-	} else if BitofWord(t) {
+	if isBoolArray {
+		//# bool array, read from the word holding the first bit
+		count = _getWordCount(uint32(path._lastIndex()%bitCount), elements, bitCount)
+	} else if path.Bit >= 0 {
 		//# bits of word
-		split_tag := strings.Split(tag, ".")
-		bitPos, _ := strconv.Atoi(split_tag[len(split_tag)-1])
-
-		tagData = plc._buildTagIOI(tag, false)
-		words := _getWordCount(uint32(bitPos), elements, bitCount)
-
-		readRequest = plc._addReadIOI(tagData, words)
-	} else {
-		//# everything else
-		tagData = plc._buildTagIOI(tag, false)
-		readRequest = plc._addReadIOI(tagData, elements)
+		count = _getWordCount(uint32(path.Bit), elements, bitCount)
 	}
 	
-
-	eipHeader = plc._buildEIPHeader(len(readRequest))
-	retData := plc._getBytes(append(eipHeader, readRequest...))
-
-	if len(retData)>= 48 {
-		status = binary.LittleEndian.Uint16(retData[48:])
-	} else {
-		status = 1
+	tagData, byInstance := plc._tagIOI(path, isBoolArray)
+	retData, err := plc._readIOI(tag, tagData, count)
+	stale := false
+	if byInstance && errors.Is(err, ErrTagNotFound) {
+		//# the instance may be gone after a download, try the name
+		retData, err = plc._readIOI(tag, path._ioi(isBoolArray), count)
+		stale = err == nil
 	}
-	//fmt.Printf("%x\n", retData)
-	if status == 0 || status == 6 {
-		result = plc._parseReply(tag, elements, retData)
-	} else {
-		if code, ok := cipErrorCodes[status]; ok {
-			err = code
-		} else {
-			err = "Unknown error"
+	if err != nil {
+		return nil, err
+	}
+	values, err := plc._parseReply(tag, path, elements, retData)
+	if stale {
+		plc._symbolsChanged()
+	}
+	return values, err
+}
+
+func (plc *PLC)_readIOI(tag string, tagIOI []byte, elements uint16) ([]byte, error) {
+	/*
+	Read Tag, then Read Tag Fragmented from where the reply stopped
+	while the controller says there's more (0x06). The pieces are put
+	together into one reply, as if it had all fit
+	*/
+	retData, err := plc._request(plc._addReadIOI(tagIOI, elements))
+	if err != nil {
+		return nil, err
+	}
+	if err := _replyError(retData, 0x6B, tag); err != nil {
+		return nil, err
+	}
+	reply := append([]byte(nil), retData...)
+	header := 4+2*int(reply[3])+_typeSize(_replyData(reply))
+	for reply[2] == 0x06 && len(reply) > header {
+		plc.Offset = uint16(len(reply)-header)
+		retData, err = plc._request(plc._addPartialReadIOI(tagIOI, elements))
+		if err != nil {
+			return nil, err
 		}
-		result = append(result, err)
+		if err := _replyError(retData, 0x6B, tag); err != nil {
+			return nil, err
+		}
+		data := _replyData(retData)
+		if len(data) <= _typeSize(data) {
+			break
+		}
+		reply = append(reply, data[_typeSize(data):]...)
+		reply[2] = retData[2]
 	}
-	return result
-	
+	plc.Offset = 0
+	return reply, nil
 }
 
-func (plc *PLC)_multiRead(args []string) []interface{} {
+func _typeSize(data []byte) int {
+	//# the type in front of read data, structures add their handle
+	if len(data) > 0 && data[0] == 0xA0 {
+		return 4
+	}
+	return 2
+}
+
+func (plc *PLC)_multiRead(args []string) ([]Response, error) {
 	/*
 	Processes the multiple read request
+	Returns a Response for every tag, in the order they were asked for
 	*/
-	var result []interface{}
-	var serviceSegments [][]byte
-	var segments []byte
-	var status uint16
-	var packetSize int
-	var offset int
-	tagCount := len(args)
-
-	if !plc._connect() {
-		return nil
+	result, byInstance, err := plc._multiReadBatches(args, true)
+	if err != nil {
+		return nil, err
 	}
-	offsets := new(bytes.Buffer)
-	readRequest := new(bytes.Buffer)
 
-	for i:=0; i<tagCount; i++ {
-		t,_,_ := _tagNameParser(args[i], 0)
-		//plc._initialRead(t, b)
+	//# tags whose instance is gone may have moved after a download
+	var stale []int
+	var tags []string
+	for i := range result {
+		if byInstance[i] && errors.Is(result[i].Err, ErrTagNotFound) {
+			stale = append(stale, i)
+			tags = append(tags, args[i])
+		}
+	}
+	if len(stale) == 0 {
+		return result, nil
+	}
+	retried, _, err := plc._multiReadBatches(tags, false)
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for k, i := range stale {
+		result[i] = retried[k]
+		changed = changed || retried[k].Err == nil
+	}
+	if changed {
+		plc._symbolsChanged()
+	}
+	return result, nil
+}
 
-		tagIOI := plc._buildTagIOI(t, false)
-		readIOI := plc._addReadIOI(tagIOI, 1)
-		serviceSegments = append(serviceSegments, readIOI)
+func (plc *PLC)_multiReadBatches(args []string, useInstances bool) ([]Response, []bool, error) {
+	/*
+	Reads the tags as planned, by symbol instance where there is one
+	when useInstances is set. Tags whose shared read failed are read
+	again one by one
+	Also returns which tags went by instance
+	*/
+	result := make([]Response, len(args))
+	byInstance := make([]bool, len(args))
+	if err := plc._connect(); err != nil {
+		return nil, nil, err
 	}
-	
+	all := make([]int, len(args))
+	for i := range all {
+		all[i] = i
+	}
+	reads := plc._planReads(args, all, result, useInstances, true)
+	failed, err := plc._sendReads(args, reads, result, byInstance)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(failed) > 0 {
+		reads = plc._planReads(args, failed, result, useInstances, false)
+		if _, err := plc._sendReads(args, reads, result, byInstance); err != nil {
+			return nil, nil, err
+		}
+	}
+	return result, byInstance, nil
+}
+
+func (plc *PLC)_sendReads(args []string, reads []plannedRead, result []Response, byInstance []bool) ([]int, error) {
+	/*
+	Packs the reads into as few Multiple Service Packets as they fit
+	in, the requests and their replies, and fills in result
+	Returns the tags of the shared reads that failed on their own
+	*/
+	var requests [][]byte
+	var batches [][]plannedRead
 
 	multiHeader := plc._buildMultiServiceHeader()
-	eipHeader := plc._buildEIPHeader(len(multiHeader)) //just for size calc
-
-	currentCount := 0
-	for totalCount := 0; totalCount<tagCount; currentCount=0 {
-		offsets.Reset()
-		readRequest.Reset()
-		segments = nil
-		packetSize = len(eipHeader)+len(multiHeader)+2
-		
-		for i:=totalCount; i<tagCount; i++ { //512 bytes max packet (256 words)
-			packetSize += 2 + len(serviceSegments[i])
-			if packetSize < 512 {
-				segments = append(segments, serviceSegments[i]...)
-				currentCount++
-			} else { //packet too large, need to stop
-				break
+	overhead := plc._requestOverhead()
+
+	for start := 0; start < len(reads); {
+		packetSize := overhead+len(multiHeader)+2
+		replySize := 4+2
+		end := start
+		for ; end < len(reads); end++ { //512 bytes max packet (256 words)
+			packetSize += 2 + len(reads[end].service)
+			replySize += reads[end].replySize
+			if end > start && (packetSize >= 512 || replySize > maxReplySize) {
+				break //# packet too large, need to stop
 			}
 		}
-
-		offset = 2+2*currentCount //2 bytes for service count + 2 per offset value 
-		for i:= 0; i < currentCount; i++ {
-			binary.Write(offsets, binary.LittleEndian, uint16(offset))
-			offset += len(serviceSegments[i+totalCount]) //in bytes
+		batch := reads[start:end]
+		start = end
+
+		readRequest := new(bytes.Buffer)
+		readRequest.Write(multiHeader)
+		binary.Write(readRequest, binary.LittleEndian, uint16(len(batch)))
+		offset := 2+2*len(batch) //2 bytes for service count + 2 per offset value
+		for _, read := range batch {
+			binary.Write(readRequest, binary.LittleEndian, uint16(offset))
+			offset += len(read.service) //in bytes
 		}
-		totalCount += currentCount
-		
-		binary.Write(readRequest, binary.LittleEndian, multiHeader)
-		binary.Write(readRequest, binary.LittleEndian, uint16(currentCount))
-		binary.Write(readRequest, binary.LittleEndian, offsets.Bytes())
-		binary.Write(readRequest, binary.LittleEndian, segments)
-
-		eipHeader = plc._buildEIPHeader(readRequest.Len())
-		
-		retData := plc._getBytes(append(eipHeader, readRequest.Bytes()...))
-		
-		if retData != nil && len(retData)>48 {
-			status = binary.LittleEndian.Uint16(retData[48:])
-		} else {
-			status = 0x01
+		for _, read := range batch {
+			readRequest.Write(read.service)
 		}
+		requests = append(requests, readRequest.Bytes())
+		batches = append(batches, batch)
+	}
 
-		if status == 0 {
-			result = append(result, plc._multiParser(retData)...)
-		} else {
-			var err string
-			if code, ok := cipErrorCodes[status]; ok {
-				err = code
-			} else {
-				err = "Unknown error"
+	replies, errs, err := plc._pipeline(requests)
+	if err != nil {
+		return nil, err
+	}
+	var failed []int
+	for n, retData := range replies {
+		batch := batches[n]
+		for _, read := range batch {
+			for _, t := range read.targets {
+				byInstance[t.arg] = read.byInstance
+			}
+		}
+		//# a packet that failed as a whole fails each of its tags
+		batchErr := errs[n]
+		var status byte
+		if batchErr == nil && len(retData) < 6 {
+			batchErr = fmt.Errorf("eip: Multiple Service Packet: reply too short (%d bytes)", len(retData))
+		}
+		//# 0x1E means some of the services failed, they carry their own status
+		if batchErr == nil && retData[2] != 0 && retData[2] != 0x1E {
+			status = retData[2]
+			batchErr = _cipError(retData, 0x02, "Multiple Service Packet")
+		}
+		var services [][]byte
+		if batchErr == nil {
+			services, batchErr = plc._multiParser(retData, len(batch))
+		}
+		for k, read := range batch {
+			if batchErr == nil && services[k] != nil {
+				if !plc._scatter(read, services[k], args, result) && read.shared {
+					for _, t := range read.targets {
+						failed = append(failed, t.arg)
+					}
+				}
+				continue
+			}
+			for _, t := range read.targets {
+				response := Response{TagName: args[t.arg], Status: status}
+				if batchErr != nil {
+					response.Err = fmt.Errorf("%s: %w", args[t.arg], batchErr)
+				} else {
+					response.Err = fmt.Errorf("eip: Multiple Service Packet: reply for %s is out of bounds", args[t.arg])
+				}
+				result[t.arg] = response
 			}
-			result = append(result, "Multi-read failed: " + err)
 		}
 	}
-	
-	return result
+	sort.Ints(failed)
+	return failed, nil
 }
 
-func (plc *PLC)_getPLCTime() time.Time {
+func (plc *PLC)_getPLCTime() (time.Time, error) {
 	/*
 	Requests the PLC clock time
 	*/ 
-	if !plc._connect() {
-		return time.Time{} //can't return nil for time.Time
+	if err := plc._connect(); err != nil {
+		return time.Time{}, err //can't return nil for time.Time
+	}
+	if plc.Micro800 {
+		//# no WallClock object on Micro800
+		return time.Time{}, &CIPError{Service: 0x03, Class: 0x8B, Path: "WallClock", Status: 0x08}
 	}
 	ap := Attribute {
 		AttributeService: 0x03,
@@ -626,119 +956,134 @@ func (plc *PLC)_getPLCTime() time.Time {
 	}
 	buf := new(bytes.Buffer)	
 	if err := binary.Write(buf, binary.LittleEndian, ap); err != nil {
-		fmt.Println(err)
-		return time.Time{}
+		return time.Time{}, err
 	} 
 
-	eipHeader := plc._buildEIPHeader(buf.Len())
-	request := append(eipHeader, buf.Bytes()...)
-	retData := plc._getBytes(request)
-	
-	var status uint16
-	if len(retData) >= 48 {
-		status = binary.LittleEndian.Uint16(retData[48:])
-	} else {
-		status = 0x01
+	retData, err := plc._request(buf.Bytes())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := _replyError(retData, 0x8B, "WallClock"); err != nil {
+		return time.Time{}, err
+	}
+	//# attribute count, attribute ID and attribute status come before the value
+	data := _replyData(retData)
+	if len(data) < 14 {
+		return time.Time{}, &CIPError{Service: 0x03, Class: 0x8B, Path: "WallClock", Status: 0x13}
 	}
 
-	if status == 0 {
-		//# get the time from the packet
-		plcTime := binary.LittleEndian.Uint64(retData[56:])
-		humanTime := time.Unix(0, int64(plcTime)*1000)
-		return humanTime
-	} else {
-		var err string
-		if code, ok := cipErrorCodes[status]; ok {
-			err = code
-		} else {
-			err = "Unknown error"
-		}
-		fmt.Println("Failed to get PLC time: " + err)
-		return time.Time{}
+	//# get the time from the packet
+	plcTime := binary.LittleEndian.Uint64(data[6:])
+	humanTime := time.Unix(0, int64(plcTime)*1000)
+	return humanTime, nil
+}
+
+func (plc *PLC)_setPLCTime(t time.Time) error {
+	/*
+	Sets the PLC clock through WallClock attribute 6, UTC in
+	microseconds, with Set Attribute List
+	*/
+	if err := plc._connect(); err != nil {
+		return err
+	}
+	if plc.Micro800 {
+		return &CIPError{Service: 0x04, Class: 0x8B, Path: "WallClock", Status: 0x08}
+	}
+	ap := Attribute {
+		AttributeService: 0x04,
+		AttributeSize: 0x02,
+		AttributeClassType: 0x20,
+		AttributeClass: 0x8B,
+		AttributeInstanceType: 0x24,
+		AttributeInstance: 0x01,
+		AttributeCount: 0x01,
+		TimeAttribute: 0x06,
+	}
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, ap); err != nil {
+		return err
+	}
+	binary.Write(buf, binary.LittleEndian, uint64(t.UnixMicro()))
+
+	retData, err := plc._request(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := _replyError(retData, 0x8B, "WallClock"); err != nil {
+		return err
+	}
+	//# attribute count, then the attribute ID and its own status
+	data := _replyData(retData)
+	if len(data) >= 6 && data[4] != 0x00 {
+		return &CIPError{Service: 0x04, Class: 0x8B, Path: "WallClock", Status: data[4]}
 	}
+	return nil
 }
 
-func (plc *PLC)_getTagList() []LGXTag {
+func (plc *PLC)_getTagList() ([]LGXTag, error) {
 	/*
 	Requests the controller tag list and returns a list of LgxTag type
 	Also updates the internal list of LGXTag (plc.TagList)
 	*/
-	if !plc._connect() {
-		return nil
+	if err := plc._connect(); err != nil {
+		return nil, err
 	}
-	var status uint16
-	plc.Offset = 0
-	plc.TagList = nil
-	plc.ProgramNames = nil
 	
-	request := plc._buildTagListRequest("")
-	eipHeader := plc._buildEIPHeader(len(request))
-	retData := plc._getBytes(append(eipHeader, request...))
-	if len(retData)>48 {
-		status = binary.LittleEndian.Uint16(retData[48:])
-		plc._extractTagPacket(retData, "")
-	} else {
-		status = uint16(0x01)
-	}
-	if status != 0 && status != 6 {
-		var err string
-		if code, ok := cipErrorCodes[status]; ok {
-			err = code
-		} else {
-			err = "Unknown error"
-		}
-		fmt.Println("Error while getting taglist: " + err)
-	}
-
-	for status == 6 {
-		plc.Offset += 1
-		request = plc._buildTagListRequest("")
-		eipHeader = plc._buildEIPHeader(len(request))
-		retData = plc._getBytes(append(eipHeader, request...))
-		plc._extractTagPacket(retData, "")
-		status = binary.LittleEndian.Uint16(retData[48:])
-		//#time.sleep(0.25)
+	tagList, programNames, err := plc._getTagListScope("")
+	if err != nil {
+		return nil, err
 	}
 
 	/*
 	When we're done with the controller scoped tags,
 	request the program scoped tags
+	Micro800 doesn't have program scoped tags
 	*/
-	for _, programName := range plc.ProgramNames {
+	if !plc.Micro800 {
+		for _, programName := range programNames {
+			programTags, _, err := plc._getTagListScope(programName)
+			if err != nil {
+				return nil, err
+			}
+			tagList = append(tagList, programTags...)
+		}
+	}
 
-		plc.Offset = 0
+	plc.cacheMu.Lock()
+	plc.TagList = tagList
+	plc.ProgramNames = programNames
+	plc.symbols = _symbolTable(tagList)
+	plc.cacheDirty = true
+	plc.cacheMu.Unlock()
+	return append([]LGXTag(nil), tagList...), nil
+}
 
-		request = plc._buildTagListRequest(programName)
-		eipHeader = plc._buildEIPHeader(len(request))
-		retData = plc._getBytes(append(eipHeader, request...))
-		if len(retData)>48 {
-			status = binary.LittleEndian.Uint16(retData[48:])
-			plc._extractTagPacket(retData, programName)
-		} else {
-			status = uint16(0x01)
+func (plc *PLC)_getTagListScope(programName string) ([]LGXTag, []string, error) {
+	/*
+	Pages through the tags of one scope, the reply status is 6
+	for as long as there are more tags to fetch
+	*/
+	var tagList []LGXTag
+	var programNames []string
+	plc.Offset = 0
+
+	for {
+		request := plc._buildTagListRequest(programName)
+		retData, err := plc._request(request)
+		if err != nil {
+			return nil, nil, err
 		}
-		if status != 0 && status != 6 {
-			var err string
-			if code, ok := cipErrorCodes[status]; ok {
-				err = code
-			} else {
-				err = "Unknown error"
-			}
-			fmt.Println("Error while getting taglist: " + err)
+		if err := _replyError(retData, 0x6B, programName); err != nil {
+			return nil, nil, err
 		}
-
-		for status == 6 {
-			plc.Offset += 1
-			request = plc._buildTagListRequest(programName)
-			eipHeader = plc._buildEIPHeader(len(request))
-			retData = plc._getBytes(append(eipHeader, request...))
-			plc._extractTagPacket(retData, programName)
-			status = binary.LittleEndian.Uint16(retData[48:])
-			//#time.sleep(0.25)
+		tags, programs := plc._extractTagPacket(retData, programName)
+		tagList = append(tagList, tags...)
+		programNames = append(programNames, programs...)
+		if retData[2] != 6 {
+			return tagList, programNames, nil
 		}
+		plc.Offset += 1
 	}
-
-	return plc.TagList
 }
 
 func (plc *PLC)_buildTagListRequest(programName string) []byte {
@@ -773,9 +1118,12 @@ func (plc *PLC)_buildTagListRequest(programName string) []byte {
 	Service := byte(0x55)
 	PathSegmentLen := PathSegment.Len() / 2
 
-	AttributeCount := uint16(0x03)
+	//# the name goes last, it's the only one that varies in size
+	AttributeCount := uint16(0x05)
 	SymbolType := uint16(0x02)
 	ByteCount := uint16(0x07)
+	Dimensions := uint16(0x08)
+	ExternalAccess := uint16(0x0A)
 	SymbolName := uint16(0x01)
 
 	TagListRequest = append(TagListRequest, Service)
@@ -783,31 +1131,43 @@ func (plc *PLC)_buildTagListRequest(programName string) []byte {
 	
 	TagListRequest = append(TagListRequest, PathSegment.Bytes()...)
 	
-	tmp := make([]byte, 8)
+	tmp := make([]byte, 12)
 	binary.LittleEndian.PutUint16(tmp[0:], AttributeCount)
 	binary.LittleEndian.PutUint16(tmp[2:], SymbolType)
 	binary.LittleEndian.PutUint16(tmp[4:], ByteCount)
-	binary.LittleEndian.PutUint16(tmp[6:], SymbolName)
+	binary.LittleEndian.PutUint16(tmp[6:], Dimensions)
+	binary.LittleEndian.PutUint16(tmp[8:], ExternalAccess)
+	binary.LittleEndian.PutUint16(tmp[10:], SymbolName)
 	TagListRequest = append(TagListRequest, tmp...)
 
 	return TagListRequest
 }
 
-func (plc *PLC)_extractTagPacket(data []byte, programName string) {
-	// the first tag in a packet starts at byte 50
-	packetStart := uint(50)
+/*
+Every tag in a tag list reply: InstanceID(4) SymbolType(2) ByteCount(2)
+Dimensions(3x4) ExternalAccess(1) then the name, Length(2) and the name
+itself
+*/
+const tagHeaderLen = 23
+
+func (plc *PLC)_extractTagPacket(data []byte, programName string) ([]LGXTag, []string) {
+	// the first tag in a packet starts after the reply status
+	data = _replyData(data)
+	packetStart := uint(0)
 	var tagLen uint16
 	var packet []byte
 	var tag LGXTag
+	var tagList []LGXTag
+	var programNames []string
 
-	for packetStart < uint(len(data)) {
+	for packetStart+tagHeaderLen <= uint(len(data)) {
 		// get the length of the tag name
-		tagLen = binary.LittleEndian.Uint16(data[packetStart+8:])
-		if tagLen == 0 {
+		tagLen = binary.LittleEndian.Uint16(data[packetStart+tagHeaderLen-2:])
+		if tagLen == 0 || packetStart+uint(tagLen)+tagHeaderLen > uint(len(data)) {
 			break
 		}
 		// get a single tag from the packet
-		packet = data[packetStart:packetStart+uint(tagLen)+10]
+		packet = data[packetStart:packetStart+uint(tagLen)+tagHeaderLen]
 		// extract the offset
 		plc.Offset = binary.LittleEndian.Uint16(packet[0:])
 		// add the tag to our tag list
@@ -815,21 +1175,22 @@ func (plc *PLC)_extractTagPacket(data []byte, programName string) {
 		// filter out garbage
 		//if _, ok := plc.CIPTypes[tag.DataType]; ok && !strings.Contains(tag.TagName, "__DEFVAL_") && !strings.Contains(tag.TagName, "Routine:") {
 		if !tag.IsSystem && !strings.HasPrefix(tag.TagName, "__") { //would that cover everything?
-			plc.TagList = append(plc.TagList, tag)
+			tagList = append(tagList, tag)
 		}
 		if len(programName) == 0 {
 			if strings.Contains(tag.TagName, "Program:") {
-				plc.ProgramNames = append(plc.ProgramNames, tag.TagName)
+				programNames = append(programNames, tag.TagName)
 			}
 		}
 		// increment ot the next tag in the packet
-		packetStart = packetStart+uint(tagLen)+10
+		packetStart = packetStart+uint(tagLen)+tagHeaderLen
 	}
+	return tagList, programNames
 }
 
 func (plc *PLC)_parseLgxTag(packet []byte, programName string) LGXTag {
 	var tag LGXTag
-/*Sample Data
+/*Sample Data, from when only symbol type, byte count and name were asked for
 InstanceID DataType ByteCount? Length TagName
 10050000 30 81 0c00 0e00 424154315f4c5144355f46434e53 
 11050000 1e 89 e81a 1800 424154315f4c5144355f4d41535445525f464f524d554c41
@@ -849,6 +1210,7 @@ InstanceID DataType ByteCount? Length TagName
 */
 	tag.InstanceID = binary.LittleEndian.Uint32(packet[0:]) //I think actually InstanceID, 32bit
 	tag.DataType = packet[4]
+	tag.SymbolType = binary.LittleEndian.Uint16(packet[4:])
 	if tag.DataType == 0xC1 {
 		tag.BitPosition = packet[5] & 0x07
 	}
@@ -860,145 +1222,232 @@ InstanceID DataType ByteCount? Length TagName
 	// bit 15 indicates struct: in this case bits 0-11 are instanceID of template obj for
 	//  structure definition
 	// bit 12 indicates system tag
-	length := binary.LittleEndian.Uint16(packet[8:])
+	if tag.IsStruct {
+		tag.TemplateInstance = tag.SymbolType & 0x0FFF
+	}
+	tag.ElementSize = int(binary.LittleEndian.Uint16(packet[6:]))
+	for i := 0; i < int(tag.ArrayDims); i++ {
+		tag.Dimensions = append(tag.Dimensions, int(binary.LittleEndian.Uint32(packet[8+4*i:])))
+	}
+	tag.ExternalAccess = Access(packet[20] & 0x03)
+	length := binary.LittleEndian.Uint16(packet[21:])
 	if len(programName) > 0 {
-		tag.TagName = programName + "." + string(packet[10:length+10])
+		tag.TagName = programName + "." + string(packet[tagHeaderLen:length+tagHeaderLen])
 	} else {
-		tag.TagName = string(packet[10:length+10])
+		tag.TagName = string(packet[tagHeaderLen:length+tagHeaderLen])
 	}
 
 	return tag
 }
 
-func (plc *PLC)_multiParser(data []byte) []interface{} {
+func (plc *PLC)_multiParser(data []byte, count int) ([][]byte, error) {
 	/*
-	Takes multi read reply data and returns an array of the values
+	Takes multi read reply data and returns the reply of each service,
+	starting at its reply service, nil for one that's out of bounds
 	*/
 	// remove the beginning of the packet because we just don't care about it
-	var reply []interface{}
-	stripped := data[50:]
+	stripped := _replyData(data)
+	if len(stripped) < 2 {
+		return nil, fmt.Errorf("eip: Multiple Service Packet: reply too short (%d bytes)", len(data))
+	}
 	tagCount := int(binary.LittleEndian.Uint16(stripped[0:]))
+	if tagCount != count || len(stripped) < 2+2*tagCount {
+		return nil, fmt.Errorf("eip: Multiple Service Packet: expected %d replies, got %d", count, tagCount)
+	}
 
-	// get the offset values for each of the tags in the packet
+	// get the offset values for each of the services in the packet
+	services := make([][]byte, tagCount)
 	for i:=0; i<tagCount; i++ {
 		loc := 2+(i*2)	//# pointer to offset
-		offset := binary.LittleEndian.Uint16(stripped[loc:])
-		replyStatus := stripped[offset+2]
-		replyExtended := stripped[offset+3]
-
-		//# successful reply, add the value to our list
-		if replyStatus == 0 && replyExtended == 0 {
-			dataTypeValue := stripped[offset+4]
-			//160 is supposed to be struct?
-			if dataTypeValue == 160 || dataTypeValue == 218 {
-				strlen := uint16(stripped[offset+8])
-				reply = append(reply, string(stripped[offset+12:offset+12+strlen]))
-			} else {
-				switch plc.CIPTypes[dataTypeValue].format {
-				case '?':	//boolean, values are 0x00 or 0xFF
-					reply = append(reply, stripped[offset+6])
-				case 'b':	//SINT
-					reply = append(reply, int8(stripped[offset+6]))
-				case 'h':	//INT
-					reply = append(reply, int16(binary.LittleEndian.Uint16(stripped[offset+6:])))
-				case 'i':	//DINT
-					reply = append(reply, int32(binary.LittleEndian.Uint32(stripped[offset+6:])))
-				case 'q':	//LINT
-					reply = append(reply, int64(binary.LittleEndian.Uint64(stripped[offset+6:])))
-				case 'B':	//USINT
-					reply = append(reply, stripped[offset+6])
-				case 'H':	//UINT
-					reply = append(reply, binary.LittleEndian.Uint16(stripped[offset+6:]))
-				case 'I':	//UDINT
-					reply = append(reply, binary.LittleEndian.Uint32(stripped[offset+6:]))
-				case 'Q':	//LWORD
-					reply = append(reply, binary.LittleEndian.Uint64(stripped[offset+6:]))
-				case 'f':	//REAL
-					reply = append(reply, math.Float32frombits(binary.LittleEndian.Uint32(stripped[offset+6:])))
-				case 'd':	//LREAL
-					reply = append(reply, math.Float64frombits(uint64(binary.LittleEndian.Uint32(stripped[offset+6:]))))
-				}
-			}
-		} else {
-			reply = append(reply, "Error")
+		offset := int(binary.LittleEndian.Uint16(stripped[loc:]))
+		if offset+4 <= len(stripped) {
+			services[i] = stripped[offset:]
 		}
 	}
-	return reply
+	return services, nil
 }
 
-func (plc *PLC)_connect() bool {
-	if plc.SocketConnected {
-		return true
+func (plc *PLC)_multiValue(service []byte) (interface{}, byte, error) {
+	/*
+	Decodes the value of one service reply in a multi read
+	Returns the value and the type code it came with
+	*/
+	data := _replyData(service)
+	if len(data) < 2 {
+		return nil, 0, fmt.Errorf("reply too short")
+	}
+	dataTypeValue := data[0]
+	dataSize := plc.CIPTypes[dataTypeValue].dataLen
+	if len(data) < 2+dataSize {
+		return nil, dataTypeValue, fmt.Errorf("reply too short")
+	}
+	//160 is supposed to be struct?
+	if dataTypeValue == 160 {
+		if len(data) < 8 || len(data) < 8+int(data[4]) {
+			return nil, dataTypeValue, fmt.Errorf("reply too short")
+		}
+		strlen := uint16(data[4])
+		return string(data[8:8+strlen]), dataTypeValue, nil
+	} else if dataTypeValue == 218 {
+		//# Micro800 short string, one byte of length
+		if len(data) < 3+int(data[2]) {
+			return nil, dataTypeValue, fmt.Errorf("reply too short")
+		}
+		strlen := uint16(data[2])
+		return string(data[3:3+strlen]), dataTypeValue, nil
+	}
+	if value, ok := _decodeAtomic(plc.CIPTypes[dataTypeValue].format, data[2:]); ok {
+		return value, dataTypeValue, nil
 	}
+	return nil, dataTypeValue, fmt.Errorf("unsupported data type 0x%02X", dataTypeValue)
+}
+
+func _decodeAtomic(format rune, data []byte) (interface{}, bool) {
+	/*
+	Decodes one atomic value, the same Go type for a CIP type whichever
+	way it was read. data has to hold at least the size of the type
+	*/
+	switch format {
+	case '?':	//boolean, values are 0x00 or 0xFF
+		return data[0] > 0, true
+	case 'b':	//SINT
+		return int8(data[0]), true
+	case 'h':	//INT
+		return int16(binary.LittleEndian.Uint16(data)), true
+	case 'i':	//DINT
+		return int32(binary.LittleEndian.Uint32(data)), true
+	case 'q':	//LINT
+		return int64(binary.LittleEndian.Uint64(data)), true
+	case 'B':	//USINT
+		return data[0], true
+	case 'H':	//UINT
+		return binary.LittleEndian.Uint16(data), true
+	case 'I':	//UDINT
+		return binary.LittleEndian.Uint32(data), true
+	case 'Q':	//LWORD
+		return binary.LittleEndian.Uint64(data), true
+	case 'f':	//REAL
+		return math.Float32frombits(binary.LittleEndian.Uint32(data)), true
+	case 'd':	//LREAL
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), true
+	}
+	return nil, false
+}
+
+func (plc *PLC)_openSession() error {
+	/*
+	Dials the target, registers a session and opens the class 3
+	connection, every attempt under a new connection serial number
+	*/
 	var err error
 
+	if plc.CIPTypes == nil {
+		if err = plc._init(); err != nil {
+			return err
+		}
+	}
+	if _, err = plc._connectionPath(); err != nil {
+		return err
+	}
+
 	addr := plc.IPAddress + ":" + strconv.Itoa(int(plc.Port))
-	plc.Socket, err = net.Dial("tcp", addr)
+	plc.Socket, err = plc._dial()
 	if err != nil {
 		plc.SocketConnected = false
 		plc.SequenceCounter = 1
-		fmt.Println(err)
-		return false
+		return err
 	}
 
+	plc.SerialNumber = uint16(rand.Intn(65000))
+	plc.SequenceCounter = 1
 	buf := plc._buildRegisterSession()
-	retData := plc._getBytes(buf)
+	frame, err := plc._transact(buf)
+	if err != nil {
+		plc.Socket.Close()
+		return fmt.Errorf("eip: failed to register session with %s: %w", addr, err)
+	}
+	plc.SessionHandle = frame.SessionHandle
 
-	if retData != nil {
-		plc.SessionHandle = binary.LittleEndian.Uint32(retData[4:])
-	} else {
-		plc.SocketConnected = false
-		fmt.Println("Failed to register session")
-		return false
+	//# figure out if we're talking to a Micro800 before opening the connection
+	if id, err := plc._getIdentity(); err == nil {
+		plc.Identity = id
+		if id.IsMicro800() {
+			plc.Micro800 = true
+		}
 	}
 	
-	buf = plc._buildForwardOpenPacket()
-	retData = plc._getBytes(buf)
-	if retData != nil {
-		plc.OTNetworkConnectionID = binary.LittleEndian.Uint32(retData[44:])
+	plc.ForwardOpened = false
+	if plc.Unconnected {
+		plc.SocketConnected = true
+		return nil
+	}
+
+	buf, err = plc._buildForwardOpenPacket()
+	if err != nil {
+		plc.Socket.Close()
+		return err
+	}
+	frame, err = plc._transact(buf)
+	if err != nil {
+		plc.Socket.Close()
+		return err
+	}
+	//# the O->T connection ID is the first thing in the reply data
+	retData, foErr := frame.CIPReply()
+	if foErr == nil {
+		if foErr = _replyError(retData, 0x06, "Forward Open"); foErr == nil {
+			if data := _replyData(retData); len(data) >= 4 {
+				plc.OTNetworkConnectionID = binary.LittleEndian.Uint32(data)
+			} else {
+				foErr = fmt.Errorf("eip: Forward Open: reply too short (%d bytes)", len(retData))
+			}
+		}
+	}
+
+	if foErr == nil {
+		plc.ForwardOpened = true
+		plc.SocketConnected = true
+	} else if plc.UnconnectedFallback {
+		plc._log().Warnf("%s: %v, using unconnected messaging", plc._logContext(), foErr)
 		plc.SocketConnected = true
 	} else {
 		plc.SocketConnected = false
-		fmt.Println("Forward Open Failed")
-		return false
+		plc.Socket.Close()
+		return foErr
 	}
 
-	return true
+	return nil
 }
 
-func (plc *PLC)_closeConnection() {
+func (plc *PLC)_closeConnection() error {
+	if !plc.SocketConnected {
+		return nil
+	}
+	var closeErr error
 
-	closePacket := plc._buildForwardClosePacket()
+	if plc.ForwardOpened {
+		closePacket, err := plc._buildForwardClosePacket()
+		if err == nil {
+			_, err = plc._transact(closePacket)
+		}
+		closeErr = err
+		plc.ForwardOpened = false
+	}
 	unregPacket := plc._buildUnregisterSession()
+	//# there is no reply to unregister session, the target just closes the socket
+	plc.Socket.SetDeadline(plc._deadline())
+	if _, err := plc.Socket.Write(unregPacket); err != nil && closeErr == nil {
+		closeErr = _ioError("write", err)
+	}
 	
-	plc._getBytes(closePacket)
-	plc._getBytes(unregPacket) //Maybe this doesn't need response?
-	
-	plc.Socket.Close()
+	if err := plc.Socket.Close(); err != nil && closeErr == nil {
+		closeErr = err
+	}
+	plc.SocketConnected = false
+	return closeErr
 }
 
-func (plc *PLC)_getBytes(data []byte) []byte {
-	tmp := make([]byte, 1024)
-	var count int
-	
-	plc.Socket.SetDeadline(time.Now().Add(1*time.Second))
-	_, err := plc.Socket.Write(data)
-	if err != nil {
-		plc.SocketConnected = false
-		fmt.Println("Write: "+err.Error())
-		return nil
-	}
 
-	plc.Socket.SetDeadline(time.Now().Add(2*time.Second))
-	count, err = plc.Socket.Read(tmp)
-	if err != nil {
-		//plc.SocketConnected = false
-		fmt.Println("Read: "+err.Error())
-		return nil
-	} else {
-		return tmp[:count]
-	}
-}
 
 func (plc *PLC)_buildRegisterSession() []byte {
 	rs := RegSession{ 
@@ -1014,7 +1463,7 @@ func (plc *PLC)_buildRegisterSession() []byte {
 	buf := new(bytes.Buffer)
 	
 	if err := binary.Write(buf, binary.LittleEndian, rs); err != nil {
-		fmt.Println(err)
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
 		return nil
 	} else {
 		return buf.Bytes()
@@ -1032,14 +1481,24 @@ func (plc *PLC)_buildUnregisterSession() []byte {
 	}
 	buf := new(bytes.Buffer)
 	if err := binary.Write(buf, binary.LittleEndian, us); err != nil {
-		fmt.Println(err)
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
 		return nil
 	} else {
 		return buf.Bytes()
 	}
 }
 
-func (plc *PLC)_buildCIPForwardOpen() []byte {
+func (plc *PLC)_buildCIPForwardOpen() ([]byte, error) {
+	/*
+	Micro800 only has its embedded port, so there is no route
+	and it wants a longer connection timeout
+	*/
+	priority := byte(0x0A)
+	timeoutTicks := byte(0x0e)
+	if plc.Micro800 {
+		priority = 0x07
+		timeoutTicks = 0xE9
+	}
 	cip_fo := CIPForwardOpen{
 		CIPService: 0x54,
 		CIPPathSize: 0x02,
@@ -1047,8 +1506,8 @@ func (plc *PLC)_buildCIPForwardOpen() []byte {
 		CIPClass: 0x06,
 		CIPInstanceType: 0x24,
 		CIPInstance: 0x01,
-		CIPPriority: 0x0A,
-		CIPTimeoutTicks: 0x0e,
+		CIPPriority: priority,
+		CIPTimeoutTicks: timeoutTicks,
 		CIPOTConnectionID: 0x20000002,
 		CIPTOConnectionID: 0x20000001,
 		CIPConnectionSerialNumber: plc.SerialNumber,
@@ -1064,25 +1523,20 @@ func (plc *PLC)_buildCIPForwardOpen() []byte {
 	buf := new(bytes.Buffer)
 	
 	if err := binary.Write(buf, binary.LittleEndian, cip_fo); err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 	
-	connPath := [7]byte{0x00, 0x01, plc.ProcessorSlot, 0x20, 0x02, 0x24, 0x01}
-	size :=(len(connPath)-1)/2
-	connPath[0] = byte(size)
-	
-	//Not totally sure if write to buf keeps track of where it ended
-	if err := binary.Write(buf, binary.LittleEndian, connPath); err != nil {
-		fmt.Println(err)
-		return nil
-	} else {
-		return buf.Bytes()
+	connPath, err := plc._connectionPath()
+	if err != nil {
+		return nil, err
 	}
+	buf.WriteByte(byte(len(connPath)/2))
+	buf.Write(connPath)
 	
+	return buf.Bytes(), nil
 }
 
-func (plc *PLC)_buildCIPForwardClose() []byte {
+func (plc *PLC)_buildCIPForwardClose() ([]byte, error) {
 	cip_fc := CIPForwardClose{
 		CIPService: 0x4e,
 		CIPPathSize: 0x02,
@@ -1099,20 +1553,19 @@ func (plc *PLC)_buildCIPForwardClose() []byte {
 	buf := new(bytes.Buffer)
 	
 	if err := binary.Write(buf, binary.LittleEndian, cip_fc); err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 	
-	connPath := [6]byte{0x01, plc.ProcessorSlot, 0x20, 0x02, 0x24, 0x01}
+	connPath, err := plc._connectionPath()
+	if err != nil {
+		return nil, err
+	}
+	//# path size is followed by a reserved byte
 	size := uint16(len(connPath)/2)
 	binary.Write(buf, binary.LittleEndian, size)
+	buf.Write(connPath)
 	
-	if err := binary.Write(buf, binary.LittleEndian, connPath); err != nil {
-		fmt.Println(err)
-		return nil
-	} else {
-		return buf.Bytes()
-	}
+	return buf.Bytes(), nil
 }
 
 func (plc *PLC)_buildEIPSendRRDataHeader(baseData []byte) []byte {
@@ -1135,101 +1588,136 @@ func (plc *PLC)_buildEIPSendRRDataHeader(baseData []byte) []byte {
 	buf := new(bytes.Buffer)
 	
 	if err := binary.Write(buf, binary.LittleEndian, eipRR); err != nil {
-		fmt.Println(err)
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
 		return nil
 	} else {
 		return buf.Bytes()
 	}
 }
 
-func (plc *PLC)_buildForwardOpenPacket() []byte {
+func (plc *PLC)_buildUnconnectedSend(message []byte) ([]byte, error) {
+	/*
+	Wraps a request in the Connection Manager's Unconnected Send (Vol 1 3-5.5.2)
+	so the modules along the route forward it to the controller
+	No route means we're already talking to the controller, send it as is
+	*/
+	routePath, err := plc._routePath()
+	if err != nil {
+		return nil, err
+	}
+	if len(routePath) == 0 {
+		return message, nil
+	}
+	return plc._unconnectedSendTo(message, routePath)
+}
+
+func (plc *PLC)_unconnectedSendTo(message []byte, routePath []byte) ([]byte, error) {
+	priority := byte(0x0A)
+	timeoutTicks := byte(0x0e)
+	if plc.Micro800 {
+		priority = 0x07
+		timeoutTicks = 0xE9
+	}
+	us := UnconnectedSend{
+		CIPService: 0x52,
+		CIPPathSize: 0x02,
+		CIPClassType: 0x20,
+		CIPClass: 0x06,
+		CIPInstanceType: 0x24,
+		CIPInstance: 0x01,
+		CIPPriority: priority,
+		CIPTimeoutTicks: timeoutTicks,
+		CIPMessageSize: uint16(len(message)),
+	}
+	buf := new(bytes.Buffer)
 	
-	data := plc._buildCIPForwardOpen()
-	rrDataHeader := plc._buildEIPSendRRDataHeader(data)
-	return append(rrDataHeader, data...)
+	if err := binary.Write(buf, binary.LittleEndian, us); err != nil {
+		return nil, err
+	}
+	buf.Write(message)
+	//# pad the message to an even length
+	if len(message)%2 > 0 {
+		buf.WriteByte(0x00)
+	}
+	buf.WriteByte(byte(len(routePath)/2))
+	buf.WriteByte(0x00) //# reserved
+	buf.Write(routePath)
+	
+	return buf.Bytes(), nil
+}
+
+func (plc *PLC)_requestOverhead() int {
+	/*
+	Bytes that wrap a CIP request on the wire, used to size packets
+	*/
+	if plc.ForwardOpened {
+		return 46 //# SendUnitData header and sequence count
+	}
+	routePath, _ := plc._routePath()
+	return 40+10+2+len(routePath) //# SendRRData header, Unconnected Send and route
+}
+
+func (plc *PLC)_request(cip []byte) ([]byte, error) {
+	/*
+	Sends a CIP request over the connection when the Forward Open
+	succeeded, otherwise as an unconnected message
+	Returns the CIP reply, starting at the reply service
+	*/
+	if plc.ForwardOpened {
+		replies, errs, err := plc._pipeline([][]byte{cip})
+		if err != nil {
+			return nil, err
+		}
+		if errs[0] != nil {
+			return nil, errs[0]
+		}
+		return replies[0], nil
+	}
+
+	message, err := plc._buildUnconnectedSend(cip)
+	if err != nil {
+		return nil, err
+	}
+	rrDataHeader := plc._buildEIPSendRRDataHeader(message)
+	frame, err := plc._transact(append(rrDataHeader, message...))
+	if err != nil {
+		return nil, err
+	}
+	return plc._checkReply(frame)
 }
 
-func (plc *PLC)_buildForwardClosePacket() []byte { 
-	data := plc._buildCIPForwardClose()
+func (plc *PLC)_checkReply(frame *Frame) ([]byte, error) {
+	reply, err := frame.CIPReply()
+	if err == nil && _connectionFailed(reply, false) {
+		plc._sessionLost(_cipError(reply, 0, "connection"))
+	}
+	return reply, err
+}
+
+func (plc *PLC)_buildForwardOpenPacket() ([]byte, error) {
+	data, err := plc._buildCIPForwardOpen()
+	if err != nil {
+		return nil, err
+	}
 	rrDataHeader := plc._buildEIPSendRRDataHeader(data)
-	return append(rrDataHeader, data...)
+	return append(rrDataHeader, data...), nil
 }
 
-func (plc *PLC)_buildTagIOI(tagName string, isBoolArray bool) []byte {
-	buf := new(bytes.Buffer)
-	tagArray := strings.Split(tagName, ".")
-
-	//# this loop figures out the packet length and builds our packet
-	for i:=0; i<len(tagArray); i++ {
-		if strings.HasSuffix(tagArray[i],"]") {
-			_, basetag, index := _tagNameParser(tagArray[i], 0)
-			
-			BaseTagLenBytes := len(basetag)						 //# get number of bytes
-			if isBoolArray && i == len(tagArray)-1 {
-				index = index/32
-			}
+func (plc *PLC)_buildForwardClosePacket() ([]byte, error) {
+	data, err := plc._buildCIPForwardClose()
+	if err != nil {
+		return nil, err
+	}
+	rrDataHeader := plc._buildEIPSendRRDataHeader(data)
+	return append(rrDataHeader, data...), nil
+}
 
-			//# Assemble the packet
-			buf.WriteByte(0x91)
-			buf.WriteByte(byte(BaseTagLenBytes))
-			buf.WriteString(basetag) //This might not work right
-			
-			if BaseTagLenBytes%2 > 0 {								  //# check for odd bytes
-				BaseTagLenBytes += 1							   //# add another byte to make it even
-				buf.WriteByte(0x00)
-			}
-			//BaseTagLenWords := BaseTagLenBytes/2					//# figure out the words for this segment
-
-			if i < len(tagArray) {
- //			   if _,ok := index.(int); ok{
-				//not isinstance(index, list) {	//checking if index is an int - requires it to change to an interface{}
-					if index < 256{		  					//# if index is 1 byte...
-						buf.WriteByte(0x28)
-						buf.WriteByte(byte(index))
-					}
-					if 65536 > index && index > 255{						 //# if index is more than 1 byte...
-						binary.Write(buf, binary.LittleEndian, 0x0029)
-						binary.Write(buf, binary.LittleEndian, uint16(index))   //# add 2 words to packet
-					}
-					if index > 65535 {								//# if index is more than 4 bytes
-						binary.Write(buf, binary.LittleEndian, 0x002A)
-						binary.Write(buf, binary.LittleEndian, uint32(index))
-					}
-/*				} else {	//index is an array
-					for i2=0; i2<len(index); i2++ {
-						if index[i2] < 256 {								  //# if index is 1 byte...
-							buf.WriteByte(0x28)
-							buf.WriteByte(byte(index[i2]))   //# add one word to packet
-						}
-						if 65536 > index[i2] > 255 {						 //# if index is more than 1 byte...
-							binary.Write(buf, binary.LittleEndian, 0x0029)
-							binary.Write(buf, binary.LittleEndian, uint16(index[i2]))   //# add 2 words to packet
-						}
-						if index[i2] > 65535 {								//# if index is more than 4 bytes
-							binary.Write(buf, binary.LittleEndian, 0x002A)
-							binary.Write(buf, binary.LittleEndian, uint32(index[i2]))  //# add 2 words to packet
-						}
-					}
-				}
-*/
-			}
-		} else {
-			_, err := strconv.Atoi(tagArray[i])
-			if err != nil { //then it is not a bool index
-				BaseTagLenBytes := len(tagArray[i])	//# store len of tag
-				buf.WriteByte(0x91)
-				buf.WriteByte(byte(BaseTagLenBytes))
-				buf.WriteString(tagArray[i])
-				
-				if BaseTagLenBytes%2 > 0 {	//# check for odd bytes
-					BaseTagLenBytes += 1	//# add another byte to make it even
-					buf.WriteByte(0x00)
-				}
-			}
-		}
+func (plc *PLC)_buildTagIOI(tagName string, isBoolArray bool) ([]byte, error) {
+	path, err := ParseTagPath(tagName)
+	if err != nil {
+		return nil, err
 	}
-	
-	return buf.Bytes()
+	return path._ioi(isBoolArray), nil
 }
 
 func (plc *PLC)_addReadIOI(tagIOI []byte, elements uint16) []byte {
@@ -1292,7 +1780,7 @@ func (plc *PLC)_buildEIPHeader(payloadLen int) []byte {
 	plc.ContextPointer += 1
 	
 	if err := binary.Write(buf, binary.LittleEndian, eip); err != nil {
-		fmt.Println(err)
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
 		return nil
 	}
 	
@@ -1312,240 +1800,206 @@ func (plc *PLC)_buildMultiServiceHeader() []byte {
 	buf := new(bytes.Buffer)
 	
 	if err := binary.Write(buf, binary.LittleEndian, ms); err != nil {
-		fmt.Println(err)
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
 		return nil
 	}
 	return buf.Bytes()
 }
 
-func (plc *PLC)_parseReply(tag string, elements uint16, data []byte) []interface {}{
+func (plc *PLC)_parseReply(tag string, path *TagPath, elements uint16, data []byte) ([]interface{}, error) {
 	var vals []interface{}
-	var words []uint16
+	var words []uint64 //# a LINT's bits go up to 63
 
-	_, basetag, index := _tagNameParser(tag, 0)
-	datatype := plc.KnownTags[basetag].dataType
+	datatype := plc._knownTag(path._base().String()).dataType
 	bitCount := plc.CIPTypes[datatype].dataLen * 8
 
-	//# if bit of word was requested
-	if BitofWord(tag) {
-		split_tag := strings.Split(tag, ".")
-		bitPos, _ := strconv.Atoi(split_tag[len(split_tag)-1])
-
-		wordCount := _getWordCount(uint32(bitPos), elements, bitCount)
-		tmp := plc._getReplyValues(tag, wordCount, data)
-		for _, val := range(tmp) {
-			words = append(words, val.(uint16))
+	//# bits of a word or of a bool array come back as whole words
+	bitOfWord := path.Bit >= 0
+	if bitOfWord || (datatype == 211 && !plc.Micro800) {
+		var wordCount uint16
+		if bitOfWord {
+			wordCount = _getWordCount(uint32(path.Bit), elements, bitCount)
+		} else {
+			wordCount = _getWordCount(uint32(path._lastIndex()%bitCount), elements, bitCount)
 		}
-		bits := plc._wordsToBits(tag, words, elements)
-		for _, x := range bits {
-			vals = append(vals, x)
+		tmp, err := plc._getReplyValues(tag, wordCount, data)
+		if err != nil {
+			return nil, err
 		}
-	} else if datatype == 211 {
-		wordCount := _getWordCount(uint32(index), elements, bitCount)
-		tmp := plc._getReplyValues(tag, wordCount, data)
 		for _, val := range(tmp) {
-			words = append(words, val.(uint16))
+			word, ok := _toInt64(val)
+			if !ok {
+				return nil, fmt.Errorf("eip: %s: can't take bits of a %T", tag, val)
+			}
+			words = append(words, uint64(word))
+		}
+		bits, err := plc._wordsToBits(tag, path, words, elements)
+		if err != nil {
+			return nil, err
 		}
-		bits := plc._wordsToBits(tag, words, elements)
 		for _, x := range bits {
 			vals = append(vals, x)
 		}
 	} else {
-		vals = plc._getReplyValues(tag, elements, data)
+		return plc._getReplyValues(tag, elements, data)
 	}
 	
-	return vals
+	return vals, nil
 }
 
-func (plc *PLC)_getReplyValues(tag string, elements uint16, data []byte) []interface{} {
+func (plc *PLC)_getReplyValues(tag string, elements uint16, data []byte) ([]interface{}, error) {
 	var vals []interface{}
 	
-	status := uint16(data[48])
-	//extendedStatus := data[49]
+	if err := _replyError(data, 0x6B, tag); err != nil {
+		return nil, err
+	}
+	service := data[0] & 0x7F
+	status := uint16(data[2])
+	data = _replyData(data)
+	if len(data) < 2 {
+		return nil, &CIPError{Service: service, Class: 0x6B, Path: tag, Status: 0x13}
+	}
 
-	if status == 0 || status == 6 {
-		//# parse the tag: This really isn't necessary, the reply explicitly states the datatype
-		//_, basetag, index := _tagNameParser(tag, 0)
-		//datatype := plc.KnownTags[basetag].dataType
-		
-		datatype := data[50] //50:51 technically, as uint16
-		CIPFormat := plc.CIPTypes[datatype].format
+	//# parse the tag: This really isn't necessary, the reply explicitly states the datatype
+	//_, basetag, index := _tagNameParser(tag, 0)
+	//datatype := plc._knownTag(basetag).dataType
+	
+	datatype := data[0] //0:1 technically, as uint16
+	CIPFormat := plc.CIPTypes[datatype].format
 
-		dataSize := plc.CIPTypes[datatype].dataLen
-		numbytes := len(data)-dataSize
-		counter := 0
-		
-		plc.Offset = 0
-		for i := uint16(0); i<elements; i++ {
-			index := 52+(counter*dataSize)
-			if datatype == 160 {
-			//This is a struct, wouldn't be reading a whole struct value
-				index = 54+(counter*dataSize)
-				NameLength := binary.LittleEndian.Uint64(data[index:])
-				vals = append(vals, string(data[index+4:index+4+int(NameLength)]))
-			} else if datatype == 218 {
-				NameLength := data[index]
-				vals = append(vals, string(data[index+1:index+1+int(NameLength)]))
-			} else {
-				switch CIPFormat {
-				case '?':	//boolean, values come back as 0x00 or 0xFF
-					vals = append(vals, data[index] > 0)
-				case 'b':	//SINT
-					vals = append(vals, int8(data[index]))
-				case 'h':	//INT
-					vals = append(vals, int16(binary.LittleEndian.Uint16(data[index:])))
-				case 'i':	//DINT
-					vals = append(vals, int32(binary.LittleEndian.Uint32(data[index:])))
-				case 'q':	//LINT
-					vals = append(vals, int64(binary.LittleEndian.Uint64(data[index:])))
-				case 'B':	//USINT
-					vals = append(vals, data[index])
-				case 'H':	//UINT
-					vals = append(vals, binary.LittleEndian.Uint16(data[index:]))
-				case 'I':	//UDINT
-					vals = append(vals, binary.LittleEndian.Uint32(data[index:]))
-				case 'Q':	//LWORD
-					vals = append(vals, binary.LittleEndian.Uint64(data[index:]))
-				case 'f':	//REAL
-					vals = append(vals, math.Float32frombits(binary.LittleEndian.Uint32(data[index:])))
-				case 'd':	//LREAL
-					vals = append(vals, math.Float64frombits(uint64(binary.LittleEndian.Uint32(data[index:]))))
-				}
+	dataSize := plc.CIPTypes[datatype].dataLen
+	numbytes := len(data)-dataSize
+	counter := 0
+	
+	plc.Offset = 0
+	for i := uint16(0); i<elements; i++ {
+		index := 2+(counter*dataSize)
+		if index+dataSize > len(data) || index >= len(data) {
+			//# not enough data for the elements we asked for
+			return nil, &CIPError{Service: service, Class: 0x6B, Path: tag, Status: 0x13}
+		}
+		if datatype == 160 {
+		//This is a struct, wouldn't be reading a whole struct value
+			index = 4+(counter*dataSize)
+			if index+4 > len(data) {
+				return nil, &CIPError{Service: service, Class: 0x6B, Path: tag, Status: 0x13}
 			}
-			plc.Offset += uint16(dataSize)
-			counter += 1
-			
-			//# re-read because the data is in more than one packet
-			if index == numbytes && status == 6 {
-				index = 0
-				counter = 0
+			NameLength := int(binary.LittleEndian.Uint32(data[index:]))
+			if index+4+NameLength > len(data) {
+				return nil, &CIPError{Service: service, Class: 0x6B, Path: tag, Status: 0x13}
 			}
-/*	Ignoring for now: don't want to handle send/receive inside this method
-				tagIOI := _buildTagIOI(plc, tag, false)
-				readIOI := _addPartialReadIOI((&plc), tagIOI, elements)
-				eipHeader := _buildEIPHeader((&plc), readIOI)
-
-				self.Socket.send(eipHeader)
-				data = self.Socket.recv(1024)
-				status = unpack_from('<h', data, 48)[0]
-				numbytes = len(data)-dataSize
-*/
+			vals = append(vals, string(data[index+4:index+4+NameLength]))
+		} else if datatype == 218 {
+			NameLength := int(data[index])
+			if index+1+NameLength > len(data) {
+				return nil, &CIPError{Service: service, Class: 0x6B, Path: tag, Status: 0x13}
+			}
+			vals = append(vals, string(data[index+1:index+1+NameLength]))
+		} else if value, ok := _decodeAtomic(CIPFormat, data[index:]); ok {
+			vals = append(vals, value)
 		}
-	} else { //# didn't nail it
-		var err string
-		if code, ok := cipErrorCodes[status]; ok {
-			err = code
-		} else {
-			err = "Unknown error"
+		plc.Offset += uint16(dataSize)
+		counter += 1
+		
+		//# re-read because the data is in more than one packet
+		if index == numbytes && status == 6 {
+			index = 0
+			counter = 0
 		}
-		vals = append(vals, "Failed to read tag: " + tag + " - " + err ) 
+/*	Ignoring for now: don't want to handle send/receive inside this method
+			tagIOI := _buildTagIOI(plc, tag, false)
+			readIOI := _addPartialReadIOI((&plc), tagIOI, elements)
+			eipHeader := _buildEIPHeader((&plc), readIOI)
+
+			self.Socket.send(eipHeader)
+			data = self.Socket.recv(1024)
+			status = unpack_from('<h', data, 48)[0]
+			numbytes = len(data)-dataSize
+*/
 	}
-	return vals
+	return vals, nil
 }
 
-func (plc *PLC)_wordsToBits(tag string, value []uint16, count uint16) []bool {
-	_, basetag, index := _tagNameParser(tag, 0)
-	datatype := plc.KnownTags[basetag].dataType
+func (plc *PLC)_wordsToBits(tag string, path *TagPath, value []uint64, count uint16) ([]bool, error) {
+	datatype := plc._knownTag(path._base().String()).dataType
 	bitCount := plc.CIPTypes[datatype].dataLen * 8
 	var bitPos int
 
 	if datatype == 211 {
-		bitPos = index
+		//# the words start at the one holding the first bit
+		bitPos = path._lastIndex()%bitCount
 	} else {
-		split_tag := strings.Split(tag, ".")
-		bitPos, _ = strconv.Atoi(split_tag[len(split_tag)-1])
+		bitPos = path.Bit
 	}
 	
 	var ret []bool
 	for _, v := range value {
 		for i:=0; i<bitCount; i++ {
-			ret = append(ret, BitValue(v, uint16(i)))
+			ret = append(ret, v & (uint64(1) << uint(i)) > 0)
 		}
 	}
-	return ret[bitPos:bitPos+int(count)]
+	if bitPos+int(count) > len(ret) {
+		return nil, fmt.Errorf("eip: %s: bit %d is out of range", tag, bitPos+int(count)-1)
+	}
+	return ret[bitPos:bitPos+int(count)], nil
 }
 
-func (plc *PLC)_initialRead(tag string, baseTag string) bool {
+func (plc *PLC)_initialRead(path *TagPath) error {
 	//# if a tag alread exists, return True
-	if _, ok := plc.KnownTags[baseTag]; ok {
-		return true
+	base := path._base()
+	baseTag := base.String()
+	if _, ok := plc._lookupTag(baseTag); ok {
+		return nil
 	}
 	
-	tmp := make([]byte, 1024)
+	var readIOI []byte
+	tagData := base._ioi(false)
+	if plc.Micro800 {
+		//# Micro800 rejects the fragmented read
+		readIOI = plc._addReadIOI(tagData, 1)
+	} else {
+		readIOI = plc._addPartialReadIOI(tagData, 1)
+	}
 
-	tagData := plc._buildTagIOI(baseTag, false)
-	readIOI := plc._addPartialReadIOI(tagData, 1)
-	eipHeader := plc._buildEIPHeader(len(readIOI))
-	readRequest := append(eipHeader, readIOI...)
-	
-	plc.Socket.SetDeadline(time.Now().Add(1*time.Second))
 	//# send our tag read request
-	plc.Socket.Write(readRequest)
-	
-	plc.Socket.SetDeadline(time.Now().Add(5*time.Second))
-	_, err := plc.Socket.Read(tmp)
-	
+	retData, err := plc._request(readIOI)
 	if err != nil {
-		plc.SocketConnected = false
-		return false
+		return err
 	}
-	status := tmp[48]
 
 	//# make sure it was successful
-	if status == 0 || status == 6 {
-		dataType := tmp[50]
-		dataLen := binary.LittleEndian.Uint16(tmp[2:])  //# this is really just used for STRING
-		plc.KnownTags[baseTag] = TagMap{dataType: dataType, dataLen: int(dataLen)}
-		return true
-	} else {
-		fmt.Println("Failed to read initial tag: " + strconv.Itoa(int(status))) 
-		return false
-	}
+	if err := _replyError(retData, 0x6B, baseTag); err != nil {
+		return err
+	}
+	data := _replyData(retData)
+	if len(data) < 2 {
+		return &CIPError{Service: retData[0] & 0x7F, Class: 0x6B, Path: baseTag, Status: 0x13}
+	}
+	dataType := data[0]
+	dataLen := len(data)-2  //# this is really just used for STRING
+	plc.cacheMu.Lock()
+	plc.KnownTags[baseTag] = TagMap{dataType: dataType, dataLen: dataLen}
+	plc.cacheDirty = true
+	plc.cacheMu.Unlock()
+	return nil
 }
 
-func _tagNameParser(tag string, offset uint16) (string, string, int) {
-	bt := tag
-	ind := 0
-	//var index interface{}
-	
-	if strings.HasSuffix(tag, "]") {
-		pos := strings.LastIndex(tag, "[") //# find position of [
-		bt = tag[:pos-1]			//# remove [x]: result=SuperDuper
-		ind_s := tag[pos+1:len(tag)-2]		   // # strip the []: result=x
-		s := strings.Split(ind_s, ",")			//# split so we can check for multi dimensin array
-		if len(s) == 1 {
-			ind, _ = strconv.Atoi(ind_s)
-			//index = ind
-		} else {
-			//# if we have a multi dim array, return the index
-			var ind_array []int
-			for i:=0; i<len(s); i++ {
-				ind, _ = strconv.Atoi(s[i])
-				ind_array = append(ind_array, ind)
-			}
-			//index = ind_array
-		}
-	}
-	
-	return tag, bt, ind
+func (plc *PLC)_lookupTag(baseTag string) (TagMap, bool) {
+	plc.cacheMu.RLock()
+	defer plc.cacheMu.RUnlock()
+	tm, ok := plc.KnownTags[baseTag]
+	return tm, ok
 }
 
-func _getBitOfWord(tag string, value uint16) bool {
-	split_tag := strings.Split(tag, ".")
-	bitPos, _ := strconv.Atoi(split_tag[len(split_tag)-1])
-	returnvalue := false
-	
-	if bitPos<=31 {
-		returnvalue = BitValue(value, uint16(bitPos))
-	}
-	
-	return returnvalue
+func (plc *PLC)_knownTag(baseTag string) TagMap {
+	tm, _ := plc._lookupTag(baseTag)
+	return tm
 }
 
 func _getWordCount(start uint32, length uint16, bits int) uint16 {
 	totalBits := start+uint32(length)
 	wordCount := totalBits / uint32(bits)
-	if totalBits % 32 > 0 {
+	if totalBits % uint32(bits) > 0 {
 		wordCount += 1
 	}
 	return uint16(wordCount)
@@ -1561,75 +2015,111 @@ func BitValue(value uint16, bitno uint16) bool {
 }
 
 func BitofWord(tag string) bool {
-	s := strings.Split(tag, ".")
-	if _, err := strconv.Atoi(s[len(s)-1]); err == nil {
-		return true
-	} else {
-		return false
-	}
+	path, err := ParseTagPath(tag)
+	return err == nil && path.Bit >= 0
 }
 
-func (plc *PLC)Read(args ...interface{}) []interface{} {
+func (plc *PLC)Read(tag string, elements ...int) ([]interface{}, error) {
 	/*
 	We have two options for reading depending on
 	the arguments, read a single tag, or read an array
 	*/
-	tag := ""
-	elements := 1
-	
-	for i,arg := range args {
-		switch i {
-		case 0:	//tag
-			if t, ok := arg.(string); !ok {
-				panic("1st arg should be tag name, type string.")
-			} else {
-				tag = t
-			}
-		case 1:	//elements
-			if e, ok := arg.(int); !ok {
-				panic("2nd arg should be elements, type int.")
-			} else {
-				elements = e
-			}
-		default:
-			panic("Too many arguments supplied.")
-		}
+	return plc.ReadContext(context.Background(), tag, elements...)
+}
+
+func (plc *PLC)ReadContext(ctx context.Context, tag string, elements ...int) ([]interface{}, error) {
+	count := 1
+	if len(elements) > 1 {
+		return nil, fmt.Errorf("eip: Read takes a single element count, got %d", len(elements))
 	}
-	
-	if len(tag)>0 {
-		return plc._readTag(tag, uint16(elements))
-	} else {
-		return nil
+	if len(elements) == 1 {
+		count = elements[0]
+	}
+	if len(tag) == 0 {
+		return nil, fmt.Errorf("eip: Read needs a tag name")
+	}
+	if count < 1 || count > math.MaxUint16 {
+		return nil, fmt.Errorf("eip: %s: invalid element count %d", tag, count)
 	}
+	defer plc._withContext(ctx)()
+	values, err := plc._readTag(tag, uint16(count))
+	if err != nil {
+		plc._logTagError(tag, err)
+	}
+	return values, err
 }
 
-func (plc *PLC)MultiRead(args []string) []interface{} {
+func (plc *PLC)MultiRead(args []string) ([]Response, error) {
         /*
         Read multiple tags in one request
+        Returns a Response per tag in the order of args, a tag that
+        can't be read has its Err set
         */
-        return plc._multiRead(args)
+        return plc.MultiReadContext(context.Background(), args)
+}
+
+func (plc *PLC)MultiReadContext(ctx context.Context, args []string) ([]Response, error) {
+        defer plc._withContext(ctx)()
+        responses, err := plc._multiRead(args)
+        for _, r := range responses {
+                if r.Err != nil {
+                        plc._logTagError(r.TagName, r.Err)
+                }
+        }
+        return responses, err
 }
 
-func (plc *PLC)GetPLCTime() time.Time {
+func (plc *PLC)GetPLCTime() (time.Time, error) {
         /*
         Get the PLC's clock time
         */
+        return plc.GetPLCTimeContext(context.Background())
+}
+
+func (plc *PLC)GetPLCTimeContext(ctx context.Context) (time.Time, error) {
+        defer plc._withContext(ctx)()
         return plc._getPLCTime()
 }
 
-func (plc *PLC)GetTagList() []LGXTag {
+func (plc *PLC)SetPLCTime(t time.Time) error {
+        /*
+        Set the PLC's clock, time.Now() to sync it with this host
+        */
+        return plc.SetPLCTimeContext(context.Background(), t)
+}
+
+func (plc *PLC)SetPLCTimeContext(ctx context.Context, t time.Time) error {
+        defer plc._withContext(ctx)()
+        return plc._setPLCTime(t)
+}
+
+func (plc *PLC)GetTagList() ([]LGXTag, error) {
         /*
         Retrieves the tag list from the PLC
         */
+        return plc.GetTagListContext(context.Background())
+}
+
+func (plc *PLC)GetTagListContext(ctx context.Context) ([]LGXTag, error) {
+        /*
+        Cancelling ctx stops the scan between, or in the middle of, pages
+        */
+        defer plc._withContext(ctx)()
         return plc._getTagList()
 }
 
 func (plc *PLC)FilterTagList(dataType byte) []string {
 	/*
 	Using 0 as "no filter"
+	Tags without external access are left out, they can't be read
 	*/
 	var result []string
+	plc.cacheMu.RLock()
+	defer plc.cacheMu.RUnlock()
 	for _, tag := range plc.TagList {
+		if !tag.Readable() {
+			continue
+		}
 		if dataType == 0 || tag.DataType == dataType {
 			result = append(result, tag.TagName)
 		}
@@ -1641,18 +2131,29 @@ func (plc *PLC)PrintTagList(dataType byte) {
 	/*
 	Using 0 as "no filter"
 	*/
-	fmt.Printf("Offset\tType \tStruct\tSystem\tDims\tTag Name\n")
+	plc.cacheMu.RLock()
+	defer plc.cacheMu.RUnlock()
+	fmt.Printf("Offset\tType\tStruct\tSystem\tDims\tAccess\tTag Name\n")
 	for _, tag := range plc.TagList {
 		if dataType == 0 || tag.DataType == dataType {
-			fmt.Printf("%v\t%v\t%v\t%v\t%v\t%v\n", tag.InstanceID, tag.DataType, tag.IsStruct, tag.IsSystem, tag.ArrayDims, tag.TagName)
+			fmt.Printf("%v\t%v\t%v\t%v\t%v\t%v\t%v\n", tag.InstanceID, plc._symbolTypeName(tag.SymbolType), tag.IsStruct, tag.IsSystem, tag.Dimensions, tag.ExternalAccess, tag.TagName)
 		}
 	}
 }
 
-func (plc *PLC)Close() {
+func (plc *PLC)Close() error {
         /*
         Close the connection to the PLC
         */
-        plc._closeConnection()
+        return plc.CloseContext(context.Background())
+}
+
+func (plc *PLC)CloseContext(ctx context.Context) error {
+        plc._waitRevalidation(true)
+        defer plc._withContext(ctx)()
+        if err := plc._saveCache(); err != nil {
+                plc._log().Warnf("%s: saving the cache: %v", plc._logContext(), err)
+        }
+        return plc._closeConnection()
 }
 
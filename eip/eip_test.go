@@ -1,32 +1,2207 @@
 package eip
 
 import (
-	"math"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aaronkoerner/telegrafPlugins/eip/eipsim"
+	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/testutil"
+	"gopkg.in/yaml.v3"
 )
 
-func TestPLC(t *testing.T) {
-	plc := &PLC{
-		 TagsToRead: ["tag1",
-	"tag2",
-	"tag3"],
-	IPAddress: "192.168.14.169"
-	ProcessSlot: 3
+func newSim(t *testing.T) *eipsim.Server {
+	sim := eipsim.New()
+	if err := sim.AddType(eipsim.Type{Name: "Motor", Members: []eipsim.Member{
+		{Name: "Speed", Type: "REAL"},
+		{Name: "Running", Type: "BOOL"},
+		{Name: "Faulted", Type: "BOOL"},
+		{Name: "Counts", Type: "DINT", Dims: []int{4}},
+	}}); err != nil {
+		t.Fatal(err)
 	}
+	tags := []eipsim.Tag{
+		{Name: "Temp", Type: "REAL", Value: 21.5},
+		{Name: "Count", Type: "DINT", Value: 42},
+		{Name: "Small", Type: "SINT", Value: -3},
+		{Name: "Word", Type: "INT", Value: 0x0A},
+		{Name: "Big", Type: "LINT", Value: 1 << 40},
+		{Name: "Flag", Type: "BOOL", Value: true},
+		{Name: "Name", Type: "STRING", Value: "hello"},
+		{Name: "Values", Type: "DINT", Dims: []int{10}, Value: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{Name: "Bits", Type: "BOOL", Dims: []int{64}, Value: []bool{false, true, false, true}},
+		{Name: "M1", Type: "Motor", Value: map[string]interface{}{"Speed": 1450.0, "Running": true, "Counts": []int{7, 8}}},
+		{Name: "Program:MainProgram.Step", Type: "DINT", Value: 3},
+	}
+	for _, tag := range tags {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sim
+}
+
+func newPLC(t *testing.T, sim *eipsim.Server) *PLC {
+	addr, err := sim.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	host, port, _ := net.SplitHostPort(addr.String())
+	p, _ := strconv.Atoi(port)
+	plc := &PLC{IPAddress: host, Port: uint16(p)}
+	if err := plc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { plc.Close() })
+	return plc
+}
+
+func TestRead(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	tests := []struct {
+		tag string
+		expected interface{}
+	}{
+		{"Temp", float32(21.5)},
+		{"Count", int32(42)},
+		{"Small", int8(-3)},
+		{"Word", int16(0x0A)},
+		{"Big", int64(1 << 40)},
+		{"Flag", true},
+		{"Name", "hello"},
+		{"Values[3]", int32(3)},
+		{"Word.1", true},
+		{"Word.2", false},
+		{"Bits[3]", true},
+		{"M1.Speed", float32(1450)},
+		{"M1.Counts[1]", int32(8)},
+		{"Program:MainProgram.Step", int32(3)},
+	}
+	for _, tt := range tests {
+		values, err := plc.Read(tt.tag)
+		if err != nil {
+			t.Errorf("%s: %v", tt.tag, err)
+			continue
+		}
+		if len(values) != 1 || values[0] != tt.expected {
+			t.Errorf("%s: expected %v (%T), got %v", tt.tag, tt.expected, tt.expected, values)
+		}
+	}
+}
+
+func TestReadElements(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	values, err := plc.Read("Values[2]", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{int32(2), int32(3), int32(4), int32(5), int32(6)}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("element %d: expected %v, got %v", i, expected[i], values[i])
+		}
+	}
+
+	if _, err := plc.Read("Values[8]", 5); err == nil {
+		t.Error("reading past the end of the array should fail")
+	}
+}
+
+func TestReadArrayFragmented(t *testing.T) {
+	//# more elements than fit in one reply come in Read Tag Fragmented pieces
+	sim := newSim(t)
+	counts := make([]int, 200)
+	totals := make([]int, 150)
+	for i := range counts {
+		counts[i] = i*3
+	}
+	for i := range totals {
+		totals[i] = -i << 33
+	}
+	for _, tag := range []eipsim.Tag{
+		{Name: "Counts", Type: "DINT", Dims: []int{200}, Value: counts},
+		{Name: "Totals", Type: "LINT", Dims: []int{150}, Value: totals},
+	} {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plc := newPLC(t, sim)
+	ctx := context.Background()
+
+	values, err := ReadArray[int32](ctx, plc, "Counts[0]", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range values {
+		if v != int32(i*3) {
+			t.Fatalf("Counts[%d]: expected %d, got %d", i, i*3, v)
+		}
+	}
+	big, err := ReadArray[int64](ctx, plc, "Totals[5]", 145)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big) != 145 || big[0] != -5 << 33 || big[144] != -149 << 33 {
+		t.Errorf("unexpected Totals %v ... %v", big[0], big[len(big)-1])
+	}
+	raw, err := plc.Read("Counts[20]", 180)
+	if err != nil || len(raw) != 180 || raw[179] != int32(199*3) {
+		t.Errorf("expected 180 elements ending in %d, got %d, %v", 199*3, len(raw), err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	_, err := plc.Read("Missing")
+	if !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("expected ErrTagNotFound, got %v", err)
+	}
+	var cipErr *CIPError
+	if !errors.As(err, &cipErr) || cipErr.Path != "Missing" {
+		t.Errorf("expected a CIPError for Missing, got %#v", err)
+	}
+}
+
+func TestParseTagPath(t *testing.T) {
+	tests := []struct {
+		tag string
+		expected string //# String() of the parsed path
+		ioi []byte
+	}{
+		{"Count", "Count", []byte{0x91, 5, 'C', 'o', 'u', 'n', 't', 0}},
+		{"Values[3]", "Values[3]", []byte{0x91, 6, 'V', 'a', 'l', 'u', 'e', 's', 0x28, 3}},
+		{"A[300]", "A[300]", []byte{0x91, 1, 'A', 0, 0x29, 0, 0x2C, 0x01}},
+		{"A[70000]", "A[70000]", []byte{0x91, 1, 'A', 0, 0x2A, 0, 0x70, 0x11, 0x01, 0x00}},
+		{"A[1, 2,3]", "A[1,2,3]", []byte{0x91, 1, 'A', 0, 0x28, 1, 0x28, 2, 0x28, 3}},
+		{"M.C[1].5", "M.C[1].5", []byte{0x91, 1, 'M', 0, 0x91, 1, 'C', 0, 0x28, 1}},
+		{"program:Main.Step", "Program:Main.Step", []byte{0x91, 12, 'P', 'r', 'o', 'g', 'r', 'a', 'm', ':', 'M', 'a', 'i', 'n', 0x91, 4, 'S', 't', 'e', 'p'}},
+		{"Local:1:I.Data[0].5", "Local:1:I.Data[0].5", []byte{0x91, 9, 'L', 'o', 'c', 'a', 'l', ':', '1', ':', 'I', 0, 0x91, 4, 'D', 'a', 't', 'a', 0x28, 0}},
+	}
+	for _, tt := range tests {
+		path, err := ParseTagPath(tt.tag)
+		if err != nil {
+			t.Errorf("%s: %v", tt.tag, err)
+			continue
+		}
+		if path.String() != tt.expected {
+			t.Errorf("%s: parsed as %s", tt.tag, path)
+		}
+		if ioi := path._ioi(false); !bytes.Equal(ioi, tt.ioi) {
+			t.Errorf("%s: expected IOI % X, got % X", tt.tag, tt.ioi, ioi)
+		}
+	}
+
+	path, _ := ParseTagPath("Local:1:I.Data[0].5")
+	if path.Root() != "Local:1:I" || path.Bit != 5 || path._base().String() != "Local:1:I.Data" {
+		t.Errorf("unexpected path %#v", path)
+	}
+
+	bad := []struct {
+		tag string
+		pos int
+	}{
+		{"", 1},
+		{"1Count", 1},
+		{"Count.", 7},
+		{"Values[3", 9},
+		{"Values[]", 8},
+		{"Values[a]", 8},
+		{"Values[1,2,3,4]", 14},
+		{"Values[99999999999]", 8},
+		{"Word.5.1", 7},
+		{"Word.64", 6},
+		{"Program:Main", 13},
+		{"Program:Main.Mod:1", 17},
+		{"Two Words", 4},
+		{strings.Repeat("x", 41), 1},
+	}
+	for _, tt := range bad {
+		_, err := ParseTagPath(tt.tag)
+		var pathErr *PathError
+		if !errors.As(err, &pathErr) || !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("%q: expected a PathError, got %v", tt.tag, err)
+			continue
+		}
+		if pathErr.Pos+1 != tt.pos {
+			t.Errorf("%q: expected position %d, got %v", tt.tag, tt.pos, err)
+		}
+	}
+}
+
+func TestReadLINTBits(t *testing.T) {
+	//# bits above 31 are in the upper half of the LINT
+	sim := newSim(t)
+	if err := sim.AddTag(eipsim.Tag{Name: "Wide", Type: "LINT", Value: -1 << 62}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+	for tag, expected := range map[string]bool{
+		"Big.40": true,
+		"Big.39": false,
+		"Big.41": false,
+		"Big.8": false,
+		"Wide.62": true,
+		"Wide.63": true,
+		"Wide.61": false,
+	} {
+		values, err := plc.Read(tag)
+		if err != nil {
+			t.Errorf("%s: %v", tag, err)
+			continue
+		}
+		if values[0] != expected {
+			t.Errorf("%s: expected %v, got %v", tag, expected, values[0])
+		}
+	}
+	values, err := plc.Read("Big.39", 3)
+	if err != nil || !reflect.DeepEqual(values, []interface{}{false, true, false}) {
+		t.Errorf("Big.39 x3: expected false true false, got %v, %v", values, err)
+	}
+
+	if err := plc.Write("Big.50", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := sim.Get("Big"); err != nil || v != int64(1 << 40 | 1 << 50) {
+		t.Errorf("writing Big.50 should set bit 50 alone, got %v, %v", v, err)
+	}
+	if values, err := plc.Read("Big.50"); err != nil || values[0] != true {
+		t.Errorf("Big.50: expected true, got %v, %v", values, err)
+	}
+}
+
+func TestReadTagPaths(t *testing.T) {
+	sim := newSim(t)
+	long := make([]int, 300)
+	for i := range long {
+		long[i] = i
+	}
+	grid := make([]int, 12)
+	for i := range grid {
+		grid[i] = i*10
+	}
+	bits := make([]bool, 96)
+	bits[40], bits[70] = true, true
+	for _, tag := range []eipsim.Tag{
+		{Name: "Long", Type: "DINT", Dims: []int{300}, Value: long},
+		{Name: "Grid", Type: "DINT", Dims: []int{3, 4}, Value: grid},
+		{Name: "Many", Type: "BOOL", Dims: []int{96}, Value: bits},
+		{Name: "Local:1:I", Type: "Motor", Value: map[string]interface{}{"Counts": []int{0, 0x20}}},
+	} {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plc := newPLC(t, sim)
+
+	tests := []struct {
+		tag string
+		expected interface{}
+	}{
+		{"Long[299]", int32(299)},
+		{"Grid[2,1]", int32(90)},
+		{"Many[40]", true},
+		{"Many[41]", false},
+		{"Many[70]", true},
+		{"Local:1:I.Counts[1]", int32(0x20)},
+		{"Local:1:I.Counts[1].5", true},
+		{"Local:1:I.Counts[1].4", false},
+	}
+	for _, tt := range tests {
+		values, err := plc.Read(tt.tag)
+		if err != nil {
+			t.Errorf("%s: %v", tt.tag, err)
+			continue
+		}
+		if len(values) != 1 || values[0] != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.tag, tt.expected, values)
+		}
+	}
+
+	if _, err := plc.Read("Long[1"); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("expected ErrInvalidTag, got %v", err)
+	}
+	responses, err := plc.MultiRead([]string{"Long[256]", "Grid[", "Count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 || responses[0].Value != int32(256) || !errors.Is(responses[1].Err, ErrInvalidTag) || responses[2].Value != int32(42) {
+		t.Errorf("unexpected responses %+v", responses)
+	}
+}
+
+func TestSymbolInstances(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.SymbolInstances = true
+	var out strings.Builder
+	plc.Logger = NewStdLogger(log.New(&out, "", 0), LogInfo)
+
+	//# names until there's a tag list
+	path, _ := ParseTagPath("M1.Counts[1]")
+	if _, byInstance := plc._tagIOI(path, false); byInstance {
+		t.Error("no instances before the tag list is read")
+	}
+	var acc testutil.Accumulator
+	plc.TagsToRead = []string{"Count"}
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	ioi, byInstance := plc._tagIOI(path, false)
+	if !byInstance || !bytes.Equal(ioi[:4], []byte{0x20, 0x6B, 0x25, 0x00}) {
+		t.Fatalf("expected an instance path, got % X", ioi)
+	}
+	program, _ := ParseTagPath("Program:MainProgram.Step")
+	if _, byInstance := plc._tagIOI(program, false); byInstance {
+		t.Error("program scoped tags should keep their names")
+	}
+	if v, err := plc.Read("M1.Counts[1]"); err != nil || v[0] != int32(8) {
+		t.Errorf("M1.Counts[1]: got %v, %v", v, err)
+	}
+
+	//# a download gives Count a new instance
+	if err := sim.RemoveTag("Count"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "Count", Type: "DINT", Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := plc.Read("Count"); err != nil || v[0] != int32(7) {
+		t.Errorf("Count after the download: got %v, %v", v, err)
+	}
+	if !strings.Contains(out.String(), "symbol instances are stale") {
+		t.Errorf("the stale instances should be logged:\n%s", out.String())
+	}
+	tagList, _ := plc.GetTagList()
+	for _, tag := range tagList {
+		if tag.TagName == "Count" && plc.symbols["COUNT"].InstanceID != tag.InstanceID {
+			t.Errorf("expected the reloaded instance %d, got %d", tag.InstanceID, plc.symbols["COUNT"].InstanceID)
+		}
+	}
+
+	out.Reset()
+	if err := sim.RemoveTag("Temp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "Temp", Type: "REAL", Value: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	responses, err := plc.MultiRead([]string{"Temp", "Count", "Missing", "Program:MainProgram.Step"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{float32(1.5), int32(7), nil, int32(3)}
+	for i, r := range responses {
+		if r.Value != expected[i] {
+			t.Errorf("%s: expected %v, got %v (%v)", r.TagName, expected[i], r.Value, r.Err)
+		}
+	}
+	if !errors.Is(responses[2].Err, ErrTagNotFound) {
+		t.Errorf("Missing: expected ErrTagNotFound, got %v", responses[2].Err)
+	}
+	if strings.Count(out.String(), "symbol instances are stale") != 1 {
+		t.Errorf("expected one reload:\n%s", out.String())
+	}
+}
+
+func TestSymbolInstancesRenumbered(t *testing.T) {
+	//# a download that moves instances onto other tags that exist
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.SymbolInstances = true
+	plc.ChangeDetectionInterval = config.Duration(time.Hour)
+	plc.TagsToRead = []string{"Count", "Temp", "Name"}
+	var acc testutil.Accumulator
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := ParseTagPath("Count")
+	before, _ := plc._tagIOI(path, false)
+
+	sim.RenumberInstances()
+	acc.ClearMetrics()
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	after, byInstance := plc._tagIOI(path, false)
+	if !byInstance || bytes.Equal(before, after) {
+		t.Errorf("expected Count's new instance, still % X", after)
+	}
+	for tag, value := range map[string]interface{}{"Count": int32(42), "Temp": float32(21.5), "Name": "hello"} {
+		acc.AssertContainsTaggedFields(t, "eip",
+			map[string]interface{}{"value": value},
+			map[string]string{"TagName": tag})
+	}
+	acc.AssertContainsFields(t, "project_changed", map[string]interface{}{"detected_by": "poll"})
+	if len(acc.Errors) > 0 {
+		t.Errorf("unexpected errors %v", acc.Errors)
+	}
+}
+
+func TestMultiRead(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	tags := []string{"Temp", "Count", "Missing", "Name"}
+	responses, err := plc.MultiRead(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 4 {
+		t.Fatalf("expected 4 responses, got %v", responses)
+	}
+	for i, r := range responses {
+		if r.TagName != tags[i] {
+			t.Errorf("response %d is for %s, expected %s", i, r.TagName, tags[i])
+		}
+	}
+	if responses[0].Value != float32(21.5) || responses[1].Value != int32(42) || responses[3].Value != "hello" {
+		t.Errorf("unexpected responses %v", responses)
+	}
+	if r := responses[1]; r.CIPType != 0xC4 || r.Elements != 1 || r.Status != 0 || r.Err != nil {
+		t.Errorf("unexpected response for Count %+v", r)
+	}
+	if r := responses[2]; r.Status != 0x05 || r.Value != nil || !errors.Is(r.Err, ErrTagNotFound) {
+		t.Errorf("expected ErrTagNotFound with status 0x05 for Missing, got %+v", r)
+	}
+}
+
+func TestReadPlan(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddType(eipsim.Type{Name: "Batch", Members: []eipsim.Member{
+		{Name: "ID", Type: "DINT"},
+		{Name: "Product", Type: "STRING"},
+		{Name: "Weights", Type: "REAL", Dims: []int{8}},
+		{Name: "Done", Type: "BOOL"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	temps := make([]float64, 200)
+	for i := range temps {
+		temps[i] = float64(i)/2
+	}
+	for _, tag := range []eipsim.Tag{
+		{Name: "Temps", Type: "REAL", Dims: []int{200}, Value: temps},
+		{Name: "Batches", Type: "Batch", Dims: []int{2}, Value: []interface{}{nil, map[string]interface{}{
+			"ID": 12, "Product": "Rye", "Weights": []float64{0, 0, 2.5}, "Done": true,
+		}}},
+	} {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plc := newPLC(t, sim)
+
+	//# 150 REALs are two runs, one of them a full reply
+	var tags []string
+	for i := 0; i < 150; i++ {
+		tags = append(tags, fmt.Sprintf("Temps[%d]", i))
+	}
+	tags = append(tags, "Temps[3]")
+	responses, err := plc.MultiRead(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequence := plc.SequenceCounter
+	if responses, err = plc.MultiRead(tags); err != nil {
+		t.Fatal(err)
+	}
+	if packets := plc.SequenceCounter-sequence; packets != 2 {
+		t.Errorf("expected 2 packets, sent %d", packets)
+	}
+	for i, r := range responses {
+		index := i
+		if i == 150 {
+			index = 3
+		}
+		if r.TagName != tags[i] || r.Value != float32(index)/2 || r.CIPType != 0xCA || r.Elements != 1 || r.Err != nil {
+			t.Fatalf("response %d: unexpected %+v", i, r)
+		}
+	}
+
+	//# the same element twice: one joins the run, the other keeps a read of its own
+	tags = []string{"Values[3]", "Values[3]", "Values[4]"}
+	all := []int{0, 1, 2}
+	reads := plc._planReads(tags, all, make([]Response, len(tags)), false, true)
+	if len(reads) != 2 || len(reads[0].targets) != 2 || len(reads[1].targets) != 1 || reads[1].shared {
+		t.Errorf("expected a run of Values[3] and Values[4] and a read of Values[3], got %d reads", len(reads))
+	}
+	if responses, err = plc.MultiRead(tags); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int32{3, 3, 4} {
+		if r := responses[i]; r.TagName != tags[i] || r.Value != expected || r.Err != nil {
+			t.Errorf("response %d: unexpected %+v", i, r)
+		}
+	}
+
+	//# a run past the end of the array fails alone, its tags are read again one by one
+	responses, err = plc.MultiRead([]string{"Values[8]", "Values[9]", "Values[10]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].Value != int32(8) || responses[1].Value != int32(9) {
+		t.Errorf("unexpected responses %+v", responses)
+	}
+	if r := responses[2]; r.Status != 0x05 || !errors.Is(r.Err, ErrTagNotFound) {
+		t.Errorf("expected ErrTagNotFound for Values[10], got %+v", r)
+	}
+
+	//# members come out of one read of their structure, the same as read on their own
+	if _, err := plc.GetTagList(); err != nil {
+		t.Fatal(err)
+	}
+	tags = []string{"M1.Speed", "M1.Running", "M1.Faulted", "M1.Counts[1]", "M1.Counts[3]", "Count",
+		"Batches[1].ID", "Batches[1].Product", "Batches[1].Weights[2]", "Batches[1].Done", "M1.Counts[1].2"}
+	all = make([]int, len(tags))
+	for i := range all {
+		all[i] = i
+	}
+	if reads := plc._planReads(tags, all, make([]Response, len(tags)), false, true); len(reads) != 4 {
+		t.Errorf("expected M1, Count, Batches[1] and the bit to be read, got %d reads", len(reads))
+	}
+	responses, err = plc.MultiRead(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tag := range tags {
+		single, err := plc.MultiRead([]string{tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(responses[i], single[0]) || single[0].Err != nil {
+			t.Errorf("%s: planned %+v, on its own %+v", tag, responses[i], single[0])
+		}
+	}
+	if responses[1].Value != true || responses[7].Value != "Rye" || responses[8].Value != float32(2.5) {
+		t.Errorf("unexpected responses %+v", responses)
+	}
+}
+
+func TestGetTagList(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	tags, err := plc.GetTagList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]LGXTag)
+	for _, tag := range tags {
+		names[tag.TagName] = tag
+	}
+	for _, name := range []string{"Temp", "Values", "M1", "Program:MainProgram.Step"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("%s missing from the tag list %v", name, plc.FilterTagList(0))
+		}
+	}
+	if !names["M1"].IsStruct {
+		t.Error("M1 should be a structure")
+	}
+	if names["Values"].ArrayDims != 1 {
+		t.Errorf("Values should have one dimension, got %d", names["Values"].ArrayDims)
+	}
+	if len(plc.ProgramNames) != 1 || plc.ProgramNames[0] != "Program:MainProgram" {
+		t.Errorf("unexpected programs %v", plc.ProgramNames)
+	}
+}
+
+func TestTagListAttributes(t *testing.T) {
+	sim := newSim(t)
+	for _, tag := range []eipsim.Tag{
+		{Name: "Grid", Type: "DINT", Dims: []int{3, 4}},
+		{Name: "Setpoint", Type: "REAL", Value: 5.0, ReadOnly: true},
+		{Name: "Secret", Type: "DINT", Value: 7, NoAccess: true},
+	} {
+		if err := sim.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plc := newPLC(t, sim)
+	tags, err := plc.GetTagList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]LGXTag)
+	for _, tag := range tags {
+		names[tag.TagName] = tag
+	}
+
+	if grid := names["Grid"]; !reflect.DeepEqual(grid.Dimensions, []int{3, 4}) || grid.Elements() != 12 {
+		t.Errorf("Grid: dimensions %v, %d elements", grid.Dimensions, grid.Elements())
+	}
+	if bits := names["Bits"]; bits.Elements() != 64 {
+		t.Errorf("Bits should hold 64 elements, got %d", bits.Elements())
+	}
+	if a := names["Setpoint"].ExternalAccess; a != AccessReadOnly || a.String() != "Read Only" {
+		t.Errorf("Setpoint access %v", a)
+	}
+	if a := names["Secret"].ExternalAccess; a != AccessNone || names["Secret"].Readable() {
+		t.Errorf("Secret access %v", a)
+	}
+	if a := names["Temp"].ExternalAccess; a != AccessReadWrite {
+		t.Errorf("Temp access %v", a)
+	}
+	if m1 := names["M1"]; m1.TemplateInstance == 0 || m1.ElementSize == 0 {
+		t.Errorf("M1: template %d, element size %d", m1.TemplateInstance, m1.ElementSize)
+	}
+	for _, name := range plc.FilterTagList(0) {
+		if name == "Secret" {
+			t.Error("FilterTagList should leave out tags without access")
+		}
+	}
+
+	for _, c := range []struct {
+		tag string
+		elements int
+		err error
+	}{
+		{"Values[8]", 5, ErrOverflow},
+		{"Values[10]", 1, ErrOverflow},
+		{"Grid[3,0]", 1, ErrOverflow},
+		{"Grid[1]", 1, ErrInvalidTag},
+		{"Bits[60]", 5, ErrOverflow},
+		{"Secret", 1, ErrNoAccess},
+	} {
+		if _, err := plc.Read(c.tag, c.elements); !errors.Is(err, c.err) {
+			t.Errorf("%s x%d: expected %v, got %v", c.tag, c.elements, c.err, err)
+		}
+	}
+	if _, err := plc.Read("Grid[2,3]"); err != nil {
+		t.Errorf("Grid[2,3]: %v", err)
+	}
+	if _, err := plc.Read("Bits[63]"); err != nil {
+		t.Errorf("Bits[63]: %v", err)
+	}
+
+	responses, err := plc.MultiRead([]string{"Temp", "Secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].Err != nil || !errors.Is(responses[1].Err, ErrNoAccess) {
+		t.Errorf("unexpected responses %+v", responses)
+	}
+}
+
+func TestExportTagList(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	var buf bytes.Buffer
+	if err := plc.ExportTagList(&buf, ExportJSON); err != nil {
+		t.Fatal(err)
+	}
+	var tags []TagInfo
+	if err := json.Unmarshal(buf.Bytes(), &tags); err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]TagInfo)
+	for i, tag := range tags {
+		byPath[tag.Path] = tag
+		if i > 0 && strings.ToUpper(tags[i-1].Path) > strings.ToUpper(tag.Path) {
+			t.Errorf("%s before %s", tags[i-1].Path, tag.Path)
+		}
+	}
+	if bits := byPath["Bits"]; bits.Type != "BOOL" || !reflect.DeepEqual(bits.Dimensions, []int{64}) {
+		t.Errorf("unexpected Bits %+v", bits)
+	}
+	if name := byPath["Name"]; name.Type != "STRING" || len(name.Members) != 0 {
+		t.Errorf("unexpected Name %+v", name)
+	}
+	m1 := byPath["M1"]
+	if m1.Type != "Motor" || !m1.Struct || m1.Access != "Read/Write" {
+		t.Errorf("unexpected M1 %+v", m1)
+	}
+	want := []MemberInfo{
+		{Name: "Speed", Path: "M1.Speed", Type: "REAL"},
+		{Name: "Running", Path: "M1.Running", Type: "BOOL"},
+		{Name: "Faulted", Path: "M1.Faulted", Type: "BOOL"},
+		{Name: "Counts", Path: "M1.Counts", Type: "DINT", Dimensions: []int{4}},
+	}
+	if !reflect.DeepEqual(m1.Members, want) {
+		t.Errorf("M1 members %+v, want %+v", m1.Members, want)
+	}
+
+	buf.Reset()
+	if err := plc.ExportTagList(&buf, ExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	csv := buf.String()
+	for _, row := range []string{
+		"path,type,dimensions,struct,system,access\n",
+		"M1,Motor,,true,false,Read/Write\n",
+		"M1.Counts,DINT,4,false,false,Read/Write\n",
+		"Program:MainProgram.Step,DINT,,false,false,Read/Write\n",
+	} {
+		if !strings.Contains(csv, row) {
+			t.Errorf("%q missing from\n%s", row, csv)
+		}
+	}
+
+	buf.Reset()
+	if err := plc.ExportTagList(&buf, ExportYAML); err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML []TagInfo
+	if err := yaml.Unmarshal(buf.Bytes(), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, tags) {
+		t.Errorf("YAML and JSON inventories differ:\n%s", buf.String())
+	}
+
+	if err := plc.ExportTagList(&buf, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestGetTagListPaged(t *testing.T) {
+	sim := eipsim.New()
+	for i := 0; i < 100; i++ {
+		if err := sim.AddTag(eipsim.Tag{Name: "Tag_" + strconv.Itoa(i), Type: "DINT"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plc := newPLC(t, sim)
+
+	tags, err := plc.GetTagList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 100 {
+		t.Errorf("expected 100 tags, got %d", len(tags))
+	}
+}
+
+func TestGetPLCTime(t *testing.T) {
+	sim := newSim(t)
+	now := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+	sim.Clock = func() time.Time { return now }
+	plc := newPLC(t, sim)
+
+	plcTime, err := plc.GetPLCTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plcTime.Equal(now) {
+		t.Errorf("expected %v, got %v", now, plcTime)
+	}
+}
+
+func TestSetPLCTime(t *testing.T) {
+	sim := newSim(t)
+	now := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+	sim.Clock = func() time.Time { return now }
+	plc := newPLC(t, sim)
+
+	set := time.Date(2024, 2, 29, 8, 15, 30, 250000000, time.UTC)
+	if err := plc.SetPLCTime(set); err != nil {
+		t.Fatal(err)
+	}
+	plcTime, err := plc.GetPLCTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plcTime.Equal(set) {
+		t.Errorf("expected %v, got %v", set, plcTime)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddTag(eipsim.Tag{Name: "Setpoint", Type: "REAL", Value: 5.0, ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+
+	for _, c := range []struct {
+		tag string
+		values []interface{}
+		expected interface{}
+		check string
+	}{
+		{"Temp", []interface{}{float32(30.5)}, float32(30.5), "Temp"},
+		{"Temp", []interface{}{"12.25"}, float32(12.25), "Temp"},
+		{"Count", []interface{}{int32(-5)}, int32(-5), "Count"},
+		{"Count", []interface{}{"0x10"}, int32(16), "Count"},
+		{"Small", []interface{}{100}, int8(100), "Small"},
+		{"Flag", []interface{}{"false"}, false, "Flag"},
+		{"Name", []interface{}{"written"}, "written", "Name"},
+		{"Values[7]", []interface{}{70, 80, 90}, []interface{}{int32(6), int32(70), int32(80), int32(90)}, "Values"},
+		{"Count.3", []interface{}{true}, int32(24), "Count"},
+		{"Count.4", []interface{}{false}, int32(8), "Count"},
+		{"Bits[40]", []interface{}{true}, true, "Bits[40]"},
+		{"Bits[1]", []interface{}{"false"}, false, "Bits[1]"},
+		{"M1.Running", []interface{}{false}, false, "M1.Running"},
+		{"M1.Counts[3]", []interface{}{int32(33)}, int32(33), "M1.Counts[3]"},
+	} {
+		if err := plc.Write(c.tag, c.values...); err != nil {
+			t.Errorf("%s: %v", c.tag, err)
+			continue
+		}
+		var got interface{}
+		var err error
+		if c.check == "Values" {
+			var values []interface{}
+			values, err = plc.Read("Values[6]", 4)
+			got = values
+		} else {
+			var values []interface{}
+			values, err = plc.Read(c.check)
+			if err == nil {
+				got = values[0]
+			}
+		}
+		if err != nil || !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", c.tag, c.expected, got, err)
+		}
+	}
+	if v, _ := sim.Get("Bits"); v.([]interface{})[1] != false || v.([]interface{})[3] != true {
+		t.Errorf("writing Bits[1] changed the other bits: %v", v)
+	}
+
+	for _, c := range []struct {
+		tag string
+		value interface{}
+		err error
+	}{
+		{"Small", 300, ErrOverflow},
+		{"Count", 1.5, ErrTypeMismatch},
+		{"Count", "twelve", ErrTypeMismatch},
+		{"Temp.3", true, ErrTypeMismatch},
+		{"Count.40", true, ErrOverflow},
+		{"M1", "x", ErrTypeMismatch},
+		{"Setpoint", 1.0, ErrNoAccess},
+		{"Missing", 1, ErrTagNotFound},
+	} {
+		if err := plc.Write(c.tag, c.value); !errors.Is(err, c.err) {
+			t.Errorf("%s = %v: expected %v, got %v", c.tag, c.value, c.err, err)
+		}
+	}
+
+	//# read only is also enforced by the controller before the tag list is known
+	plc = &PLC{IPAddress: plc.IPAddress, Port: plc.Port}
+	if err := plc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer plc.Close()
+	var cipErr *CIPError
+	if err := plc.Write("Setpoint", 1.0); !errors.Is(err, ErrNoAccess) || !errors.As(err, &cipErr) || cipErr.Class != 0x6B {
+		t.Errorf("expected ErrNoAccess from the Symbol Object, got %v", err)
+	}
+
+	//# write errors name the object the path went to
+	path, _ := ParseTagPath("Count")
+	for _, tt := range []struct {
+		ioi []byte
+		class byte
+	}{
+		{path._ioi(false), 0x6B},
+		{path._instanceIOI(0x1234, false), 0x6B},
+		{[]byte{0x20, 0x6C, 0x24, 0x01}, 0x6C},
+		{[]byte{0x20, 0x8B, 0x24, 0x01}, 0x8B},
+	} {
+		if class := _ioiClass(tt.ioi); class != tt.class {
+			t.Errorf("% X: expected class 0x%02X, got 0x%02X", tt.ioi, tt.class, class)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	sim := newSim(t)
+	sim.Slot = 2
+	sim.Modules = map[int]eipsim.Identity{
+		0: {VendorID: 1, DeviceType: 0x0C, ProductCode: 0xA6, Major: 11, Minor: 2, SerialNumber: 0x1111, ProductName: "1756-EN2T/D"},
+		5: {VendorID: 1, DeviceType: 0x07, ProductCode: 0x5B, Major: 3, Minor: 1, SerialNumber: 0x2222, ProductName: "1756-IB16/B"},
+	}
+	plc := newPLC(t, sim)
+	plc.ProcessorSlot = 2
+
+	modules, err := plc.GetModules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 3 {
+		t.Fatalf("expected 3 modules, got %+v", modules)
+	}
+	for i, want := range []struct {
+		slot int
+		name string
+		revision string
+	}{
+		{0, "1756-EN2T/D", "11.002"},
+		{2, "1756-L83E/B", "33.011"},
+		{5, "1756-IB16/B", "3.001"},
+	} {
+		m := modules[i]
+		if m.Slot != want.slot || m.ProductName != want.name || m.Revision != want.revision {
+			t.Errorf("module %d: expected slot %d %s %s, got %+v", i, want.slot, want.name, want.revision, m)
+		}
+	}
+
+	id, err := plc.GetModuleProperties(5)
+	if err != nil || id.SerialNumber != 0x2222 || id.DeviceType != 0x07 {
+		t.Errorf("slot 5: %+v, %v", id, err)
+	}
+	var cipErr *CIPError
+	if _, err := plc.GetModuleProperties(9); !errors.As(err, &cipErr) || cipErr.Status != 0x01 {
+		t.Errorf("expected a connection failure for an empty slot, got %v", err)
+	}
+	//# an empty slot says nothing about the session to the controller
+	if _, err := plc.Read("Count"); err != nil {
+		t.Errorf("reading after an empty slot: %v", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	id, err := plc.GetIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.ProductName != "1756-L83E/B" || id.SerialNumber != 0x00C0FFEE || id.Revision != "33.011" || id.IPAddress != "127.0.0.1" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	sim := newSim(t)
+	addr, err := sim.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	found, err := Discover(addr.String(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ProductName != "1756-L83E/B" || found[0].IPAddress != "127.0.0.1" {
+		t.Errorf("unexpected devices %+v", found)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := DiscoverContext(ctx, addr.String()); err != nil {
+		t.Errorf("a cancelled discovery should return what it found, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("cancelling didn't stop the discovery")
+	}
+}
+
+func TestUnconnected(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+	plc.Unconnected = true
+
+	values, err := plc.Read("Count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != int32(42) {
+		t.Errorf("expected 42, got %v", values[0])
+	}
+	if plc.ForwardOpened {
+		t.Error("unconnected mode shouldn't open a connection")
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	for _, c := range []struct {
+		route string
+		hops []RouteHop
+		encoded []byte
+	}{
+		{"", nil, nil},
+		{"1,0", []RouteHop{{1, "0"}}, []byte{0x01, 0x00}},
+		{" 1, 3 ", []RouteHop{{1, "3"}}, []byte{0x01, 0x03}},
+		//# 12 characters of IP, even with the type and size bytes
+		{"1,0,2,192.168.5.10,1,3", []RouteHop{{1, "0"}, {2, "192.168.5.10"}, {1, "3"}},
+			append(append([]byte{0x01, 0x00, 0x12, 12}, "192.168.5.10"...), 0x01, 0x03)},
+		//# 9 characters, padded
+		{"2,10.0.0.15", []RouteHop{{2, "10.0.0.15"}}, append(append([]byte{0x12, 9}, "10.0.0.15"...), 0x00)},
+		//# ports from 15 on follow the type as a word
+		{"18,4", []RouteHop{{18, "4"}}, []byte{0x0F, 0x12, 0x00, 0x04}},
+		{"15,1", []RouteHop{{15, "1"}}, []byte{0x0F, 0x0F, 0x00, 0x01}},
+		{"300,10.0.0.1", []RouteHop{{300, "10.0.0.1"}}, append([]byte{0x1F, 8, 0x2C, 0x01}, "10.0.0.1"...)},
+		{"300,10.0.0.15", []RouteHop{{300, "10.0.0.15"}}, append(append([]byte{0x1F, 9, 0x2C, 0x01}, "10.0.0.15"...), 0x00)},
+	} {
+		hops, err := ParseRoute(c.route)
+		if err != nil {
+			t.Errorf("%q: %v", c.route, err)
+			continue
+		}
+		if len(hops) != len(c.hops) || (len(hops) > 0 && !reflect.DeepEqual(hops, c.hops)) {
+			t.Errorf("%q: expected %v, got %v", c.route, c.hops, hops)
+		}
+		if encoded := EncodeRoute(hops); !bytes.Equal(encoded, c.encoded) {
+			t.Errorf("%q: expected % X, got % X", c.route, c.encoded, encoded)
+		}
+	}
+
+	for _, route := range []string{
+		"1",
+		"1,0,2",
+		"0,1",
+		"x,1",
+		"70000,1",
+		"1,",
+		"1,256",
+		"1,-1",
+		"2,192.168.5.300",
+		"2,192.168.5",
+		"2,plc.local",
+	} {
+		if _, err := ParseRoute(route); err == nil {
+			t.Errorf("%q: expected an error", route)
+		}
+	}
+	plc := &PLC{IPAddress: "127.0.0.1", Route: "1,0,2"}
+	if err := plc.Init(); err == nil {
+		t.Error("Init should reject a malformed route")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	const route = "1,0,2,192.168.5.10,1,3"
+	hops, _ := ParseRoute(route)
+	encoded := EncodeRoute(hops)
+
+	sim := newSim(t)
+	sim.Slot = 3
+	plc := newPLC(t, sim)
+	plc.Route = route
+	values, err := plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Fatalf("expected 42, got %v, %v", values, err)
+	}
+	//# the route, then the message router
+	if !plc.ForwardOpened || !bytes.Equal(sim.LastRoute(), append(encoded, 0x20, 0x02, 0x24, 0x01)) {
+		t.Errorf("Forward Open path % X", sim.LastRoute())
+	}
+	plc.Close()
+
+	plc.Unconnected = true
+	values, err = plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Fatalf("expected 42, got %v, %v", values, err)
+	}
+	if plc.ForwardOpened || !bytes.Equal(sim.LastRoute(), encoded) {
+		t.Errorf("Unconnected Send route % X", sim.LastRoute())
+	}
+
+	//# a route to an empty slot comes back from the chassis
+	plc.Route = "1,5"
+	var cipErr *CIPError
+	if _, err := plc.Read("Count"); !errors.As(err, &cipErr) || cipErr.Status != 0x01 || cipErr.ExtStatus[0] != 0x0311 {
+		t.Errorf("expected Port not available, got %v", err)
+	}
+}
+
+func TestForwardOpenRejected(t *testing.T) {
+	sim := newSim(t)
+	sim.RejectForwardOpen = 0x0113
+	plc := newPLC(t, sim)
+
+	_, err := plc.Read("Count")
+	var cipErr *CIPError
+	if !errors.As(err, &cipErr) || cipErr.Status != 0x01 || len(cipErr.ExtStatus) == 0 || cipErr.ExtStatus[0] != 0x0113 {
+		t.Fatalf("expected a Connection Manager error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Out of connections") {
+		t.Errorf("error should explain the extended status: %v", err)
+	}
+	for _, tt := range []struct {
+		err *CIPError
+		expected string
+	}{
+		{&CIPError{Service: 0x4C, Class: 0x6B, Path: "Count", Status: 0xFF, ExtStatus: []uint16{0x2105}},
+			"eip: service 0x4C Count: General error (0xFF), Number of elements goes beyond the end of the tag (0x2105)"},
+		{&CIPError{Service: 0x03, Class: 0xAC, Path: "change detection", Status: 0xFF, ExtStatus: []uint16{0x2101, 0x0004}},
+			"eip: service 0x03 change detection: General error (0xFF), extended status (0x2101 0x0004)"},
+		{&CIPError{Service: 0x0A, Class: 0x02, Status: 0x1E, ExtStatus: []uint16{0x0001}},
+			"eip: service 0x0A: Embedded service error (0x1E), extended status (0x0001)"},
+	} {
+		if got := tt.err.Error(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+
+	plc.UnconnectedFallback = true
+	values, err := plc.Read("Count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != int32(42) {
+		t.Errorf("expected 42, got %v", values[0])
+	}
+}
+
+func TestMicro800(t *testing.T) {
+	sim := eipsim.NewMicro800()
+	if err := sim.AddTag(eipsim.Tag{Name: "Count", Type: "DINT", Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "Name", Type: "STRING", Value: "micro"}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+
+	responses, err := plc.MultiRead([]string{"Count", "Name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plc.Micro800 {
+		t.Error("Micro800 should have been detected from the identity")
+	}
+	if responses[0].Value != int32(7) || responses[1].Value != "micro" {
+		t.Errorf("unexpected responses %v", responses)
+	}
+}
+
+func TestParsePCCCAddress(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		expected PCCCAddress
+	}{
+		{"N7:0", PCCCAddress{FileType: "N", FileNumber: 7, Element: 0, Bit: -1}},
+		{"F8:10", PCCCAddress{FileType: "F", FileNumber: 8, Element: 10, Bit: -1}},
+		{"B3:0/5", PCCCAddress{FileType: "B", FileNumber: 3, Element: 0, Bit: 5}},
+		{"B3/21", PCCCAddress{FileType: "B", FileNumber: 3, Element: 1, Bit: 5}},
+		{"T4:2.ACC", PCCCAddress{FileType: "T", FileNumber: 4, Element: 2, SubElement: 2, Bit: -1}},
+		{"C5:0.DN", PCCCAddress{FileType: "C", FileNumber: 5, Element: 0, Bit: 13}},
+		{"ST9:1", PCCCAddress{FileType: "ST", FileNumber: 9, Element: 1, Bit: -1}},
+		{"O:0/3", PCCCAddress{FileType: "O", FileNumber: 0, Element: 0, Bit: 3}},
+		{" n7:300 ", PCCCAddress{FileType: "N", FileNumber: 7, Element: 300, Bit: -1}},
+	} {
+		addr, err := ParsePCCCAddress(tt.address)
+		if err != nil {
+			t.Errorf("%q: %v", tt.address, err)
+			continue
+		}
+		tt.expected.Address = tt.address
+		if addr != tt.expected {
+			t.Errorf("%q: expected %+v, got %+v", tt.address, tt.expected, addr)
+		}
+	}
+
+	for _, address := range []string{
+		"",
+		"N7", //# no element
+		"N:0", //# N has no default file
+		"X7:0", //# unknown file type
+		"N7:0/16", //# bit out of range
+		"F8:0/1", //# bits only in word files
+		"T4:2", //# timers need a sub-element
+		"T4:2.XYZ",
+		"N7:0.ACC",
+		"N7/3", //# only bit files take a bit alone
+		"N7:70000",
+		"N7:0:1",
+	} {
+		if _, err := ParsePCCCAddress(address); err == nil {
+			t.Errorf("%q: expected an error", address)
+		}
+	}
+}
+
+func TestPCCCEncoding(t *testing.T) {
+	//# the address fields, with the 0xFF escape for values above 254
+	addr, _ := ParsePCCCAddress("N7:300")
+	if got, expected := addr._encode(addr.Element, 2), []byte{0x02, 0x07, 0x89, 0xFF, 0x2C, 0x01, 0x00}; !bytes.Equal(got, expected) {
+		t.Errorf("N7:300: expected % X, got % X", expected, got)
+	}
+
+	//# ST words are stored with their bytes swapped, odd lengths pad the last one
+	data := _pcccStringBytes("abc")
+	if len(data) != 84 || !bytes.Equal(data[:6], []byte{0x03, 0x00, 'b', 'a', 0x00, 'c'}) {
+		t.Errorf("unexpected ST encoding % X", data[:6])
+	}
+	for _, s := range []string{"", "a", "abc", "hello!", strings.Repeat("x", 81), strings.Repeat("y", 82)} {
+		if got := _pcccString(_pcccStringBytes(s)); got != s {
+			t.Errorf("ST round trip of %q gave %q", s, got)
+		}
+	}
+	if got := _pcccString(_pcccStringBytes(strings.Repeat("z", 90))); got != strings.Repeat("z", 82) {
+		t.Errorf("ST strings should be cut at 82 characters, got %d", len(got))
+	}
+
+	for _, tt := range []struct {
+		address string
+		value interface{}
+		expected interface{}
+	}{
+		{"N7:0", -1234, int16(-1234)},
+		{"N7:0", int16(32767), int16(32767)},
+		{"F8:0", 21.5, float32(21.5)},
+		{"F8:0", 3, float32(3)},
+		{"L9:0", int32(-70000), int32(-70000)},
+		{"ST10:0", "odd", "odd"},
+		{"ST10:0", "even", "even"},
+		{"T4:0.ACC", 150, int16(150)},
+	} {
+		addr, err := ParsePCCCAddress(tt.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := addr._encodeValue(tt.value)
+		if err != nil {
+			t.Errorf("%s %v: %v", tt.address, tt.value, err)
+			continue
+		}
+		if len(data) != addr._elementSize() {
+			t.Errorf("%s: encoded %d bytes, expected %d", tt.address, len(data), addr._elementSize())
+		}
+		if got := addr._decode(data, 1)[0]; got != tt.expected {
+			t.Errorf("%s: %v round tripped to %v (%T)", tt.address, tt.value, got, got)
+		}
+	}
+
+	addr, _ = ParsePCCCAddress("N7:0")
+	for _, value := range []interface{}{70000, "x", 1.5} {
+		if _, err := addr._encodeValue(value); err == nil {
+			t.Errorf("N7:0: expected %v to be rejected", value)
+		}
+	}
+}
+
+func newPCCCSim(t *testing.T) *eipsim.Server {
+	sim := eipsim.New()
+	sim.Identity.ProductName = "1747-L551 C/5.05"
+	words := func(vals ...int16) []byte {
+		b := new(bytes.Buffer)
+		binary.Write(b, binary.LittleEndian, vals)
+		return b.Bytes()
+	}
+	floats := new(bytes.Buffer)
+	binary.Write(floats, binary.LittleEndian, []float32{0, 21.5, -3.25})
+	files := []struct {
+		number uint16
+		typ string
+		data []byte
+	}{
+		{3, "B", words(0x0020, 0x0002)},
+		{4, "T", words(0, 1000, 250)},
+		{7, "N", words(42, -7, 300)},
+		{8, "F", floats.Bytes()},
+		{9, "ST", append(_pcccStringBytes("line 1"), _pcccStringBytes("abc")...)},
+	}
+	for _, f := range files {
+		if err := sim.AddFile(f.number, f.typ, f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sim
+}
+
+func newPCCC(t *testing.T, sim *eipsim.Server) *PLC {
+	addr, err := sim.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	host, port, _ := net.SplitHostPort(addr.String())
+	p, _ := strconv.Atoi(port)
+	plc := &PLC{IPAddress: host, Port: uint16(p), Protocol: "pccc"}
+	if err := plc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { plc.Close() })
+	return plc
+}
+
+func TestPCCC(t *testing.T) {
+	sim := newPCCCSim(t)
+	plc := newPCCC(t, sim)
+	p := &PCCC{PLC: plc}
+
+	values, err := p.Read("N7:0", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{int16(42), int16(-7), int16(300)}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("N7:0: expected %v, got %v", expected, values)
+	}
+
+	values, err = p.MultiRead([]string{"F8:1", "B3:0/5", "B3/17", "B3/16", "T4:0.ACC", "ST9:1", "N7:10", "N12:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{float32(21.5), true, true, false, int16(250), "abc"}
+	if !reflect.DeepEqual(values[:6], expected) {
+		t.Errorf("expected %v, got %v", expected, values[:6])
+	}
+	var pcccErr *PCCCError
+	if !errors.As(values[6].(error), &pcccErr) || pcccErr.Status != 0xF0 || pcccErr.ExtStatus != 0x06 {
+		t.Errorf("N7:10: expected an address error, got %v", values[6])
+	}
+	if _, ok := values[7].(error); !ok {
+		t.Errorf("N12:0: expected an error for a missing file, got %v", values[7])
+	}
+
+	for address, value := range map[string]interface{}{
+		"N7:1": 1234,
+		"F8:2": float32(-1.5),
+		"T4:0.PRE": 2000,
+		"ST9:0": "written",
+		"B3:0/0": true,
+		"B3:0/5": false,
+	} {
+		if err := p.Write(address, value); err != nil {
+			t.Errorf("writing %s: %v", address, err)
+		}
+	}
+	values, err = p.MultiRead([]string{"N7:1", "F8:2", "T4:0.PRE", "ST9:0", "B3:0/0", "B3:0/5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []interface{}{int16(1234), float32(-1.5), int16(2000), "written", true, false}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v after writing, got %v", expected, values)
+	}
+
+	//# the masked write only touches its own bit
+	data, _ := sim.File(3)
+	if word := binary.LittleEndian.Uint16(data); word != 0x0001 {
+		t.Errorf("B3:0 should be 0x0001, got 0x%04X", word)
+	}
+	if word := binary.LittleEndian.Uint16(data[2:]); word != 0x0002 {
+		t.Errorf("B3:1 shouldn't have changed, got 0x%04X", word)
+	}
+
+	if err := p.Write("B3:0/1", 1); err == nil {
+		t.Error("bits should only take bools")
+	}
+}
+
+//# a context that has run out without the transport noticing, as when it
+//# expires right after a reply came in
+type lapsedContext struct {
+	context.Context
+}
+
+func (lapsedContext) Err() error {
+	return context.DeadlineExceeded
+}
+
+func TestPCCCContextLapsed(t *testing.T) {
+	plc := newPCCC(t, newPCCCSim(t))
+	p := &PCCC{PLC: plc}
+	values, err := p.MultiReadContext(lapsedContext{context.Background()}, []string{"N7:0", "F8:1"})
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) || values != nil {
+		t.Errorf("expected the lapsed context as a timeout, got %v, %v", values, err)
+	}
+}
+
+func TestGatherPCCC(t *testing.T) {
+	plc := newPCCC(t, newPCCCSim(t))
+	plc.TagsToRead = []string{"N7:0", "F8:1", "ST9:0", "N7:10"}
+
+	var acc testutil.Accumulator
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	acc.AssertContainsTaggedFields(t, "eip",
+		map[string]interface{}{"value": int16(42)},
+		map[string]string{"TagName": "N7:0"})
+	acc.AssertContainsTaggedFields(t, "eip",
+		map[string]interface{}{"value": float32(21.5)},
+		map[string]string{"TagName": "F8:1"})
+	acc.AssertContainsTaggedFields(t, "eip",
+		map[string]interface{}{"value": "line 1"},
+		map[string]string{"TagName": "ST9:0"})
+	var pcccErr *PCCCError
+	if len(acc.Errors) != 1 || !errors.As(acc.Errors[0], &pcccErr) {
+		t.Errorf("expected one pccc error, got %v", acc.Errors)
+	}
+}
+
+func TestGather(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+	plc.TagsToRead = []string{"Temp", "Count", "Missing"}
+
+	var acc testutil.Accumulator
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	acc.AssertContainsTaggedFields(t, "eip",
+		map[string]interface{}{"value": float32(21.5)},
+		map[string]string{"TagName": "Temp"})
+	acc.AssertContainsTaggedFields(t, "eip",
+		map[string]interface{}{"value": int32(42)},
+		map[string]string{"TagName": "Count"})
+	if len(acc.Errors) != 1 || !errors.Is(acc.Errors[0], ErrTagNotFound) {
+		t.Errorf("expected one tag not found error, got %v", acc.Errors)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	plc := newPLC(t, newSim(t))
+
+	expected := map[string]interface{}{
+		"Temp": float32(21.5),
+		"Count": int32(42),
+		"Name": "hello",
+		"Values[7]": int32(7),
+		"M1.Speed": float32(1450),
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				for tag, value := range expected {
+					values, err := plc.Read(tag)
+					if err != nil {
+						errs <- fmt.Errorf("%s: %v", tag, err)
+						return
+					}
+					if values[0] != value {
+						errs <- fmt.Errorf("%s: expected %v, got %v", tag, value, values[0])
+						return
+					}
+				}
+				if g%2 == 0 {
+					responses, err := plc.MultiRead([]string{"Count", "Temp"})
+					if err != nil || responses[0].Value != int32(42) || responses[1].Value != float32(21.5) {
+						errs <- fmt.Errorf("MultiRead: got %v, %v", responses, err)
+						return
+					}
+				}
+				if g == 3 && i%5 == 0 {
+					if _, err := plc.GetTagList(); err != nil {
+						errs <- err
+						return
+					}
+					plc.FilterTagList(0)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestReadContextDeadline(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	if _, err := plc.Read("Count"); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.SetDelay(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := plc.ReadContext(ctx, "Count")
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("deadline wasn't respected, took %v", elapsed)
+	}
+
+	//# the connection was dropped, the next read opens a new one
+	sim.SetDelay(0)
+	values, err := plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Errorf("expected 42 after reconnecting, got %v, %v", values, err)
+	}
+}
+
+func TestGetTagListContextCancel(t *testing.T) {
+	sim := newSim(t)
+	sim.SetDelay(time.Second)
+	plc := newPLC(t, sim)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := plc.GetTagListContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancel wasn't respected, took %v", elapsed)
+	}
+}
+
+func TestGatherTimeout(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.TagsToRead = []string{"Count"}
+	plc.GatherTimeout = config.Duration(50*time.Millisecond)
+	sim.SetDelay(time.Second)
+
+	var acc testutil.Accumulator
+	start := time.Now()
+	if err := plc.Gather(&acc); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected the gather to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gather wasn't bounded, took %v", elapsed)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	if _, err := plc.GetTagList(); err != nil {
+		t.Fatal(err)
+	}
+	session := plc.SessionHandle
+
+	sim.DropConnections()
+	if _, err := plc.Read("Count"); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected the connection to be lost, got %v", err)
+	}
+	if plc.SocketConnected {
+		t.Error("a dead connection should be dropped")
+	}
+
+	values, err := plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Fatalf("expected 42 after reconnecting, got %v, %v", values, err)
+	}
+	if plc.Reconnects != 1 || plc.SessionHandle == session || !plc.ForwardOpened {
+		t.Errorf("expected a new session, reconnects %d, handle 0x%08X", plc.Reconnects, plc.SessionHandle)
+	}
+	if len(plc.TagList) == 0 {
+		t.Error("the project didn't change, the tag list should have been kept")
+	}
+}
+
+func TestUnconnectedFailure(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.Unconnected = true
+	if _, err := plc.Read("Count"); err != nil {
+		t.Fatal(err)
+	}
+	session := plc.SessionHandle
+
+	//# an unconnected send that timed out on the way is that request's problem only
+	sim.SetRejectUnconnected(0x0204)
+	_, err := plc.Read("Count")
+	var cipErr *CIPError
+	if !errors.As(err, &cipErr) || cipErr.Status != 0x01 || cipErr.ExtStatus[0] != 0x0204 {
+		t.Fatalf("expected an unconnected send timeout, got %v", err)
+	}
+	if errors.Is(err, ErrConnectionLost) {
+		t.Errorf("0x01/0x0204 isn't a lost connection: %v", err)
+	}
+
+	sim.SetRejectUnconnected(0)
+	values, err := plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Fatalf("expected 42, got %v, %v", values, err)
+	}
+	if plc.SessionHandle != session || plc.Reconnects != 0 || !plc.SocketConnected {
+		t.Errorf("the session should have been kept, reconnects %d, handle 0x%08X", plc.Reconnects, plc.SessionHandle)
+	}
+
+	for _, tt := range []struct {
+		reply []byte
+		connected bool
+		expected bool
+	}{
+		{[]byte{0xCC, 0x00, 0x07, 0x00}, false, true},
+		{[]byte{0xCC, 0x00, 0x07, 0x00}, true, true},
+		{[]byte{0xCC, 0x00, 0x01, 0x01, 0x07, 0x01}, true, true},
+		{[]byte{0xCC, 0x00, 0x01, 0x01, 0x03, 0x02}, true, true},
+		{[]byte{0xCC, 0x00, 0x01, 0x01, 0x07, 0x01}, false, false},
+		{[]byte{0xCC, 0x00, 0x01, 0x01, 0x04, 0x02}, true, false},
+		{[]byte{0xCC, 0x00, 0x01, 0x01, 0x11, 0x03}, true, false},
+		{[]byte{0xCC, 0x00, 0x01, 0x00}, true, false},
+		{[]byte{0xCC, 0x00, 0x04, 0x00}, true, false},
+	} {
+		if got := _connectionFailed(tt.reply, tt.connected); got != tt.expected {
+			t.Errorf("% X connected %v: expected %v", tt.reply, tt.connected, tt.expected)
+		}
+		if got := errors.Is(_cipError(tt.reply, 0, ""), ErrConnectionLost); got != tt.expected && tt.connected {
+			t.Errorf("% X: errors.Is ErrConnectionLost should be %v", tt.reply, tt.expected)
+		}
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.ReconnectMinDelay = config.Duration(time.Second)
+	if _, err := plc.Read("Count"); err != nil {
+		t.Fatal(err)
+	}
+	sim.Close()
+	plc.Read("Count")
+	if _, err := plc.Read("Count"); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected the connection to fail, got %v", err)
+	}
+
+	//# the next attempt is due after the deadline, so don't even wait
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := plc.ReadContext(ctx, "Count")
+	if !errors.Is(err, ErrConnectionLost) || !strings.Contains(err.Error(), "next attempt") {
+		t.Errorf("expected to be told about the backoff, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("should fail without waiting, took %v", elapsed)
+	}
+
+	plc.ReconnectMaxDelay = config.Duration(4*time.Second)
+	for failures, max := range []time.Duration{time.Second, time.Second, 2*time.Second, 4*time.Second, 4*time.Second} {
+		plc.failures = failures
+		if delay := plc._reconnectDelay(); delay < max/2 || delay > max {
+			t.Errorf("%d failures: delay %v out of [%v, %v]", failures, delay, max/2, max)
+		}
+	}
+}
+
+func TestLogging(t *testing.T) {
+	sim := newSim(t)
+	sim.RejectForwardOpen = 0x0113
+	plc := newPLC(t, sim)
+	plc.UnconnectedFallback = true
+	var out strings.Builder
+	plc.Logger = NewStdLogger(log.New(&out, "", 0), LogDebug)
+
+	plc.Read("Missing")
+	for _, expected := range []string{
+		"W! controller=127.0.0.1 slot=0: eip: service 0x54",
+		"using unconnected messaging",
+		"D! controller=127.0.0.1 slot=0: sent command=0x6F",
+		"received command=0x6F session=0x",
+		"D! controller=127.0.0.1 slot=0 tag=Missing service=0x52 status=0x05",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("log should contain %q:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	plc.Logger.(*StdLogger).Level = LogWarn
+	plc.Read("Count")
+	if out.Len() != 0 {
+		t.Errorf("nothing should be logged above warn level:\n%s", out.String())
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddTag(eipsim.Tag{Name: "Slow", Type: "DINT", Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+	plc.Window = 4
+
+	//# enough tags for several packets, the first one held back
+	tags := []string{"Slow"}
+	for i := 0; i < 150; i++ {
+		tags = append(tags, "Count")
+	}
+	sim.SetTagDelay("Slow", 100*time.Millisecond)
+	responses, err := plc.MultiRead(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(tags) || responses[0].Value != int32(7) {
+		t.Fatalf("unexpected responses %v", responses[:2])
+	}
+	for i, r := range responses[1:] {
+		if r.Value != int32(42) {
+			t.Fatalf("response %d: expected 42, got %+v", i+1, r)
+		}
+	}
+
+	//# a packet that times out fails its own tags, not the others
+	session := plc.SessionHandle
+	plc.Timeout = config.Duration(100*time.Millisecond)
+	sim.SetTagDelay("Slow", 300*time.Millisecond)
+	responses, err = plc.MultiRead(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := responses[0]; r.TagName != "Slow" || !errors.Is(r.Err, ErrTimeout) {
+		t.Errorf("expected Slow to time out, got %+v", r)
+	}
+	if r := responses[len(responses)-1]; r.Value != int32(42) {
+		t.Errorf("expected 42, got %+v", r)
+	}
+
+	//# the late reply turns up now and has to be passed over
+	time.Sleep(300*time.Millisecond)
+	values, err := plc.Read("Count")
+	if err != nil || values[0] != int32(42) {
+		t.Errorf("expected 42, got %v, %v", values, err)
+	}
+	if plc.SessionHandle != session || plc.Reconnects != 0 {
+		t.Error("a single late reply shouldn't cost the connection")
+	}
+}
+
+func TestTypedRead(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddTag(eipsim.Tag{Name: "Precise", Type: "LREAL", Value: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+	ctx := context.Background()
+
+	if v, err := plc.ReadDINT("Count"); err != nil || v != 42 {
+		t.Errorf("ReadDINT: got %v, %v", v, err)
+	}
+	if v, err := plc.ReadREALContext(ctx, "Temp"); err != nil || v != 21.5 {
+		t.Errorf("ReadREAL: got %v, %v", v, err)
+	}
+	if v, err := plc.ReadBOOL("Flag"); err != nil || !v {
+		t.Errorf("ReadBOOL: got %v, %v", v, err)
+	}
+	if v, err := plc.ReadStringContext(ctx, "Name"); err != nil || v != "hello" {
+		t.Errorf("ReadString: got %v, %v", v, err)
+	}
+	if v, err := plc.ReadLREAL("Precise"); err != nil || v != 0.1 {
+		t.Errorf("ReadLREAL: got %v, %v", v, err)
+	}
+	if multi, err := plc.MultiRead([]string{"Precise"}); err != nil || multi[0].Value != 0.1 {
+		t.Errorf("MultiRead LREAL: got %v, %v", multi, err)
+	}
+	if v, err := Read[int64](ctx, plc, "Small"); err != nil || v != -3 {
+		t.Errorf("Read[int64]: got %v, %v", v, err)
+	}
+	if v, err := Read[float64](ctx, plc, "Temp"); err != nil || v != 21.5 {
+		t.Errorf("Read[float64]: got %v, %v", v, err)
+	}
+	values, err := ReadArray[int](ctx, plc, "Values[2]", 3)
+	if err != nil || fmt.Sprint(values) != "[2 3 4]" {
+		t.Errorf("ReadArray[int]: got %v, %v", values, err)
+	}
+	bits, err := ReadArray[bool](ctx, plc, "Bits[1]", 3)
+	if err != nil || fmt.Sprint(bits) != "[true false true]" {
+		t.Errorf("ReadArray[bool]: got %v, %v", bits, err)
+	}
+
+	if _, err := Read[int8](ctx, plc, "Big"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("a LINT of 1<<40 shouldn't fit in an int8, got %v", err)
+	}
+	if _, err := Read[uint16](ctx, plc, "Small"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("-3 shouldn't fit in a uint16, got %v", err)
+	}
+	if _, err := plc.ReadDINTContext(ctx, "Temp"); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "REAL") {
+		t.Errorf("expected a type mismatch for a REAL, got %v", err)
+	}
+	if _, err := Read[string](ctx, plc, "Count"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch for a DINT, got %v", err)
+	}
+	if _, err := plc.ReadDINT("Missing"); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("expected ErrTagNotFound, got %v", err)
+	}
+
+	//# a BOOL is a bool however it's read
+	multi, err := plc.MultiRead([]string{"Flag"})
+	if err != nil || multi[0].Value != true {
+		t.Errorf("MultiRead: expected true, got %#v, %v", multi, err)
+	}
+}
+
+type testMotor struct {
+	Speed float32 `cip:"Speed"`
+	Running bool `cip:"Running"`
+	Faulted bool `cip:"Faulted"`
+	Counts []int32 `cip:"Counts"`
+}
+
+type unexportedMotor struct {
+	Speed float32 `cip:"Speed"`
+	running bool `cip:"Running"`
+	Faulted bool `cip:"Faulted"`
+	Counts []int32 `cip:"Counts"`
+}
+
+type testBatch struct {
+	ID int64 `cip:"BatchID"`
+	Product string
+	Motor testMotor `cip:"Motor"`
+	Weights [3]float64 `cip:"Weights"`
+	Flags [32]bool `cip:"Flags"`
+	Done bool `cip:"Done"`
+	Note string `cip:"-"`
+}
+
+func TestReadIntoWriteFrom(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddType(eipsim.Type{Name: "Batch", Members: []eipsim.Member{
+		{Name: "BatchID", Type: "DINT"},
+		{Name: "Product", Type: "STRING"},
+		{Name: "Motor", Type: "Motor"},
+		{Name: "Weights", Type: "REAL", Dims: []int{3}},
+		{Name: "Flags", Type: "BOOL", Dims: []int{32}},
+		{Name: "Done", Type: "BOOL"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "B1", Type: "Batch", Value: map[string]interface{}{
+		"BatchID": 1001,
+		"Product": "flour",
+		"Motor": map[string]interface{}{"Speed": 12.5, "Faulted": true, "Counts": []int{1, 2, 3, 4}},
+		"Weights": []float64{1.5, 2.5, 3.5},
+		"Flags": []bool{false, true, false, false, false, true},
+		"Done": true,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+
+	var batch testBatch
+	if err := plc.ReadInto("B1", &batch); err != nil {
+		t.Fatal(err)
+	}
+	expected := testBatch{
+		ID: 1001,
+		Product: "flour",
+		Motor: testMotor{Speed: 12.5, Faulted: true, Counts: []int32{1, 2, 3, 4}},
+		Weights: [3]float64{1.5, 2.5, 3.5},
+		Done: true,
+	}
+	expected.Flags[1], expected.Flags[5] = true, true
+	if fmt.Sprint(batch) != fmt.Sprint(expected) {
+		t.Errorf("expected %+v, got %+v", expected, batch)
+	}
+
+	//# nested members on their own
+	var motor testMotor
+	if err := plc.ReadInto("B1.Motor", &motor); err != nil || motor.Speed != 12.5 {
+		t.Errorf("B1.Motor: got %+v, %v", motor, err)
+	}
+
+	batch.ID = 1002
+	batch.Product = "sugar"
+	batch.Motor.Running = true
+	batch.Flags[5] = false
+	batch.Flags[31] = true
+	if err := plc.WriteFrom("B1", batch); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := sim.Get("B1")
+	written := v.(map[string]interface{})
+	flags := written["Flags"].([]interface{})
+	if written["BatchID"] != int32(1002) || written["Product"] != "sugar" || written["Done"] != true ||
+		written["Motor"].(map[string]interface{})["Running"] != true || flags[5] != false || flags[31] != true {
+		t.Errorf("unexpected tag after WriteFrom: %v", written)
+	}
+
+	//# every member needs a field and every field a member
+	var partial struct {
+		ID int32 `cip:"BatchID"`
+	}
+	if err := plc.ReadInto("B1", &partial); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "Product") {
+		t.Errorf("expected the missing members to be reported, got %v", err)
+	}
+	var extra struct {
+		testMotor
+		Torque float32
+	}
+	if err := plc.ReadInto("M1", &extra); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "Torque") {
+		t.Errorf("expected the extra field to be reported, got %v", err)
+	}
+	//# an unexported field can't be bound, tag or not
+	var hidden unexportedMotor
+	if err := plc.ReadInto("M1", &hidden); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "running") {
+		t.Errorf("expected the unexported field to be reported, got %v", err)
+	}
+	if err := plc.WriteFrom("M1", hidden); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "running") {
+		t.Errorf("expected the unexported field to be reported on write, got %v", err)
+	}
+	small := struct {
+		Speed int8
+		Running bool
+		Faulted bool
+		Counts []int32
+	}{Speed: 1}
+	batch.Product = strings.Repeat("x", 100)
+	if err := plc.WriteFrom("B1", batch); !errors.Is(err, ErrOverflow) {
+		t.Errorf("100 characters shouldn't fit in a STRING, got %v", err)
+	}
+	if err := plc.ReadInto("M1", &small); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("a REAL shouldn't go into an int8, got %v", err)
+	}
+	if err := plc.ReadInto("Count", &motor); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Count isn't a structure, got %v", err)
+	}
+}
+
+func TestReadIntoFragmented(t *testing.T) {
+	sim := newSim(t)
+	if err := sim.AddType(eipsim.Type{Name: "Profile", Members: []eipsim.Member{
+		{Name: "Points", Type: "REAL", Dims: []int{150}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "P1", Type: "Profile"}); err != nil {
+		t.Fatal(err)
+	}
+	plc := newPLC(t, sim)
+
+	//# 600 bytes don't fit in one request or reply
+	var profile struct {
+		Points []float32
+	}
+	for i := 0; i < 150; i++ {
+		profile.Points = append(profile.Points, float32(i)/2)
+	}
+	if err := plc.WriteFrom("P1", &profile); err != nil {
+		t.Fatal(err)
+	}
+	profile.Points = nil
+	if err := plc.ReadInto("P1", &profile); err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Points) != 150 || profile.Points[149] != 74.5 {
+		t.Errorf("unexpected points %v", profile.Points)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	sim := newSim(t)
+	sim.Bridge = &eipsim.Identity{VendorID: 1, DeviceType: 0x0C, ProductCode: 0xC9, SerialNumber: 0x00B41D6E, ProductName: "1756-EN2T/D"}
+	dir := t.TempDir()
+	first := newPLC(t, sim)
+	first.CacheDir = dir
+	reopen := func(out *strings.Builder) *PLC {
+		plc := &PLC{IPAddress: first.IPAddress, Port: first.Port, CacheDir: dir}
+		plc.Logger = NewStdLogger(log.New(out, "", 0), LogDebug)
+		if err := plc.Init(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { plc.Close() })
+		return plc
+	}
+
+	tagList, err := first.GetTagList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Read("M1.Counts[1]"); err != nil {
+		t.Fatal(err)
+	}
+	var m testMotor
+	if err := first.ReadInto("M1", &m); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//# named after the controller, not the bridge List Identity answers for
+	if _, err := os.Stat(filepath.Join(dir, "eip_00C0FFEE.json")); err != nil {
+		t.Errorf("expected the cache under the controller serial: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "eip_00B41D6E.json")); err == nil {
+		t.Error("the cache shouldn't be keyed by the bridge serial")
+	}
+
+	//# the next start has it all before asking for any of it
+	var out strings.Builder
+	plc := reopen(&out)
+	if _, err := plc.Read("Count"); err != nil {
+		t.Fatal(err)
+	}
+	plc.cacheMu.RLock()
+	loaded, known, templates := len(plc.TagList), plc.KnownTags["M1.Counts"], len(plc.templates)
+	plc.cacheMu.RUnlock()
+	if loaded != len(tagList) || known.dataType != 0xC4 || templates == 0 {
+		t.Errorf("expected the cache to be loaded, got %d tags, %v, %d templates:\n%s", loaded, known, templates, out.String())
+	}
+	plc._waitRevalidation(false)
+	if !strings.Contains(out.String(), "the cached tag list is current") {
+		t.Errorf("the background read should find the list current:\n%s", out.String())
+	}
+
+	//# a download makes it another project
+	if err := sim.AddTag(eipsim.Tag{Name: "Extra", Type: "DINT"}); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	plc = reopen(&out)
+	if _, err := plc.Read("Count"); err != nil {
+		t.Fatal(err)
+	}
+	plc.cacheMu.RLock()
+	loaded = len(plc.TagList)
+	plc.cacheMu.RUnlock()
+	if loaded != 0 || !strings.Contains(out.String(), "the cache is for another project") {
+		t.Errorf("the cache should have been ignored, got %d tags:\n%s", loaded, out.String())
+	}
+}
 
-	for i := 0.0; i < 10.0; i++ {
+func TestDiskCacheRevalidate(t *testing.T) {
+	//# the background read catches a list that changed under the same project
+	sim := newSim(t)
+	dir := t.TempDir()
+	first := newPLC(t, sim)
+	first.CacheDir = dir
+	tagList, err := first.GetTagList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	file := filepath.Join(dir, "eip_00C0FFEE.json")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cache diskCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	dropped := cache.TagList[len(cache.TagList)-1].TagName
+	cache.TagList = cache.TagList[:len(cache.TagList)-1]
+	if data, err = json.Marshal(cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
 
-		var acc testutil.Accumulator
+	var out strings.Builder
+	plc := &PLC{IPAddress: first.IPAddress, Port: first.Port, CacheDir: dir}
+	plc.Logger = NewStdLogger(log.New(&out, "", 0), LogInfo)
+	if err := plc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer plc.Close()
+	if v, err := plc.Read("Count"); err != nil || v[0] != int32(42) {
+		t.Fatalf("Count: got %v, %v", v, err)
+	}
+	plc._waitRevalidation(false)
+	if !strings.Contains(out.String(), fmt.Sprintf("the cached tag list was stale, %d tags now", len(tagList))) {
+		t.Errorf("the background read should replace the list:\n%s", out.String())
+	}
+	data, err = os.ReadFile(file)
+	if err != nil || !strings.Contains(string(data), strconv.Quote(dropped)) {
+		t.Errorf("the cache file should have been saved again: %v", err)
+	}
+}
 
-		values := []float{5.3, 8.9, 10.1}
+func TestDiskCacheNoProject(t *testing.T) {
+	//# Micro800 has no project identity to tell a stale cache by
+	sim := eipsim.NewMicro800()
+	if err := sim.AddTag(eipsim.Tag{Name: "Count", Type: "DINT", Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	addr, err := sim.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	host, port, _ := net.SplitHostPort(addr.String())
+	p, _ := strconv.Atoi(port)
+	var out strings.Builder
+	plc := &PLC{IPAddress: host, Port: uint16(p), CacheDir: dir}
+	plc.Logger = NewStdLogger(log.New(&out, "", 0), LogInfo)
+	if err := plc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer plc.Close()
+	if _, err := plc.GetTagList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plc._saveCache(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) > 0 || !strings.Contains(out.String(), "not caching") {
+		t.Errorf("nothing should be cached without a project identity, found %d files:\n%s", len(files), out.String())
+	}
+}
 
-		plc.Gather(&acc)
+func TestProjectChanged(t *testing.T) {
+	sim := newSim(t)
+	plc := newPLC(t, sim)
+	plc.ChangeDetectionInterval = config.Duration(time.Nanosecond)
+	plc.GatherTimeout = config.Duration(5*time.Second)
+	plc.TagsToRead = []string{"Count"}
+	var acc testutil.Accumulator
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plc.GetTagList(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plc.Read("M1.Counts[1]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	if acc.HasMeasurement("project_changed") {
+		t.Fatal("nothing changed yet")
+	}
 
-		fields := make(map[string]interface{})
-		fields["values"] = values
+	//# a download while collecting
+	if err := sim.RemoveTag("Count"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.AddTag(eipsim.Tag{Name: "Count", Type: "REAL", Value: 2.5}); err != nil {
+		t.Fatal(err)
+	}
+	acc.ClearMetrics()
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := acc.StringField("project_changed", "detected_by"); !ok || v != "poll" {
+		t.Errorf("expected a project_changed metric from the poll, got %v", acc.GetTelegrafMetrics())
+	}
+	if v, ok := acc.Get("eip"); !ok || v.Fields["value"] != float32(2.5) {
+		t.Errorf("expected the new Count, got %v", acc.GetTelegrafMetrics())
+	}
+	plc.cacheMu.RLock()
+	_, stale := plc.KnownTags["M1.Counts"]
+	reloaded := len(plc.TagList)
+	plc.cacheMu.RUnlock()
+	if stale || reloaded == 0 {
+		t.Errorf("expected flushed types and a new tag list, got stale %v, %d tags", stale, reloaded)
+	}
 
-		acc.AssertContainsFields(t, "eip", fields)
+	//# and one while the controller was away
+	sim.DropConnections()
+	if err := sim.AddTag(eipsim.Tag{Name: "Extra", Type: "DINT"}); err != nil {
+		t.Fatal(err)
+	}
+	plc.Read("Count")
+	acc.ClearMetrics()
+	if err := plc.Gather(&acc); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := acc.StringField("project_changed", "detected_by"); !ok || v != "reconnect" {
+		t.Errorf("expected a project_changed metric from the reconnect, got %v", acc.GetTelegrafMetrics())
+	}
+	if n := len(acc.GetTelegrafMetrics()); n != 2 {
+		t.Errorf("expected the value and one change, got %d metrics", n)
 	}
 }
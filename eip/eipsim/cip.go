@@ -0,0 +1,721 @@
+package eipsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+CIP requests are a service, the path size in words, the path and
+the request data. Replies are the service with 0x80 set, a reserved
+byte, the general status, the additional status size in words and
+the additional status, then the reply data (Vol 1 2-4)
+*/
+
+const (
+	statusSuccess = 0x00
+	statusConnectionFailure = 0x01
+	statusPathSegment = 0x04
+	statusPathUnknown = 0x05
+	statusPartial = 0x06
+	statusServiceNotSupported = 0x08
+	statusPrivilege = 0x0F
+	statusReplyTooLarge = 0x11
+	statusNotEnoughData = 0x13
+	statusAttributeNotSupported = 0x14
+	statusTooMuchData = 0x15
+	statusEmbeddedFailure = 0x1E
+	statusGeneral = 0xFF
+)
+
+//# replies are kept below the 500 byte connection size the client asks for
+const maxReplyData = 480
+const connectionSize = 500
+
+type segment struct {
+	kind byte //# 's'ymbol, 'e'lement, 'c'lass, 'i'nstance, 'a'ttribute, 'p'ort
+	value uint32
+	name string //# symbol name, or the link address of a port
+}
+
+type request struct {
+	service byte
+	path []segment
+	data []byte
+}
+
+func _parseRequest(raw []byte) (request, bool) {
+	var req request
+	if len(raw) < 2 {
+		return req, false
+	}
+	req.service = raw[0]
+	size := 2*int(raw[1])
+	if len(raw) < 2+size {
+		return req, false
+	}
+	path, ok := _parsePath(raw[2:2+size])
+	if !ok {
+		return req, false
+	}
+	req.path = path
+	req.data = raw[2+size:]
+	return req, true
+}
+
+func _parsePath(path []byte) ([]segment, bool) {
+	var segments []segment
+	for i := 0; i < len(path); {
+		t := path[i]
+		switch {
+		case t == 0x91:
+			if i+2 > len(path) {
+				return nil, false
+			}
+			n := int(path[i+1])
+			if i+2+n > len(path) {
+				return nil, false
+			}
+			segments = append(segments, segment{kind: 's', name: string(path[i+2:i+2+n])})
+			i += 2+n+n%2
+		case t&0xE0 == 0x20: //# logical segments
+			kind := map[byte]byte{0x00: 'c', 0x04: 'i', 0x08: 'e', 0x0C: 'p', 0x10: 'a'}[t&0x1C]
+			if kind == 0 || kind == 'p' {
+				return nil, false
+			}
+			var value uint32
+			switch t&0x03 {
+			case 0x00:
+				if i+2 > len(path) {
+					return nil, false
+				}
+				value = uint32(path[i+1])
+				i += 2
+			case 0x01:
+				if i+4 > len(path) {
+					return nil, false
+				}
+				value = uint32(binary.LittleEndian.Uint16(path[i+2:]))
+				i += 4
+			case 0x02:
+				if i+6 > len(path) {
+					return nil, false
+				}
+				value = binary.LittleEndian.Uint32(path[i+2:])
+				i += 6
+			default:
+				return nil, false
+			}
+			segments = append(segments, segment{kind: kind, value: value})
+		case t < 0x20: //# port segments, only found in routes
+			n := 2
+			if t&0x10 > 0 {
+				if i+2 > len(path) {
+					return nil, false
+				}
+				n = 2+int(path[i+1])
+			}
+			port := uint32(t&0x0F)
+			if t&0x0F == 0x0F {
+				if i+4 > len(path) {
+					return nil, false
+				}
+				n += 2
+			}
+			if i+n > len(path) {
+				return nil, false
+			}
+			var link string
+			switch {
+			case t&0x10 > 0 && t&0x0F == 0x0F:
+				port = uint32(binary.LittleEndian.Uint16(path[i+2:]))
+				link = string(path[i+4:i+n])
+			case t&0x10 > 0:
+				link = string(path[i+2:i+n])
+			case t&0x0F == 0x0F:
+				port = uint32(binary.LittleEndian.Uint16(path[i+1:]))
+				link = strconv.Itoa(int(path[i+3]))
+			default:
+				link = strconv.Itoa(int(path[i+1]))
+			}
+			i += n+n%2
+			segments = append(segments, segment{kind: 'p', value: port, name: link})
+		default:
+			return nil, false
+		}
+	}
+	return segments, true
+}
+
+func _reply(service byte, status byte, ext []uint16, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(service | 0x80)
+	buf.WriteByte(0x00)
+	buf.WriteByte(status)
+	buf.WriteByte(byte(len(ext)))
+	for _, e := range ext {
+		binary.Write(buf, binary.LittleEndian, e)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func (s *Server) _handleCIP(sess *session, raw []byte, connected bool) []byte {
+	req, ok := _parseRequest(raw)
+	if !ok {
+		service := byte(0)
+		if len(raw) > 0 {
+			service = raw[0]
+		}
+		return _reply(service, statusPathSegment, nil, nil)
+	}
+
+	class := uint32(0)
+	for _, seg := range req.path {
+		if seg.kind == 'c' {
+			class = seg.value
+			break
+		}
+	}
+
+	switch {
+	case class == 0x01:
+		s.mu.Lock()
+		id := s.Identity
+		s.mu.Unlock()
+		return _identityObject(id, req)
+	case class == 0x06:
+		return s._connectionManager(sess, req)
+	case class == 0x02 && req.service == 0x0A:
+		return s._multipleService(sess, req, connected)
+	case class == 0x67:
+		return s._executePCCC(req)
+	case class == 0x6C:
+		return s._templateService(req)
+	case class == 0x8B:
+		return s._wallClock(req)
+	case class == 0xAC:
+		return s._controllerObject(req)
+	case class == 0x6B && req.service == 0x55:
+		return s._instanceAttributeList(req)
+	case class == 0x6B || (len(req.path) > 0 && req.path[0].kind == 's'):
+		return s._tagService(req)
+	}
+	return _reply(req.service, statusPathUnknown, nil, nil)
+}
+
+func (s *Server) _connectionManager(sess *session, req request) []byte {
+	switch req.service {
+	case 0x54:
+		return s._forwardOpen(sess, req)
+	case 0x4E:
+		return s._forwardClose(sess, req)
+	case 0x52:
+		return s._unconnectedSend(sess, req)
+	}
+	return _reply(req.service, statusServiceNotSupported, nil, nil)
+}
+
+func (s *Server) _forwardOpen(sess *session, req request) []byte {
+	/*
+	priority(1) ticks(1) O->T ID(4) T->O ID(4) connection serial(2)
+	vendor(2) originator serial(4) multiplier(1) reserved(3)
+	O->T RPI(4) O->T params(2) T->O RPI(4) T->O params(2) trigger(1)
+	path size(1) path
+	*/
+	d := req.data
+	if len(d) < 36 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	if s.RejectForwardOpen != 0 {
+		return _reply(req.service, statusConnectionFailure, []uint16{s.RejectForwardOpen}, d[10:18])
+	}
+	pathSize := 2*int(d[35])
+	if len(d) < 36+pathSize {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	if _, ok := _parsePath(d[36:36+pathSize]); !ok {
+		return _reply(req.service, statusConnectionFailure, []uint16{0x0315}, d[10:18])
+	}
+
+	s.mu.Lock()
+	s.lastRoute = append([]byte(nil), d[36:36+pathSize]...)
+	s.nextConnection++
+	otID := s.nextConnection
+	s.mu.Unlock()
+	toID := binary.LittleEndian.Uint32(d[6:])
+	sess.connections[otID] = toID
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, otID)
+	binary.Write(buf, binary.LittleEndian, toID)
+	buf.Write(d[10:18]) //# connection serial, vendor, originator serial
+	buf.Write(d[22:26]) //# O->T API is the RPI we were asked for
+	buf.Write(d[28:32])
+	buf.WriteByte(0x00) //# application reply size
+	buf.WriteByte(0x00)
+	return _reply(req.service, statusSuccess, nil, buf.Bytes())
+}
+
+func (s *Server) _forwardClose(sess *session, req request) []byte {
+	/*
+	priority(1) ticks(1) connection serial(2) vendor(2)
+	originator serial(4) path size(1) reserved(1) path
+	*/
+	d := req.data
+	if len(d) < 10 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	//# one connection per session here, so the triplet is good enough
+	for id := range sess.connections {
+		delete(sess.connections, id)
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(d[2:10])
+	buf.WriteByte(0x00)
+	buf.WriteByte(0x00)
+	return _reply(req.service, statusSuccess, nil, buf.Bytes())
+}
+
+func (s *Server) _unconnectedSend(sess *session, req request) []byte {
+	/*
+	priority(1) ticks(1) message size(2) message, padded to even,
+	route size(1) reserved(1) route
+	The reply is the embedded request's own reply
+	*/
+	d := req.data
+	if len(d) < 4 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	size := int(binary.LittleEndian.Uint16(d[2:]))
+	if len(d) < 4+size {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	message := d[4:4+size]
+	rest := d[4+size+size%2:]
+	if len(rest) < 2 || len(rest) < 2+2*int(rest[0]) {
+		return _reply(req.service, statusConnectionFailure, []uint16{0x0205}, nil)
+	}
+	route, ok := _parsePath(rest[2:2+2*int(rest[0])])
+	if !ok {
+		return _reply(req.service, statusConnectionFailure, []uint16{0x0315}, nil)
+	}
+	s.mu.Lock()
+	s.lastRoute = append([]byte(nil), rest[2:2+2*int(rest[0])]...)
+	reject := s.RejectUnconnected
+	s.mu.Unlock()
+	if reject != 0 {
+		return _reply(req.service, statusConnectionFailure, []uint16{reject}, nil)
+	}
+	//# the last hop says which module of the chassis the message is for
+	if len(route) > 0 && route[len(route)-1].value == 1 {
+		slot, _ := strconv.Atoi(route[len(route)-1].name)
+		s.mu.Lock()
+		id, isModule := s.Modules[slot]
+		controller := slot == s.Slot
+		s.mu.Unlock()
+		switch {
+		case isModule && !controller:
+			embedded, ok := _parseRequest(message)
+			if !ok {
+				return _reply(req.service, statusPathSegment, nil, nil)
+			}
+			return _moduleObject(id, embedded)
+		case !controller:
+			//# nothing in that slot
+			return _reply(req.service, statusConnectionFailure, []uint16{0x0311}, nil)
+		}
+	}
+	return s._handleCIP(sess, message, false)
+}
+
+func _moduleObject(id Identity, req request) []byte {
+	/*
+	Modules other than the controller only answer for their identity
+	*/
+	for _, seg := range req.path {
+		if seg.kind == 'c' {
+			if seg.value == 0x01 {
+				return _identityObject(id, req)
+			}
+			break
+		}
+	}
+	return _reply(req.service, statusPathUnknown, nil, nil)
+}
+
+func _identityObject(id Identity, req request) []byte {
+	/*
+	Get Attributes All of the Identity Object (class 0x01, Vol 1
+	5-2): vendor(2) device type(2) product code(2) revision(2)
+	status(2) serial(4) product name(short string)
+	*/
+	if req.service != 0x01 {
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+	return _reply(req.service, statusSuccess, nil, _identityAttributes(id))
+}
+
+func _identityAttributes(id Identity) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, id.VendorID)
+	binary.Write(buf, binary.LittleEndian, id.DeviceType)
+	binary.Write(buf, binary.LittleEndian, id.ProductCode)
+	buf.WriteByte(id.Major)
+	buf.WriteByte(id.Minor)
+	binary.Write(buf, binary.LittleEndian, uint16(0x0060)) //# status: run
+	binary.Write(buf, binary.LittleEndian, id.SerialNumber)
+	buf.WriteByte(byte(len(id.ProductName)))
+	buf.WriteString(id.ProductName)
+	return buf.Bytes()
+}
+
+func (s *Server) _multipleService(sess *session, req request, connected bool) []byte {
+	/*
+	service count(2), an offset(2) per service from the start of
+	the count, then the services. The reply has the same layout
+	*/
+	d := req.data
+	if len(d) < 2 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	count := int(binary.LittleEndian.Uint16(d))
+	if len(d) < 2+2*count {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	var replies [][]byte
+	status := byte(statusSuccess)
+	for i := 0; i < count; i++ {
+		start := int(binary.LittleEndian.Uint16(d[2+2*i:]))
+		end := len(d)
+		if i+1 < count {
+			end = int(binary.LittleEndian.Uint16(d[2+2*(i+1):]))
+		}
+		if start > end || end > len(d) {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		reply := s._handleCIP(sess, d[start:end], connected)
+		if reply[2] != statusSuccess {
+			status = statusEmbeddedFailure
+		}
+		replies = append(replies, reply)
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint16(count))
+	offset := 2+2*count
+	for _, r := range replies {
+		binary.Write(buf, binary.LittleEndian, uint16(offset))
+		offset += len(r)
+	}
+	for _, r := range replies {
+		buf.Write(r)
+	}
+	//# all the replies together have to fit in the connection
+	if 4+buf.Len() > connectionSize {
+		return _reply(req.service, statusReplyTooLarge, nil, nil)
+	}
+	return _reply(req.service, status, nil, buf.Bytes())
+}
+
+func (s *Server) _wallClock(req request) []byte {
+	/*
+	Attribute 0x0B is the local time in microseconds since 1970,
+	setting attribute 6 (UTC, the same unit) moves the clock
+	*/
+	if s.Micro800 {
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+	now := uint64(s._now().UnixNano()/1000)
+	switch req.service {
+	case 0x03: //# Get Attribute List
+		ids, ok := _attributeIDs(req.data)
+		if !ok {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, uint16(len(ids)))
+		for _, id := range ids {
+			binary.Write(buf, binary.LittleEndian, id)
+			if id != 0x0B {
+				binary.Write(buf, binary.LittleEndian, uint16(statusAttributeNotSupported))
+				continue
+			}
+			binary.Write(buf, binary.LittleEndian, uint16(0))
+			binary.Write(buf, binary.LittleEndian, now)
+		}
+		return _reply(req.service, statusSuccess, nil, buf.Bytes())
+	case 0x0E: //# Get Attribute Single
+		for _, seg := range req.path {
+			if seg.kind == 'a' && seg.value == 0x0B {
+				return _reply(req.service, statusSuccess, nil, binary.LittleEndian.AppendUint64(nil, now))
+			}
+		}
+		return _reply(req.service, statusAttributeNotSupported, nil, nil)
+	case 0x04: //# Set Attribute List: count(2), then id(2) and value
+		d := req.data
+		if len(d) < 2 {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		count := int(binary.LittleEndian.Uint16(d))
+		d = d[2:]
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, uint16(count))
+		for i := 0; i < count; i++ {
+			if len(d) < 2 {
+				return _reply(req.service, statusNotEnoughData, nil, nil)
+			}
+			id := binary.LittleEndian.Uint16(d)
+			d = d[2:]
+			binary.Write(buf, binary.LittleEndian, id)
+			if id != 0x06 {
+				//# the size of anything else is unknown, so nothing after it can be read either
+				binary.Write(buf, binary.LittleEndian, uint16(statusAttributeNotSupported))
+				return _reply(req.service, statusAttributeNotSupported, nil, buf.Bytes())
+			}
+			if len(d) < 8 {
+				return _reply(req.service, statusNotEnoughData, nil, nil)
+			}
+			set := time.UnixMicro(int64(binary.LittleEndian.Uint64(d)))
+			d = d[8:]
+			s.mu.Lock()
+			s.clockOffset = set.Sub(s._clock())
+			s.mu.Unlock()
+			binary.Write(buf, binary.LittleEndian, uint16(0))
+		}
+		return _reply(req.service, statusSuccess, nil, buf.Bytes())
+	}
+	return _reply(req.service, statusServiceNotSupported, nil, nil)
+}
+
+func (s *Server) _controllerObject(req request) []byte {
+	/*
+	Change detection: attributes 1 and 2 (UINT) and 3, 4 and 10
+	(UDINT) of the Logix controller object all move when the project
+	is edited or downloaded. Here they're the edit count
+	*/
+	if s.Micro800 {
+		return _reply(req.service, statusPathUnknown, nil, nil)
+	}
+	if req.service != 0x03 {
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+	ids, ok := _attributeIDs(req.data)
+	if !ok {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	s.mu.Lock()
+	changes := s.changes
+	s.mu.Unlock()
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint16(len(ids)))
+	for _, id := range ids {
+		binary.Write(buf, binary.LittleEndian, id)
+		switch id {
+		case 0x01, 0x02:
+			binary.Write(buf, binary.LittleEndian, uint16(0))
+			binary.Write(buf, binary.LittleEndian, uint16(changes))
+		case 0x03, 0x04, 0x0A:
+			binary.Write(buf, binary.LittleEndian, uint16(0))
+			binary.Write(buf, binary.LittleEndian, changes)
+		default:
+			binary.Write(buf, binary.LittleEndian, uint16(statusAttributeNotSupported))
+		}
+	}
+	return _reply(req.service, statusSuccess, nil, buf.Bytes())
+}
+
+func _attributeIDs(data []byte) ([]uint16, bool) {
+	if len(data) < 2 {
+		return nil, false
+	}
+	count := int(binary.LittleEndian.Uint16(data))
+	if len(data) < 2+2*count {
+		return nil, false
+	}
+	ids := make([]uint16, count)
+	for i := range ids {
+		ids[i] = binary.LittleEndian.Uint16(data[2+2*i:])
+	}
+	return ids, true
+}
+
+func _instance(path []segment) (uint32, bool) {
+	for _, seg := range path {
+		if seg.kind == 'i' {
+			return seg.value, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) _instanceAttributeList(req request) []byte {
+	/*
+	Tag browsing: every tag of the scope from the requested instance
+	on, with the attributes asked for, in the order asked for
+	The status is 0x06 when they didn't all fit
+	*/
+	ids, ok := _attributeIDs(req.data)
+	if !ok {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	start, _ := _instance(req.path)
+	program := ""
+	if len(req.path) > 0 && req.path[0].kind == 's' {
+		program = req.path[0].name
+		if !strings.HasPrefix(program, "Program:") || s.Micro800 {
+			return _reply(req.service, statusPathUnknown, nil, nil)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(program) > 0 {
+		if t, ok := s.tagIndex[strings.ToUpper(program)]; !ok || !t.isProgram {
+			return _reply(req.service, statusPathUnknown, nil, nil)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	status := byte(statusSuccess)
+	for _, t := range s.tags {
+		if t.instance < start {
+			continue
+		}
+		if (len(program) == 0 && len(t.program) > 0) || (len(program) > 0 && !strings.EqualFold(t.program, program)) {
+			continue
+		}
+		entry := new(bytes.Buffer)
+		binary.Write(entry, binary.LittleEndian, t.instance)
+		for _, id := range ids {
+			t._attribute(entry, id)
+		}
+		if buf.Len()+entry.Len() > maxReplyData {
+			status = statusPartial
+			break
+		}
+		buf.Write(entry.Bytes())
+	}
+	return _reply(req.service, status, nil, buf.Bytes())
+}
+
+func (t *tag) _attribute(buf *bytes.Buffer, id uint16) {
+	/*
+	Symbol object attributes
+		1 name, 2 symbol type, 7 element size in bytes,
+		8 array dimensions, 10 external access
+	*/
+	switch id {
+	case 0x01:
+		binary.Write(buf, binary.LittleEndian, uint16(len(t.local)))
+		buf.WriteString(t.local)
+	case 0x02:
+		binary.Write(buf, binary.LittleEndian, t._symbolType())
+	case 0x07:
+		size := uint16(0)
+		if t.typ != nil {
+			size = uint16(t.typ.size)
+		}
+		binary.Write(buf, binary.LittleEndian, size)
+	case 0x08:
+		for i := 0; i < 3; i++ {
+			d := uint32(0)
+			if i < len(t.dims) {
+				d = uint32(t.dims[i])
+			}
+			binary.Write(buf, binary.LittleEndian, d)
+		}
+	case 0x0A:
+		access := byte(0x00) //# read/write
+		if t.readOnly {
+			access = 0x02
+		}
+		if t.noAccess {
+			access = 0x03
+		}
+		buf.WriteByte(access)
+	}
+}
+
+func (t *tag) _symbolType() uint16 {
+	/*
+	Bits 0-11 type or template instance, 12 system, 13-14 dimensions,
+	15 structure
+	*/
+	if t.isProgram {
+		return 0x1068
+	}
+	typ := t.typ._symbolType()
+	return typ | uint16(len(t.dims))<<13
+}
+
+func (s *Server) _templateService(req request) []byte {
+	id, ok := _instance(req.path)
+	if !ok {
+		return _reply(req.service, statusPathUnknown, nil, nil)
+	}
+	s.mu.Lock()
+	var tmpl *template
+	for _, t := range s.templates {
+		if uint32(t.instance) == id {
+			tmpl = t
+		}
+	}
+	s.mu.Unlock()
+	if tmpl == nil {
+		return _reply(req.service, statusPathUnknown, nil, nil)
+	}
+
+	switch req.service {
+	case 0x03: //# Get Attribute List
+		ids, ok := _attributeIDs(req.data)
+		if !ok {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, uint16(len(ids)))
+		for _, a := range ids {
+			binary.Write(buf, binary.LittleEndian, a)
+			switch a {
+			case 0x01: //# structure handle
+				binary.Write(buf, binary.LittleEndian, uint16(0))
+				binary.Write(buf, binary.LittleEndian, tmpl.handle)
+			case 0x02: //# member count
+				binary.Write(buf, binary.LittleEndian, uint16(0))
+				binary.Write(buf, binary.LittleEndian, uint16(len(tmpl.members)))
+			case 0x04: //# definition size in 32 bit words
+				binary.Write(buf, binary.LittleEndian, uint16(0))
+				binary.Write(buf, binary.LittleEndian, uint32((len(tmpl.definition)+23+3)/4))
+			case 0x05: //# structure size in bytes
+				binary.Write(buf, binary.LittleEndian, uint16(0))
+				binary.Write(buf, binary.LittleEndian, uint32(tmpl.size))
+			default:
+				binary.Write(buf, binary.LittleEndian, uint16(statusAttributeNotSupported))
+			}
+		}
+		return _reply(req.service, statusSuccess, nil, buf.Bytes())
+	case 0x4C: //# Read Template: offset(4) bytes(2)
+		if len(req.data) < 6 {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		offset := int(binary.LittleEndian.Uint32(req.data))
+		count := int(binary.LittleEndian.Uint16(req.data[4:]))
+		if offset > len(tmpl.definition) {
+			return _reply(req.service, statusGeneral, []uint16{0x2104}, nil)
+		}
+		end := offset+count
+		if end > len(tmpl.definition) {
+			end = len(tmpl.definition)
+		}
+		status := byte(statusSuccess)
+		if end-offset > maxReplyData {
+			end = offset+maxReplyData
+			status = statusPartial
+		}
+		return _reply(req.service, status, nil, tmpl.definition[offset:end])
+	}
+	return _reply(req.service, statusServiceNotSupported, nil, nil)
+}
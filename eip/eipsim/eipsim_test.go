@@ -0,0 +1,137 @@
+package eipsim
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const testYAML = `
+identity:
+  product_name: 1756-L85E/B
+  serial_number: 0x1234
+types:
+  - name: Motor
+    members:
+      - {name: Speed, type: REAL}
+      - {name: Running, type: BOOL}
+      - {name: Faulted, type: BOOL}
+      - {name: Counts, type: DINT, dims: [2]}
+tags:
+  - {name: Temp, type: REAL, value: 21.5}
+  - {name: Values, type: DINT, dims: [3], value: [1, 2, 3]}
+  - {name: M1, type: Motor, value: {Speed: 10, Faulted: true}}
+  - {name: Program:Main.Step, type: DINT, value: 4}
+`
+
+const testJSON = `{
+  "tags": [
+    {"name": "Temp", "type": "REAL", "value": 21.5},
+    {"name": "Values", "type": "DINT", "dims": [3], "value": [1, 2, 3]}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		data string
+	}{
+		{"yaml", testYAML},
+		{"json", testJSON},
+	} {
+		s, err := Parse([]byte(tt.data), tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if v, err := s.Get("Temp"); err != nil || v != float32(21.5) {
+			t.Errorf("%s: Temp: got %v, %v", tt.format, v, err)
+		}
+		expected := []interface{}{int32(1), int32(2), int32(3)}
+		if v, err := s.Get("Values"); err != nil || !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: Values: got %v, %v", tt.format, v, err)
+		}
+	}
+
+	s, err := Parse([]byte(testYAML), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Identity.SerialNumber != 0x1234 || s.Identity.ProductName != "1756-L85E/B" || s.Identity.VendorID != 1 {
+		t.Errorf("unexpected identity %+v", s.Identity)
+	}
+	m1, err := s.Get("M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"Speed": float32(10),
+		"Running": false,
+		"Faulted": true,
+		"Counts": []interface{}{int32(0), int32(0)},
+	}
+	if !reflect.DeepEqual(m1, expected) {
+		t.Errorf("M1: expected %v, got %v", expected, m1)
+	}
+	if v, err := s.Get("Program:Main.Step"); err != nil || v != int32(4) {
+		t.Errorf("Program:Main.Step: got %v, %v", v, err)
+	}
+}
+
+func TestTemplateLayout(t *testing.T) {
+	s, err := Parse([]byte(testYAML), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	motor := s.types["MOTOR"].template
+
+	//# Speed at 0, the BOOLs share the SINT at 4, Counts aligned to 8
+	offsets := map[string]int{"Speed": 0, "Running": 4, "Faulted": 4, "Counts": 8}
+	for name, offset := range offsets {
+		if m := motor._member(name); m == nil || m.offset != offset {
+			t.Errorf("%s: expected offset %d, got %+v", name, offset, m)
+		}
+	}
+	if motor._member("Faulted").bit != 1 {
+		t.Errorf("Faulted should be bit 1")
+	}
+	if motor.size != 16 {
+		t.Errorf("expected size 16, got %d", motor.size)
+	}
+
+	//# Read Template hands out the definition, 8 bytes per member first
+	reply := s._templateService(request{
+		service: 0x4C,
+		path: []segment{{kind: 'c', value: 0x6C}, {kind: 'i', value: uint32(motor.instance)}},
+		data: []byte{0, 0, 0, 0, 0xFF, 0x00},
+	})
+	if reply[2] != statusSuccess {
+		t.Fatalf("Read Template failed with 0x%02X", reply[2])
+	}
+	definition := reply[4:]
+	if binary.LittleEndian.Uint16(definition[2:]) != 0xCA {
+		t.Errorf("first member should be a REAL")
+	}
+	countsInfo := definition[8*4:]
+	if binary.LittleEndian.Uint16(countsInfo) != 2 || binary.LittleEndian.Uint16(countsInfo[2:]) != 0x20C4 {
+		t.Errorf("Counts should be a DINT[2], got % X", countsInfo[:8])
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := New()
+	if err := s.AddTag(Tag{Name: "Count", Type: "DINT"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("Count", 12); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("Count"); v != int32(12) {
+		t.Errorf("expected 12, got %v", v)
+	}
+	if err := s.Set("Count", "twelve"); err == nil {
+		t.Error("a string shouldn't fit in a DINT")
+	}
+	if err := s.AddTag(Tag{Name: "Count", Type: "DINT"}); err == nil {
+		t.Error("duplicate tags should be rejected")
+	}
+}
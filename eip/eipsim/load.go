@@ -0,0 +1,118 @@
+package eipsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Controllers can be described in a file instead of Go:
+
+	identity:
+	  product_name: 1756-L83E/B
+	  serial_number: 0x00C0FFEE
+	modules:
+	  1: {product_name: 1756-EN2T/D, device_type: 12}
+	  2: {product_name: 1756-IB16/B, device_type: 7}
+	types:
+	  - name: Motor
+	    members:
+	      - {name: Speed, type: REAL}
+	      - {name: Running, type: BOOL}
+	tags:
+	  - {name: Temp, type: REAL, value: 21.5}
+	  - {name: Counts, type: DINT, dims: [10], value: [1, 2, 3]}
+	  - {name: M1, type: Motor, value: {Speed: 1450.0, Running: true}}
+	  - {name: Program:MainProgram.Step, type: DINT, value: 3}
+
+The same layout works as JSON
+*/
+
+type Config struct {
+	Micro800 bool `yaml:"micro800" json:"micro800"`
+	Identity *Identity `yaml:"identity" json:"identity"`
+	Slot int `yaml:"slot" json:"slot"`
+	Modules map[int]Identity `yaml:"modules" json:"modules"`
+	Types []Type `yaml:"types" json:"types"`
+	Tags []Tag `yaml:"tags" json:"tags"`
+}
+
+func Load(path string) (*Server, error) {
+	/*
+	Builds a controller from a YAML or JSON file, picked by extension
+	*/
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := "yaml"
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = "json"
+	}
+	return Parse(data, format)
+}
+
+func Parse(data []byte, format string) (*Server, error) {
+	var cfg Config
+	switch format {
+	case "json":
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("eipsim: %v", err)
+		}
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("eipsim: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("eipsim: unknown format %q", format)
+	}
+	return NewFromConfig(cfg)
+}
+
+func NewFromConfig(cfg Config) (*Server, error) {
+	s := New()
+	if cfg.Micro800 {
+		s = NewMicro800()
+	}
+	if cfg.Identity != nil {
+		id := *cfg.Identity
+		//# keep the defaults for whatever the file left out
+		if id.VendorID == 0 {
+			id.VendorID = s.Identity.VendorID
+		}
+		if id.DeviceType == 0 {
+			id.DeviceType = s.Identity.DeviceType
+		}
+		if id.ProductCode == 0 {
+			id.ProductCode = s.Identity.ProductCode
+		}
+		if id.Major == 0 {
+			id.Major, id.Minor = s.Identity.Major, s.Identity.Minor
+		}
+		if id.SerialNumber == 0 {
+			id.SerialNumber = s.Identity.SerialNumber
+		}
+		if len(id.ProductName) == 0 {
+			id.ProductName = s.Identity.ProductName
+		}
+		s.Identity = id
+	}
+	s.Slot = cfg.Slot
+	s.Modules = cfg.Modules
+	for _, t := range cfg.Types {
+		if err := s.AddType(t); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range cfg.Tags {
+		if err := s.AddTag(t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
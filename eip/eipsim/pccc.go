@@ -0,0 +1,193 @@
+package eipsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+SLC 500 and PLC-5 data table files behind Execute PCCC (service
+0x4B of class 0x67). The request data is the requestor ID, then
+CMD, STS, TNS(2), FNC and the function's parameters; the reply
+echoes the requestor ID, CMD|0x40, STS and TNS before the data.
+Only the protected typed logical commands with three address fields
+are answered: read 0xA2, write 0xAA and masked write 0xAB
+	size(1) file(1|3) type(1) element(1|3) sub-element(1|3)
+Files hold raw little endian bytes, ST elements with the characters
+of every word swapped as the controller stores them
+*/
+
+const (
+	pcccExtStatus = 0xF0
+	pcccIllegalValue = 0x01
+	pcccAddressUnusable = 0x06
+	pcccTooLarge = 0x09
+	pcccTypeMismatch = 0x17
+)
+
+type fileType struct {
+	code byte
+	size int //# bytes per element
+}
+
+var fileTypes = map[string]fileType{
+	"O": {code: 0x8B, size: 2},
+	"I": {code: 0x8C, size: 2},
+	"S": {code: 0x84, size: 2},
+	"B": {code: 0x85, size: 2},
+	"T": {code: 0x86, size: 6},
+	"C": {code: 0x87, size: 6},
+	"R": {code: 0x88, size: 6},
+	"N": {code: 0x89, size: 2},
+	"F": {code: 0x8A, size: 4},
+	"ST": {code: 0x8D, size: 84},
+	"A": {code: 0x8E, size: 2},
+	"L": {code: 0x91, size: 4},
+}
+
+type dataFile struct {
+	typ fileType
+	data []byte
+}
+
+func (s *Server) AddFile(number uint16, typ string, data []byte) error {
+	/*
+	Adds data table file number of type typ ("N", "F", "B", "T",
+	"ST"...) holding data, which is rounded up to whole elements
+	*/
+	ft, ok := fileTypes[strings.ToUpper(typ)]
+	if !ok {
+		return fmt.Errorf("eipsim: unknown file type %q", typ)
+	}
+	size := (len(data)+ft.size-1)/ft.size*ft.size
+	if size == 0 {
+		size = ft.size
+	}
+	f := &dataFile{typ: ft, data: make([]byte, size)}
+	copy(f.data, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[uint16]*dataFile)
+	}
+	s.files[number] = f
+	return nil
+}
+
+func (s *Server) File(number uint16) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[number]
+	if !ok {
+		return nil, fmt.Errorf("eipsim: file %d not found", number)
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (s *Server) _executePCCC(req request) []byte {
+	if req.service != 0x4B {
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+	if len(req.data) < 1 || len(req.data) < int(req.data[0])+5 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	requestor := req.data[:req.data[0]]
+	cmd := req.data[len(requestor):]
+
+	data, sts, ext := s._pcccCommand(cmd[4], cmd[5:])
+
+	buf := new(bytes.Buffer)
+	buf.Write(requestor)
+	buf.WriteByte(cmd[0] | 0x40)
+	buf.WriteByte(sts)
+	buf.Write(cmd[2:4])
+	if sts == pcccExtStatus {
+		buf.WriteByte(ext)
+	}
+	buf.Write(data)
+	return _reply(req.service, statusSuccess, nil, buf.Bytes())
+}
+
+func (s *Server) _pcccCommand(function byte, params []byte) ([]byte, byte, byte) {
+	/*
+	Returns the reply data, STS and EXT STS of one command
+	*/
+	if function != 0xA2 && function != 0xAA && function != 0xAB {
+		return nil, 0x10, 0 //# illegal command or format
+	}
+	size, number, code, element, sub, rest, ok := _pcccAddress(params)
+	if !ok {
+		return nil, pcccExtStatus, pcccIllegalValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, found := s.files[number]
+	if !found {
+		return nil, pcccExtStatus, pcccAddressUnusable
+	}
+	if f.typ.code != code {
+		return nil, pcccExtStatus, pcccTypeMismatch
+	}
+	offset := int(element)*f.typ.size + int(sub)*2
+	if offset+size > len(f.data) {
+		return nil, pcccExtStatus, pcccAddressUnusable
+	}
+	target := f.data[offset:offset+size]
+
+	switch function {
+	case 0xA2:
+		if size > maxReplyData {
+			return nil, pcccExtStatus, pcccTooLarge
+		}
+		return append([]byte(nil), target...), 0, 0
+	case 0xAA:
+		if len(rest) < size {
+			return nil, pcccExtStatus, pcccIllegalValue
+		}
+		copy(target, rest[:size])
+	case 0xAB:
+		//# AND mask then OR value, one word at a time
+		if size != 2 || len(rest) < 4 {
+			return nil, pcccExtStatus, pcccIllegalValue
+		}
+		mask := binary.LittleEndian.Uint16(rest)
+		value := binary.LittleEndian.Uint16(rest[2:])
+		word := binary.LittleEndian.Uint16(target)
+		binary.LittleEndian.PutUint16(target, word&^mask | value&mask)
+	}
+	return nil, 0, 0
+}
+
+func _pcccAddress(params []byte) (int, uint16, byte, uint16, uint16, []byte, bool) {
+	//# byte size, file number, file type, element and sub-element
+	if len(params) < 1 {
+		return 0, 0, 0, 0, 0, nil, false
+	}
+	size := int(params[0])
+	rest := params[1:]
+	var fields [4]uint16
+	for i := range fields {
+		if len(rest) < 1 {
+			return 0, 0, 0, 0, 0, nil, false
+		}
+		switch {
+		case i == 1:
+			fields[i] = uint16(rest[0])
+			rest = rest[1:]
+		case rest[0] == 0xFF:
+			if len(rest) < 3 {
+				return 0, 0, 0, 0, 0, nil, false
+			}
+			fields[i] = binary.LittleEndian.Uint16(rest[1:])
+			rest = rest[3:]
+		default:
+			fields[i] = uint16(rest[0])
+			rest = rest[1:]
+		}
+	}
+	return size, fields[0], byte(fields[1]), fields[2], fields[3], rest, true
+}
@@ -0,0 +1,558 @@
+package eipsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Package eipsim is a small in-process Logix controller for tests.
+It answers the encapsulation commands the client uses (Register and
+Unregister Session, List Identity, SendRRData and SendUnitData) and
+the CIP services behind them: Forward Open/Close, Unconnected Send,
+Read/Write Tag (fragmented too), Read Modify Write Tag, Multiple
+Service Packet, tag browsing with Get_Instance_Attribute_List,
+Template reads, the WallClock and the Identity Object of the
+controller and of the other Modules in its chassis, and the data
+table files of Execute PCCC. ListenUDP answers List Identity
+broadcasts.
+
+	sim := eipsim.New()
+	sim.AddTag(eipsim.Tag{Name: "Temp", Type: "REAL", Value: 21.5})
+	addr, err := sim.Listen("127.0.0.1:0")
+	defer sim.Close()
+
+It has no notion of the client package so either side can change
+without the other
+*/
+
+type Identity struct {
+	VendorID uint16 `yaml:"vendor_id" json:"vendor_id"`
+	DeviceType uint16 `yaml:"device_type" json:"device_type"`
+	ProductCode uint16 `yaml:"product_code" json:"product_code"`
+	Major byte `yaml:"major" json:"major"`
+	Minor byte `yaml:"minor" json:"minor"`
+	SerialNumber uint32 `yaml:"serial_number" json:"serial_number"`
+	ProductName string `yaml:"product_name" json:"product_name"`
+}
+
+type Server struct {
+	Identity Identity
+	Micro800 bool //# short strings, no fragmented reads, no program scope
+	Clock func() time.Time //# WallClock source, time.Now when nil
+	RejectForwardOpen uint16 //# extended status to reject every Forward Open with, 0 accepts
+	RejectUnconnected uint16 //# extended status to fail every Unconnected Send with, 0 passes them on
+	Delay time.Duration //# how long to sit on every CIP request before answering
+	Slot int //# backplane slot of the controller
+	Modules map[int]Identity //# the other modules in the chassis, by slot
+	Bridge *Identity //# who List Identity says we are when it's a communication module in front of the controller
+
+	mu sync.Mutex
+	tagDelays map[string]time.Duration
+	types map[string]*dataType
+	templates []*template
+	tags []*tag
+	tagIndex map[string]*tag
+	files map[uint16]*dataFile //# PCCC data table files by number
+	nextInstance uint32
+	changes uint32 //# bumped by every edit to the tags or types, like a download
+	clockOffset time.Duration //# set through the WallClock, added to Clock
+	lastRoute []byte
+	nextConnection uint32
+	nextSession uint32
+
+	listener net.Listener
+	udp net.PacketConn
+	conns map[net.Conn]bool
+	wg sync.WaitGroup
+	closed bool
+	done chan struct{}
+}
+
+func New() *Server {
+	s := &Server{
+		Identity: Identity{
+			VendorID: 0x01,
+			DeviceType: 0x0E,
+			ProductCode: 0xAA,
+			Major: 33,
+			Minor: 11,
+			SerialNumber: 0x00C0FFEE,
+			ProductName: "1756-L83E/B",
+		},
+		types: make(map[string]*dataType),
+		tagIndex: make(map[string]*tag),
+		nextInstance: 0x100,
+		nextConnection: 0x41000000,
+		conns: make(map[net.Conn]bool),
+		done: make(chan struct{}),
+	}
+	for name, t := range atomicTypes {
+		s.types[name] = t
+	}
+	s._addStringType()
+	return s
+}
+
+func NewMicro800() *Server {
+	s := New()
+	s.Micro800 = true
+	s.Identity.ProductCode = 0xBD
+	s.Identity.Major = 12
+	s.Identity.Minor = 11
+	s.Identity.ProductName = "2080-LC50-24QWB"
+	return s
+}
+
+func (s *Server) Listen(address string) (net.Addr, error) {
+	/*
+	Starts serving on address in the background, "127.0.0.1:0"
+	picks a free port, the address it got is returned
+	*/
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.Serve(l)
+	}()
+	return l.Addr(), nil
+}
+
+func (s *Server) ListenUDP(address string) (net.Addr, error) {
+	/*
+	Answers List Identity over UDP too, which is how controllers are
+	found with a broadcast
+	*/
+	conn, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.udp = conn
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 24 || binary.LittleEndian.Uint16(buf) != 0x63 {
+				continue
+			}
+			conn.WriteTo(_frame(0x63, 0, 0, buf[12:20], s._listIdentity()), from)
+		}
+	}()
+	return conn.LocalAddr(), nil
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+func (s *Server) ServeConn(conn net.Conn) {
+	/*
+	Serves one client connection until it unregisters or goes away,
+	handy with net.Pipe when a test doesn't want a listener
+	*/
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	sess := &session{server: s, connections: make(map[uint32]uint32)}
+	var writeMu sync.Mutex
+	write := func(reply []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Write(reply)
+		return err
+	}
+	header := make([]byte, 24)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.LittleEndian.Uint16(header[2:])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		reply, keep := sess._handle(header, data)
+		if reply != nil {
+			//# held back replies let the ones behind them overtake
+			if delay := s._tagDelay(header, data); delay > 0 {
+				time.AfterFunc(delay, func() { write(reply) })
+			} else if err := write(reply); err != nil {
+				return
+			}
+		}
+		if !keep {
+			return
+		}
+	}
+}
+
+func (s *Server) Close() error {
+	/*
+	Stops the listener, drops every client and waits for them to finish
+	*/
+	s.mu.Lock()
+	if !s.closed {
+		close(s.done)
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) DropConnections() {
+	/*
+	Closes every client connection but keeps listening, which is what
+	clients see when the controller reboots
+	*/
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) SetTagDelay(name string, delay time.Duration) {
+	/*
+	Holds back the reply to every connected request that names the tag
+	without holding up the requests after it, so replies come back out
+	of order, or too late when the delay is longer than the client waits
+	*/
+	s.mu.Lock()
+	if s.tagDelays == nil {
+		s.tagDelays = make(map[string]time.Duration)
+	}
+	s.tagDelays[name] = delay
+	s.mu.Unlock()
+}
+
+func (s *Server) _tagDelay(header, data []byte) time.Duration {
+	//# only SendUnitData, unconnected replies have nothing to be matched by
+	if binary.LittleEndian.Uint16(header) != 0x70 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var delay time.Duration
+	for name, d := range s.tagDelays {
+		//# the symbolic segment of the tag anywhere in the request
+		symbol := append([]byte{0x91, byte(len(name))}, name...)
+		if d > delay && bytes.Contains(data, symbol) {
+			delay = d
+		}
+	}
+	return delay
+}
+
+func (s *Server) _wait() bool {
+	/*
+	Sits out Delay, false when the server was closed meanwhile
+	*/
+	s.mu.Lock()
+	delay := s.Delay
+	s.mu.Unlock()
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	s.Delay = delay
+	s.mu.Unlock()
+}
+
+func (s *Server) SetRejectUnconnected(ext uint16) {
+	s.mu.Lock()
+	s.RejectUnconnected = ext
+	s.mu.Unlock()
+}
+
+func (s *Server) LastRoute() []byte {
+	/*
+	The connection path of the last Forward Open, or the route of the
+	last Unconnected Send, as it came in
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.lastRoute...)
+}
+
+func (s *Server) _now() time.Time {
+	s.mu.Lock()
+	offset := s.clockOffset
+	s.mu.Unlock()
+	return s._clock().Add(offset)
+}
+
+func (s *Server) _clock() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+type session struct {
+	server *Server
+	handle uint32
+	connections map[uint32]uint32 //# our O->T connection ID to the client's T->O ID
+}
+
+func (sess *session) _handle(header, data []byte) ([]byte, bool) {
+	/*
+	Handles one encapsulation packet, returns the reply and whether
+	to keep the TCP connection open
+	*/
+	command := binary.LittleEndian.Uint16(header[0:])
+	handle := binary.LittleEndian.Uint32(header[4:])
+	context := header[12:20]
+
+	switch command {
+	case 0x63: //# List Identity
+		return _frame(command, 0, 0, context, sess.server._listIdentity()), true
+	case 0x65: //# Register Session
+		if sess.handle != 0 || len(data) < 4 {
+			return _frame(command, 0, 0x0003, context, nil), true
+		}
+		sess.server.mu.Lock()
+		sess.server.nextSession++
+		sess.handle = 0x10000000 | sess.server.nextSession
+		sess.server.mu.Unlock()
+		return _frame(command, sess.handle, 0, context, data[:4]), true
+	case 0x66: //# Unregister Session, no reply
+		return nil, false
+	case 0x6F, 0x70: //# SendRRData, SendUnitData
+		if sess.handle == 0 || handle != sess.handle {
+			return _frame(command, handle, 0x0064, context, nil), true
+		}
+		items, ok := _items(data)
+		if !ok {
+			return _frame(command, handle, 0x0003, context, nil), true
+		}
+		if !sess.server._wait() {
+			return nil, false
+		}
+		if command == 0x6F {
+			return sess._sendRRData(context, items), true
+		}
+		return sess._sendUnitData(context, items)
+	}
+	return _frame(command, handle, 0x0001, context, nil), true
+}
+
+func (sess *session) _sendRRData(context []byte, items []item) []byte {
+	var request []byte
+	for _, it := range items {
+		if it.typeID == 0xB2 {
+			request = it.data
+		}
+	}
+	if request == nil {
+		return _frame(0x6F, sess.handle, 0x0003, context, nil)
+	}
+	reply := sess.server._handleCIP(sess, request, false)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(0)) //# interface handle
+	binary.Write(buf, binary.LittleEndian, uint16(0)) //# timeout
+	binary.Write(buf, binary.LittleEndian, uint16(2))
+	binary.Write(buf, binary.LittleEndian, uint16(0x00))
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+	binary.Write(buf, binary.LittleEndian, uint16(0xB2))
+	binary.Write(buf, binary.LittleEndian, uint16(len(reply)))
+	buf.Write(reply)
+	return _frame(0x6F, sess.handle, 0, context, buf.Bytes())
+}
+
+func (sess *session) _sendUnitData(context []byte, items []item) ([]byte, bool) {
+	/*
+	Connected messages only make sense on a connection we opened,
+	a real controller ignores anything else so we hang up instead
+	of leaving the client waiting
+	*/
+	var connID uint32
+	var request []byte
+	for _, it := range items {
+		switch it.typeID {
+		case 0xA1:
+			if len(it.data) >= 4 {
+				connID = binary.LittleEndian.Uint32(it.data)
+			}
+		case 0xB1:
+			request = it.data
+		}
+	}
+	toID, ok := sess.connections[connID]
+	if !ok || len(request) < 2 {
+		return nil, false
+	}
+	sequence := request[:2]
+	reply := sess.server._handleCIP(sess, request[2:], true)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+	binary.Write(buf, binary.LittleEndian, uint16(2))
+	binary.Write(buf, binary.LittleEndian, uint16(0xA1))
+	binary.Write(buf, binary.LittleEndian, uint16(4))
+	binary.Write(buf, binary.LittleEndian, toID)
+	binary.Write(buf, binary.LittleEndian, uint16(0xB1))
+	binary.Write(buf, binary.LittleEndian, uint16(len(reply)+2))
+	buf.Write(sequence)
+	buf.Write(reply)
+	return _frame(0x70, sess.handle, 0, context, buf.Bytes()), true
+}
+
+func (s *Server) _listIdentity() []byte {
+	/*
+	One identity item (Vol 2 2-4.2.3), the socket address is big endian
+	*/
+	s.mu.Lock()
+	id := s.Identity
+	if s.Bridge != nil {
+		id = *s.Bridge
+	}
+	ip := net.IPv4(127, 0, 0, 1).To4()
+	if s.listener != nil {
+		if addr, ok := s.listener.Addr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+			ip = addr.IP.To4()
+		}
+	}
+	s.mu.Unlock()
+
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, uint16(1)) //# protocol version
+	binary.Write(body, binary.BigEndian, uint16(2)) //# AF_INET
+	binary.Write(body, binary.BigEndian, uint16(44818))
+	body.Write(ip)
+	body.Write(make([]byte, 8))
+	body.Write(_identityAttributes(id))
+	body.WriteByte(0x03) //# state: operational
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	binary.Write(buf, binary.LittleEndian, uint16(0x0C))
+	binary.Write(buf, binary.LittleEndian, uint16(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+type item struct {
+	typeID uint16
+	data []byte
+}
+
+func _items(data []byte) ([]item, bool) {
+	/*
+	Common packet format: interface handle(4) timeout(2) count(2) items
+	*/
+	if len(data) < 8 {
+		return nil, false
+	}
+	count := int(binary.LittleEndian.Uint16(data[6:]))
+	offset := 8
+	var items []item
+	for i := 0; i < count; i++ {
+		if offset+4 > len(data) {
+			return nil, false
+		}
+		typeID := binary.LittleEndian.Uint16(data[offset:])
+		length := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		offset += 4
+		if offset+length > len(data) {
+			return nil, false
+		}
+		items = append(items, item{typeID: typeID, data: data[offset:offset+length]})
+		offset += length
+	}
+	return items, true
+}
+
+func _frame(command uint16, handle uint32, status uint32, context []byte, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, command)
+	binary.Write(buf, binary.LittleEndian, uint16(len(data)))
+	binary.Write(buf, binary.LittleEndian, handle)
+	binary.Write(buf, binary.LittleEndian, status)
+	buf.Write(context)
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func _programScope(name string) (string, string) {
+	/*
+	Splits "Program:Main.Count" into the program and the tag name
+	*/
+	if !strings.HasPrefix(name, "Program:") {
+		return "", name
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
@@ -0,0 +1,731 @@
+package eipsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+Tags are kept the way the controller keeps them: a block of little
+endian bytes per tag, laid out by its type. Structures follow the
+Logix rules, members aligned to their own size, BOOLs packed into a
+hidden SINT, and the whole thing padded to a multiple of 4 bytes
+*/
+
+type Member struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+	Dims []int `yaml:"dims,omitempty" json:"dims,omitempty"` //# members only have one dimension
+}
+
+type Type struct {
+	Name string `yaml:"name" json:"name"`
+	Members []Member `yaml:"members" json:"members"`
+}
+
+type Tag struct {
+	Name string `yaml:"name" json:"name"` //# "Program:Main.Count" for program scope
+	Type string `yaml:"type" json:"type"`
+	Dims []int `yaml:"dims,omitempty" json:"dims,omitempty"`
+	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+	ReadOnly bool `yaml:"read_only,omitempty" json:"read_only,omitempty"`
+	NoAccess bool `yaml:"no_access,omitempty" json:"no_access,omitempty"` //# External Access None
+}
+
+type dataType struct {
+	name string
+	code uint16 //# atomic type code, 0 for structures
+	size int
+	align int
+	template *template
+}
+
+type template struct {
+	instance uint16
+	handle uint16 //# structure handle, what reads and writes identify the type by
+	name string
+	size int
+	members []*member
+	definition []byte
+}
+
+type member struct {
+	name string
+	typ *dataType
+	count int //# array size, 0 when it isn't an array
+	offset int
+	bit int //# BOOL members live in a bit of a hidden SINT
+	bits int //# BOOL array members are DWORDs, this is how many BOOLs
+	hidden bool
+}
+
+type tag struct {
+	name string
+	program string
+	local string //# name within its scope
+	instance uint32
+	typ *dataType
+	dims []int
+	bits int //# BOOL arrays are stored as DWORDs, this is how many BOOLs
+	data []byte
+	readOnly bool
+	noAccess bool
+	isProgram bool
+}
+
+var atomicTypes = map[string]*dataType{
+	"BOOL": {name: "BOOL", code: 0xC1, size: 1, align: 1},
+	"SINT": {name: "SINT", code: 0xC2, size: 1, align: 1},
+	"INT": {name: "INT", code: 0xC3, size: 2, align: 2},
+	"DINT": {name: "DINT", code: 0xC4, size: 4, align: 4},
+	"LINT": {name: "LINT", code: 0xC5, size: 8, align: 8},
+	"USINT": {name: "USINT", code: 0xC6, size: 1, align: 1},
+	"UINT": {name: "UINT", code: 0xC7, size: 2, align: 2},
+	"UDINT": {name: "UDINT", code: 0xC8, size: 4, align: 4},
+	"ULINT": {name: "ULINT", code: 0xC9, size: 8, align: 8},
+	"LWORD": {name: "LWORD", code: 0xC9, size: 8, align: 8},
+	"REAL": {name: "REAL", code: 0xCA, size: 4, align: 4},
+	"LREAL": {name: "LREAL", code: 0xCB, size: 8, align: 8},
+	"DWORD": {name: "DWORD", code: 0xD3, size: 4, align: 4},
+}
+
+//# Micro800 strings are a length byte followed by the characters
+var shortString = &dataType{name: "STRING", code: 0xDA, size: 83, align: 1}
+
+const stringHandle = 0x0FCE
+const stringLength = 82
+
+func (t *dataType) _symbolType() uint16 {
+	if t.template != nil {
+		return 0x8000 | (t.template.instance & 0x0FFF)
+	}
+	return t.code
+}
+
+func (t *dataType) _replyType() []byte {
+	/*
+	Read replies start with the type, structures add their handle
+	*/
+	buf := make([]byte, 2, 4)
+	if t.template == nil {
+		binary.LittleEndian.PutUint16(buf, t.code)
+		return buf
+	}
+	buf[0] = 0xA0
+	buf[1] = 0x02
+	return binary.LittleEndian.AppendUint16(buf, t.template.handle)
+}
+
+func (t *tag) _elements() int {
+	count := 1
+	for _, d := range t.dims {
+		count *= d
+	}
+	return count
+}
+
+func (s *Server) AddType(t Type) error {
+	/*
+	Defines a structure (UDT), the types of its members have to be
+	defined first
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s._addType(t, 0)
+	if err == nil {
+		s.changes++
+	}
+	return err
+}
+
+func (s *Server) _addType(t Type, handle uint16) (*dataType, error) {
+	key := strings.ToUpper(t.Name)
+	if len(t.Name) == 0 {
+		return nil, fmt.Errorf("eipsim: type needs a name")
+	}
+	if _, ok := s.types[key]; ok {
+		return nil, fmt.Errorf("eipsim: type %s already exists", t.Name)
+	}
+	if len(t.Members) == 0 {
+		return nil, fmt.Errorf("eipsim: type %s has no members", t.Name)
+	}
+
+	tmpl := &template{instance: uint16(len(s.templates)+1), name: t.Name}
+	align := 4
+	offset := 0
+	var host *member
+	hostBits := 0
+
+	for _, m := range t.Members {
+		mt, ok := s.types[strings.ToUpper(m.Type)]
+		if !ok {
+			return nil, fmt.Errorf("eipsim: %s.%s: unknown type %s", t.Name, m.Name, m.Type)
+		}
+		if len(m.Dims) > 1 {
+			return nil, fmt.Errorf("eipsim: %s.%s: members can only have one dimension", t.Name, m.Name)
+		}
+		count := 0
+		if len(m.Dims) == 1 {
+			if m.Dims[0] < 1 {
+				return nil, fmt.Errorf("eipsim: %s.%s: invalid dimension %d", t.Name, m.Name, m.Dims[0])
+			}
+			count = m.Dims[0]
+		}
+
+		//# BOOLs share a hidden SINT, up to 8 of them
+		if mt.code == 0xC1 && count == 0 {
+			if host == nil || hostBits == 8 {
+				host = &member{name: fmt.Sprintf("ZZZZZZZZZZ%s%d", t.Name, offset), typ: atomicTypes["SINT"], offset: offset, bit: -1, hidden: true}
+				tmpl.members = append(tmpl.members, host)
+				offset += 1
+				hostBits = 0
+			}
+			tmpl.members = append(tmpl.members, &member{name: m.Name, typ: mt, offset: host.offset, bit: hostBits})
+			hostBits++
+			continue
+		}
+		host = nil
+
+		//# BOOL arrays are DWORDs underneath
+		bits := 0
+		if mt.code == 0xC1 {
+			mt = atomicTypes["DWORD"]
+			bits = count
+			count = (count+31)/32
+		}
+		if mt.align > align {
+			align = mt.align
+		}
+		offset = _alignTo(offset, mt.align)
+		size := mt.size
+		if count > 0 {
+			size *= count
+		}
+		tmpl.members = append(tmpl.members, &member{name: m.Name, typ: mt, count: count, offset: offset, bit: -1, bits: bits})
+		offset += size
+	}
+	tmpl.size = _alignTo(offset, align)
+	tmpl.definition = _templateDefinition(tmpl)
+	if handle == 0 {
+		handle = uint16(crc32.ChecksumIEEE(tmpl.definition))
+	}
+	tmpl.handle = handle
+
+	dt := &dataType{name: t.Name, size: tmpl.size, align: align, template: tmpl}
+	s.templates = append(s.templates, tmpl)
+	s.types[key] = dt
+	return dt, nil
+}
+
+func (s *Server) _addStringType() {
+	s._addType(Type{Name: "STRING", Members: []Member{
+		{Name: "LEN", Type: "DINT"},
+		{Name: "DATA", Type: "SINT", Dims: []int{stringLength}},
+	}}, stringHandle)
+}
+
+func _templateDefinition(tmpl *template) []byte {
+	/*
+	What Read Template returns: 8 bytes per member (info, type, offset),
+	then the template name and the member names, null terminated
+	*/
+	buf := new(bytes.Buffer)
+	for _, m := range tmpl.members {
+		info := uint16(0)
+		typ := m.typ._symbolType()
+		if m.count > 0 {
+			info = uint16(m.count)
+			typ |= 0x2000
+		} else if m.typ.code == 0xC1 {
+			info = uint16(m.bit)
+		}
+		binary.Write(buf, binary.LittleEndian, info)
+		binary.Write(buf, binary.LittleEndian, typ)
+		binary.Write(buf, binary.LittleEndian, uint32(m.offset))
+	}
+	buf.WriteString(tmpl.name + ";n")
+	buf.WriteByte(0x00)
+	for _, m := range tmpl.members {
+		buf.WriteString(m.name)
+		buf.WriteByte(0x00)
+	}
+	return buf.Bytes()
+}
+
+func _alignTo(offset, align int) int {
+	if offset%align > 0 {
+		return offset + align - offset%align
+	}
+	return offset
+}
+
+func (s *Server) AddTag(t Tag) error {
+	/*
+	Adds a tag, program scoped when its name starts with "Program:"
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s._addTag(t)
+}
+
+func (s *Server) _addTag(t Tag) error {
+	program, local := _programScope(t.Name)
+	if len(local) == 0 || strings.ContainsAny(local, ".[]") {
+		return fmt.Errorf("eipsim: invalid tag name %q", t.Name)
+	}
+	if len(program) > 0 && s.Micro800 {
+		return fmt.Errorf("eipsim: %s: Micro800 has no program scope", t.Name)
+	}
+	if _, ok := s.tagIndex[strings.ToUpper(t.Name)]; ok {
+		return fmt.Errorf("eipsim: tag %s already exists", t.Name)
+	}
+	typ, ok := s.types[strings.ToUpper(t.Type)]
+	if !ok {
+		return fmt.Errorf("eipsim: %s: unknown type %s", t.Name, t.Type)
+	}
+	if s.Micro800 && typ.template != nil && typ.template.handle == stringHandle {
+		typ = shortString
+	}
+	if len(t.Dims) > 3 {
+		return fmt.Errorf("eipsim: %s: at most 3 dimensions", t.Name)
+	}
+	for _, d := range t.Dims {
+		if d < 1 {
+			return fmt.Errorf("eipsim: %s: invalid dimension %d", t.Name, d)
+		}
+	}
+
+	if len(program) > 0 {
+		if _, ok := s.tagIndex[strings.ToUpper(program)]; !ok {
+			s._register(&tag{name: program, local: program, isProgram: true})
+		}
+	}
+
+	tg := &tag{
+		name: t.Name,
+		program: program,
+		local: local,
+		typ: typ,
+		dims: append([]int(nil), t.Dims...),
+		readOnly: t.ReadOnly,
+		noAccess: t.NoAccess,
+	}
+	if typ.code == 0xC1 && len(t.Dims) > 0 {
+		if len(t.Dims) > 1 {
+			return fmt.Errorf("eipsim: %s: BOOL arrays can only have one dimension", t.Name)
+		}
+		tg.bits = t.Dims[0]
+		tg.typ = atomicTypes["DWORD"]
+		tg.dims = []int{(t.Dims[0]+31)/32}
+	}
+	tg.data = make([]byte, tg.typ.size*tg._elements())
+	if err := tg._set(t.Value); err != nil {
+		return err
+	}
+	s._register(tg)
+	return nil
+}
+
+func (s *Server) RemoveTag(name string) error {
+	/*
+	Deletes a tag like a download of a changed project would, adding
+	it back gives it a new instance
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tagIndex[strings.ToUpper(name)]
+	if !ok || t.isProgram {
+		return fmt.Errorf("eipsim: tag %s not found", name)
+	}
+	delete(s.tagIndex, strings.ToUpper(name))
+	for i, candidate := range s.tags {
+		if candidate == t {
+			s.tags = append(s.tags[:i], s.tags[i+1:]...)
+			break
+		}
+	}
+	s.changes++
+	return nil
+}
+
+func (s *Server) RenumberInstances() {
+	/*
+	Hands the controller scoped tags each other's instances, last to
+	first, as a download can: an instance from before finds another tag
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scoped []*tag
+	for _, t := range s.tags {
+		if len(t.program) == 0 {
+			scoped = append(scoped, t)
+		}
+	}
+	for i, j := 0, len(scoped)-1; i < j; i, j = i+1, j-1 {
+		scoped[i].instance, scoped[j].instance = scoped[j].instance, scoped[i].instance
+	}
+	//# browsing goes through the tags in instance order
+	sort.SliceStable(s.tags, func(a, b int) bool {
+		return s.tags[a].instance < s.tags[b].instance
+	})
+	s.changes++
+}
+
+func (s *Server) _register(t *tag) {
+	t.instance = s.nextInstance
+	s.nextInstance++
+	s.changes++
+	s.tags = append(s.tags, t)
+	s.tagIndex[strings.ToUpper(t.name)] = t
+}
+
+func (s *Server) Get(name string) (interface{}, error) {
+	/*
+	Returns the value of a whole tag, arrays as []interface{},
+	structures as map[string]interface{}
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tagIndex[strings.ToUpper(name)]
+	if !ok || t.isProgram {
+		return nil, fmt.Errorf("eipsim: tag %s not found", name)
+	}
+	if t.bits > 0 {
+		var vals []interface{}
+		for i := 0; i < t.bits; i++ {
+			vals = append(vals, t.data[i/8]&(1<<(i%8)) > 0)
+		}
+		return vals, nil
+	}
+	if len(t.dims) == 0 {
+		return _decode(t.typ, t.data), nil
+	}
+	var vals []interface{}
+	for i := 0; i < t._elements(); i++ {
+		vals = append(vals, _decode(t.typ, t.data[i*t.typ.size:]))
+	}
+	return vals, nil
+}
+
+func (s *Server) Set(name string, value interface{}) error {
+	/*
+	Changes the value of a whole tag, like the logic would
+	*/
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tagIndex[strings.ToUpper(name)]
+	if !ok || t.isProgram {
+		return fmt.Errorf("eipsim: tag %s not found", name)
+	}
+	return t._set(value)
+}
+
+func (t *tag) _set(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	data := make([]byte, len(t.data))
+	if t.bits > 0 {
+		vals, ok := _slice(value)
+		if !ok {
+			vals = []interface{}{value}
+		}
+		for i, v := range vals {
+			if i >= t.bits {
+				break
+			}
+			b, ok := _toInt64(v)
+			if !ok {
+				return fmt.Errorf("eipsim: %s: can't store %T in a BOOL", t.name, v)
+			}
+			if b != 0 {
+				data[i/8] |= 1 << (i%8)
+			}
+		}
+	} else if len(t.dims) == 0 {
+		if err := _encode(t.typ, data, value); err != nil {
+			return fmt.Errorf("eipsim: %s: %v", t.name, err)
+		}
+	} else {
+		if err := _encodeArray(t.typ, t._elements(), data, value); err != nil {
+			return fmt.Errorf("eipsim: %s: %v", t.name, err)
+		}
+	}
+	t.data = data
+	return nil
+}
+
+func _encodeArray(typ *dataType, count int, buf []byte, value interface{}) error {
+	vals, ok := _slice(value)
+	if !ok {
+		vals = []interface{}{value}
+	}
+	if len(vals) > count {
+		return fmt.Errorf("%d values for %d elements", len(vals), count)
+	}
+	for i, v := range vals {
+		if err := _encode(typ, buf[i*typ.size:], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func _encode(typ *dataType, buf []byte, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	if typ == shortString {
+		str, ok := value.(string)
+		if !ok || len(str) > stringLength {
+			return fmt.Errorf("can't store %v in a STRING", value)
+		}
+		buf[0] = byte(len(str))
+		copy(buf[1:], str)
+		return nil
+	}
+	if typ.template != nil {
+		return _encodeStruct(typ.template, buf, value)
+	}
+
+	if typ.code == 0xCA || typ.code == 0xCB {
+		f, ok := _toFloat64(value)
+		if !ok {
+			return fmt.Errorf("can't store %T in a %s", value, typ.name)
+		}
+		if typ.code == 0xCA {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+		}
+		return nil
+	}
+	n, ok := _toInt64(value)
+	if !ok {
+		return fmt.Errorf("can't store %T in a %s", value, typ.name)
+	}
+	switch typ.size {
+	case 1:
+		if typ.code == 0xC1 && n != 0 {
+			n = 0xFF
+		}
+		buf[0] = byte(n)
+	case 2:
+		binary.LittleEndian.PutUint16(buf, uint16(n))
+	case 4:
+		binary.LittleEndian.PutUint32(buf, uint32(n))
+	case 8:
+		binary.LittleEndian.PutUint64(buf, uint64(n))
+	}
+	return nil
+}
+
+func _encodeStruct(tmpl *template, buf []byte, value interface{}) error {
+	if tmpl.handle == stringHandle {
+		str, ok := value.(string)
+		if !ok || len(str) > stringLength {
+			return fmt.Errorf("can't store %v in a STRING", value)
+		}
+		binary.LittleEndian.PutUint32(buf, uint32(len(str)))
+		copy(buf[4:4+stringLength], make([]byte, stringLength))
+		copy(buf[4:], str)
+		return nil
+	}
+	fields, ok := _fields(value)
+	if !ok {
+		return fmt.Errorf("can't store %T in a %s", value, tmpl.name)
+	}
+	for name, v := range fields {
+		m := tmpl._member(name)
+		if m == nil {
+			return fmt.Errorf("%s has no member %s", tmpl.name, name)
+		}
+		if err := m._encode(buf, v); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (m *member) _encode(buf []byte, value interface{}) error {
+	if m.bit >= 0 && m.typ.code == 0xC1 {
+		b, ok := _toInt64(value)
+		if !ok {
+			return fmt.Errorf("can't store %T in a BOOL", value)
+		}
+		if b != 0 {
+			buf[m.offset] |= 1 << m.bit
+		} else {
+			buf[m.offset] &^= 1 << m.bit
+		}
+		return nil
+	}
+	if m.bits > 0 {
+		vals, ok := _slice(value)
+		if !ok || len(vals) > m.bits {
+			return fmt.Errorf("can't store %v in a BOOL[%d]", value, m.bits)
+		}
+		for i, v := range vals {
+			b, ok := _toInt64(v)
+			if !ok {
+				return fmt.Errorf("can't store %T in a BOOL", v)
+			}
+			if b != 0 {
+				buf[m.offset+i/8] |= 1 << (i%8)
+			} else {
+				buf[m.offset+i/8] &^= 1 << (i%8)
+			}
+		}
+		return nil
+	}
+	if m.count > 0 {
+		return _encodeArray(m.typ, m.count, buf[m.offset:], value)
+	}
+	return _encode(m.typ, buf[m.offset:], value)
+}
+
+func (tmpl *template) _member(name string) *member {
+	for _, m := range tmpl.members {
+		if !m.hidden && strings.EqualFold(m.name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
+func _decode(typ *dataType, buf []byte) interface{} {
+	if typ == shortString {
+		return string(buf[1:1+int(buf[0])])
+	}
+	if typ.template != nil {
+		tmpl := typ.template
+		if tmpl.handle == stringHandle {
+			n := int(binary.LittleEndian.Uint32(buf))
+			if n > stringLength {
+				n = stringLength
+			}
+			return string(buf[4:4+n])
+		}
+		fields := make(map[string]interface{})
+		for _, m := range tmpl.members {
+			if m.hidden {
+				continue
+			}
+			switch {
+			case m.bit >= 0 && m.typ.code == 0xC1:
+				fields[m.name] = buf[m.offset]&(1<<m.bit) > 0
+			case m.bits > 0:
+				var vals []interface{}
+				for i := 0; i < m.bits; i++ {
+					vals = append(vals, buf[m.offset+i/8]&(1<<(i%8)) > 0)
+				}
+				fields[m.name] = vals
+			case m.count > 0:
+				var vals []interface{}
+				for i := 0; i < m.count; i++ {
+					vals = append(vals, _decode(m.typ, buf[m.offset+i*m.typ.size:]))
+				}
+				fields[m.name] = vals
+			default:
+				fields[m.name] = _decode(m.typ, buf[m.offset:])
+			}
+		}
+		return fields
+	}
+	switch typ.code {
+	case 0xC1:
+		return buf[0] != 0
+	case 0xC2:
+		return int8(buf[0])
+	case 0xC3:
+		return int16(binary.LittleEndian.Uint16(buf))
+	case 0xC4:
+		return int32(binary.LittleEndian.Uint32(buf))
+	case 0xC5:
+		return int64(binary.LittleEndian.Uint64(buf))
+	case 0xC6:
+		return buf[0]
+	case 0xC7:
+		return binary.LittleEndian.Uint16(buf)
+	case 0xC8, 0xD3:
+		return binary.LittleEndian.Uint32(buf)
+	case 0xC9:
+		return binary.LittleEndian.Uint64(buf)
+	case 0xCA:
+		return math.Float32frombits(binary.LittleEndian.Uint32(buf))
+	case 0xCB:
+		return math.Float64frombits(binary.LittleEndian.Uint64(buf))
+	}
+	return nil
+}
+
+func _slice(value interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	vals := make([]interface{}, v.Len())
+	for i := range vals {
+		vals[i] = v.Index(i).Interface()
+	}
+	return vals, true
+}
+
+func _fields(value interface{}) (map[string]interface{}, bool) {
+	/*
+	YAML and JSON give us map[string]interface{}, older YAML decoders
+	map[interface{}]interface{}
+	*/
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		fields := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			fields[fmt.Sprint(k)] = v
+		}
+		return fields, true
+	}
+	return nil, false
+}
+
+func _toInt64(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		//# JSON numbers are all floats
+		f := v.Float()
+		if f == math.Trunc(f) {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
+
+func _toFloat64(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,276 @@
+package eipsim
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+/*
+Read Tag (0x4C), Read Tag Fragmented (0x52), Write Tag (0x4D), Write
+Tag Fragmented (0x53) and Read Modify Write Tag (0x4E), addressed by
+symbolic segments, array element segments and member names, or by
+symbol instance
+*/
+
+type location struct {
+	tag *tag
+	typ *dataType
+	offset int //# byte offset into the tag data
+	available int //# elements left in the array from offset on
+	bit int //# BOOL members, -1 otherwise
+}
+
+func (s *Server) _resolve(path []segment) (location, byte) {
+	var loc location
+	var t *tag
+	i := 0
+
+	switch {
+	case len(path) >= 2 && path[0].kind == 'c' && path[0].value == 0x6B && path[1].kind == 'i':
+		for _, candidate := range s.tags {
+			if candidate.instance == path[1].value {
+				t = candidate
+			}
+		}
+		i = 2
+	case len(path) >= 1 && path[0].kind == 's':
+		name := path[0].name
+		i = 1
+		if strings.HasPrefix(name, "Program:") {
+			if len(path) < 2 || path[1].kind != 's' {
+				return loc, statusPathSegment
+			}
+			name += "." + path[1].name
+			i = 2
+		}
+		t = s.tagIndex[strings.ToUpper(name)]
+	default:
+		return loc, statusPathSegment
+	}
+	if t == nil || t.isProgram {
+		return loc, statusPathUnknown
+	}
+
+	loc = location{tag: t, typ: t.typ, available: t._elements(), bit: -1}
+	dims := t.dims
+	for i < len(path) {
+		switch path[i].kind {
+		case 'e':
+			var index []int
+			for ; i < len(path) && path[i].kind == 'e'; i++ {
+				index = append(index, int(path[i].value))
+			}
+			if len(dims) == 0 || len(index) > len(dims) {
+				return loc, statusPathSegment
+			}
+			linear := 0
+			total := 1
+			for k, d := range dims {
+				v := 0
+				if k < len(index) {
+					v = index[k]
+				}
+				if v >= d {
+					return loc, statusPathUnknown
+				}
+				linear = linear*d + v
+				total *= d
+			}
+			loc.offset += linear*loc.typ.size
+			loc.available = total-linear
+			dims = nil
+		case 's':
+			if loc.typ.template == nil || len(dims) > 0 || loc.typ == shortString {
+				return loc, statusPathSegment
+			}
+			m := loc.typ.template._member(path[i].name)
+			if m == nil {
+				return loc, statusPathUnknown
+			}
+			loc.offset += m.offset
+			loc.typ = m.typ
+			loc.available = 1
+			if m.bit >= 0 && m.typ.code == 0xC1 {
+				loc.bit = m.bit
+			}
+			if m.count > 0 {
+				dims = []int{m.count}
+				loc.available = m.count
+			}
+			i++
+		default:
+			return loc, statusPathSegment
+		}
+	}
+	return loc, statusSuccess
+}
+
+func (s *Server) _tagService(req request) []byte {
+	if s.Micro800 && (req.service == 0x52 || req.service == 0x53) {
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+	switch req.service {
+	case 0x4C, 0x52, 0x4D, 0x53, 0x4E:
+	default:
+		return _reply(req.service, statusServiceNotSupported, nil, nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, status := s._resolve(req.path)
+	if status != statusSuccess {
+		return _reply(req.service, status, nil, nil)
+	}
+	if loc.tag.noAccess {
+		return _reply(req.service, statusPrivilege, nil, nil)
+	}
+	switch req.service {
+	case 0x4C, 0x52:
+		return s._readTag(req, loc)
+	case 0x4E:
+		return s._readModifyWrite(req, loc)
+	}
+	return s._writeTag(req, loc)
+}
+
+func (s *Server) _readModifyWrite(req request, loc location) []byte {
+	/*
+	mask size(2) OR mask, AND mask: bits set in the first are set,
+	bits clear in the second are cleared, on integers only
+	*/
+	d := req.data
+	if len(d) < 2 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	size := int(binary.LittleEndian.Uint16(d))
+	if len(d) < 2+2*size {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	switch {
+	case loc.bit >= 0 || loc.typ.template != nil || loc.typ.code == 0xC1 || loc.typ.code == 0xCA || loc.typ.code == 0xCB:
+		return _reply(req.service, statusGeneral, []uint16{0x2107}, nil)
+	case size > loc.typ.size:
+		return _reply(req.service, statusTooMuchData, nil, nil)
+	case loc.tag.readOnly:
+		return _reply(req.service, statusPrivilege, nil, nil)
+	}
+	or, and := d[2:2+size], d[2+size:2+2*size]
+	for i := 0; i < size; i++ {
+		b := loc.tag.data[loc.offset+i]
+		loc.tag.data[loc.offset+i] = (b | or[i]) & and[i]
+	}
+	return _reply(req.service, statusSuccess, nil, nil)
+}
+
+func (s *Server) _readTag(req request, loc location) []byte {
+	/*
+	Read Tag: elements(2)
+	Read Tag Fragmented: elements(2) byte offset(4)
+	*/
+	d := req.data
+	if len(d) < 2 || (req.service == 0x52 && len(d) < 6) {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	count := int(binary.LittleEndian.Uint16(d))
+	if count < 1 {
+		count = 1
+	}
+	if count > loc.available {
+		return _reply(req.service, statusGeneral, []uint16{0x2105}, nil)
+	}
+
+	if loc.bit >= 0 {
+		value := byte(0x00)
+		if loc.tag.data[loc.offset]&(1<<loc.bit) > 0 {
+			value = 0xFF
+		}
+		return _reply(req.service, statusSuccess, nil, append(loc.typ._replyType(), value))
+	}
+
+	data := loc.tag.data[loc.offset:loc.offset+count*loc.typ.size]
+	start := 0
+	if req.service == 0x52 {
+		start = int(binary.LittleEndian.Uint32(d[2:]))
+		if start > len(data) {
+			return _reply(req.service, statusGeneral, []uint16{0x2104}, nil)
+		}
+	}
+	//# what doesn't fit comes back as a partial transfer, fragmented reads pick it up from there
+	status := byte(statusSuccess)
+	chunk := data[start:]
+	if len(chunk) > maxReplyData {
+		size := maxReplyData
+		if loc.typ.size <= maxReplyData {
+			size -= size%loc.typ.size
+		}
+		chunk = chunk[:size]
+		status = statusPartial
+	}
+	return _reply(req.service, status, nil, append(loc.typ._replyType(), chunk...))
+}
+
+func (s *Server) _writeTag(req request, loc location) []byte {
+	/*
+	Write Tag: type(2, 4 for structures) elements(2) data
+	Write Tag Fragmented: type elements(2) byte offset(4) data
+	*/
+	d := req.data
+	replyType := loc.typ._replyType()
+	if len(d) < len(replyType) || string(d[:len(replyType)]) != string(replyType) {
+		if len(d) < 2 {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		return _reply(req.service, statusGeneral, []uint16{0x2107}, nil)
+	}
+	d = d[len(replyType):]
+	if len(d) < 2 {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	count := int(binary.LittleEndian.Uint16(d))
+	d = d[2:]
+	start := 0
+	if req.service == 0x53 {
+		if len(d) < 4 {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		start = int(binary.LittleEndian.Uint32(d))
+		d = d[4:]
+	}
+	if loc.tag.readOnly {
+		return _reply(req.service, statusPrivilege, nil, nil)
+	}
+	if count < 1 || count > loc.available {
+		return _reply(req.service, statusGeneral, []uint16{0x2105}, nil)
+	}
+
+	if loc.bit >= 0 {
+		if len(d) < 1 {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		if d[0] != 0 {
+			loc.tag.data[loc.offset] |= 1 << loc.bit
+		} else {
+			loc.tag.data[loc.offset] &^= 1 << loc.bit
+		}
+		return _reply(req.service, statusSuccess, nil, nil)
+	}
+
+	size := count*loc.typ.size
+	if loc.typ == shortString && count == 1 {
+		//# short strings only send the characters they have
+		if len(d) < 1 || len(d) < 1+int(d[0]) || int(d[0]) > stringLength {
+			return _reply(req.service, statusNotEnoughData, nil, nil)
+		}
+		copy(loc.tag.data[loc.offset:loc.offset+size], make([]byte, size))
+		copy(loc.tag.data[loc.offset:], d[:1+int(d[0])])
+		return _reply(req.service, statusSuccess, nil, nil)
+	}
+	if start+len(d) > size {
+		return _reply(req.service, statusTooMuchData, nil, nil)
+	}
+	if req.service == 0x4D && len(d) < size {
+		return _reply(req.service, statusNotEnoughData, nil, nil)
+	}
+	copy(loc.tag.data[loc.offset+start:], d)
+	return _reply(req.service, statusSuccess, nil, nil)
+}
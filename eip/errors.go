@@ -0,0 +1,309 @@
+package eip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	ErrConnectionLost = errors.New("eip: connection lost")
+	ErrTimeout = errors.New("eip: timeout")
+	ErrTagNotFound = errors.New("eip: tag not found")
+	ErrTypeMismatch = errors.New("eip: type mismatch")
+	ErrOverflow = errors.New("eip: value out of range")
+	ErrInvalidTag = errors.New("eip: invalid tag name")
+	ErrNoAccess = errors.New("eip: no external access")
+)
+
+type CIPError struct {
+	Service byte
+	Class byte //# object class the request went to, picks the extended status table
+	Path string //# tag name or object the request went to
+	Status byte
+	ExtStatus []uint16
+}
+
+/*
+Extended status codes only mean something together with the object
+that returned them (Vol 1 Appendix B)
+Connection failures (0x01) always carry Connection Manager codes,
+no matter which object the request was for, since it's the one that
+opens connections and routes unconnected messages
+The other objects this client talks to have no published codes: the
+Message Router (0x02) and the Identity Object (0x01) define none of
+their own, the Controller (0xAC) and WallClock (0x8B) objects are
+Rockwell's and undocumented, and PCCC (0x67) reports its errors in
+the STS and EXT STS of the PCCC reply (PCCCError). Their extended
+status is shown as the raw words
+*/
+var cipExtendedErrorCodes = map[byte]map[uint16]string{
+	0x06: { //# Connection Manager (Vol 1 3-5.6)
+		0x0100: "Connection in use or duplicate Forward Open",
+		0x0103: "Transport class and trigger combination not supported",
+		0x0106: "Ownership conflict",
+		0x0107: "Target connection not found",
+		0x0108: "Invalid network connection parameter",
+		0x0109: "Invalid connection size",
+		0x0110: "Target for connection not configured",
+		0x0111: "RPI not supported",
+		0x0112: "RPI value not acceptable",
+		0x0113: "Out of connections",
+		0x0114: "Vendor ID or product code mismatch",
+		0x0115: "Device type mismatch",
+		0x0116: "Revision mismatch",
+		0x0117: "Invalid produced or consumed application path",
+		0x0118: "Invalid or inconsistent configuration application path",
+		0x0119: "Non-listen only connection not opened",
+		0x011A: "Target object out of connections",
+		0x011B: "RPI is smaller than the production inhibit time",
+		0x011C: "Transport class not supported",
+		0x011D: "Production trigger not supported",
+		0x011E: "Direction not supported",
+		0x011F: "Invalid O->T network connection fixed/variable flag",
+		0x0120: "Invalid T->O network connection fixed/variable flag",
+		0x0121: "Invalid O->T network connection priority",
+		0x0122: "Invalid T->O network connection priority",
+		0x0123: "Invalid O->T network connection type",
+		0x0124: "Invalid T->O network connection type",
+		0x0125: "Invalid O->T network connection redundant owner flag",
+		0x0126: "Invalid configuration size",
+		0x0127: "Invalid O->T size",
+		0x0128: "Invalid T->O size",
+		0x0129: "Invalid configuration application path",
+		0x012A: "Invalid consuming application path",
+		0x012B: "Invalid producing application path",
+		0x012C: "Configuration symbol does not exist",
+		0x012D: "Consuming symbol does not exist",
+		0x012E: "Producing symbol does not exist",
+		0x012F: "Inconsistent application path combination",
+		0x0130: "Inconsistent consume data format",
+		0x0131: "Inconsistent produce data format",
+		0x0132: "Null Forward Open function not supported",
+		0x0133: "Connection timeout multiplier not acceptable",
+		0x0203: "Connection timed out",
+		0x0204: "Unconnected request timed out",
+		0x0205: "Parameter error in unconnected request service",
+		0x0206: "Message too large for Unconnected Send service",
+		0x0207: "Unconnected acknowledge without reply",
+		0x0301: "No buffer memory available",
+		0x0302: "Network bandwidth not available for data",
+		0x0303: "No consumed connection ID filter available",
+		0x0304: "Not configured to send scheduled priority data",
+		0x0305: "Schedule signature mismatch",
+		0x0306: "Schedule signature validation not possible",
+		0x0311: "Port not available",
+		0x0312: "Link address not valid",
+		0x0315: "Invalid segment in connection path",
+		0x0316: "Error in Forward Close service connection path",
+		0x0317: "Scheduling not specified",
+		0x0318: "Link address to self invalid",
+		0x0319: "Secondary resources unavailable",
+		0x031A: "Rack connection already established",
+		0x031B: "Module connection already established",
+		0x031C: "Miscellaneous",
+		0x031D: "Redundant connection mismatch",
+		0x031E: "No more user configurable link consumer resources available in the producing module",
+		0x031F: "No user configurable link consumer resources configured in the producing module",
+		0x0800: "Network link offline",
+		0x0810: "No target application data available",
+		0x0811: "No originator application data available",
+		0x0812: "Node address has changed since the network was scheduled",
+		0x0813: "Not configured for off-subnet multicast",
+		0x0814: "Invalid produce/consume data format",
+	},
+	0x6B: { //# Logix Symbol object, with general status 0xFF
+		0x2101: "Keyswitch position prevents the operation",
+		0x2102: "Controller mode prevents the operation",
+		0x2104: "Offset is beyond the end of the tag",
+		0x2105: "Number of elements goes beyond the end of the tag",
+		0x2106: "Data in use by another request",
+		0x2107: "Data type does not match the tag",
+	},
+	0x6C: { //# Template object
+		0x2104: "Offset is beyond the end of the template",
+		0x2105: "Number of bytes goes beyond the end of the template",
+	},
+}
+
+func (e *CIPError) ExtendedMessage() string {
+	/*
+	Describes the first extended status word, empty when there isn't
+	one or the object's codes aren't known
+	*/
+	if len(e.ExtStatus) == 0 {
+		return ""
+	}
+	class := e.Class
+	if e.Status == 0x01 {
+		class = 0x06
+	}
+	if msg, ok := cipExtendedErrorCodes[class][e.ExtStatus[0]]; ok {
+		return msg
+	}
+	return ""
+}
+
+func (e *CIPError) Error() string {
+	msg, ok := cipErrorCodes[uint16(e.Status)]
+	if !ok {
+		msg = "Unknown error"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "eip: service 0x%02X", e.Service)
+	if len(e.Path) > 0 {
+		fmt.Fprintf(&b, " %s", e.Path)
+	}
+	fmt.Fprintf(&b, ": %s (0x%02X)", msg, e.Status)
+	if len(e.ExtStatus) > 0 {
+		if ext := e.ExtendedMessage(); len(ext) > 0 {
+			fmt.Fprintf(&b, ", %s (0x%04X", ext, e.ExtStatus[0])
+		} else {
+			fmt.Fprintf(&b, ", extended status (0x%04X", e.ExtStatus[0])
+		}
+		for _, ext := range e.ExtStatus[1:] {
+			fmt.Fprintf(&b, " 0x%04X", ext)
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func (e *CIPError) Is(target error) bool {
+	/*
+	Lets errors.Is match the sentinels for statuses that mean the same thing
+	*/
+	switch target {
+	case ErrConnectionLost:
+		return e.Status == 0x07 || (e.Status == 0x01 && len(e.ExtStatus) > 0 && connectionLostStatus[e.ExtStatus[0]])
+	case ErrTagNotFound:
+		return e.Status == 0x04 || e.Status == 0x05
+	case ErrNoAccess:
+		return e.Status == 0x0F
+	}
+	return false
+}
+
+type PathError struct {
+	Path string
+	Pos int //# byte offset into Path where parsing stopped
+	Msg string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("eip: invalid tag %q: %s at position %d", e.Path, e.Msg, e.Pos+1)
+}
+
+func (e *PathError) Is(target error) bool {
+	return target == ErrInvalidTag
+}
+
+type EncapError struct {
+	Command uint16
+	Status uint32
+}
+
+var encapErrorCodes = map[uint32]string{
+	0x0001: "Invalid or unsupported command",
+	0x0002: "Insufficient memory",
+	0x0003: "Poorly formed or incorrect data",
+	0x0064: "Invalid session handle",
+	0x0065: "Invalid length",
+	0x0069: "Unsupported protocol revision",
+}
+
+func (e *EncapError) Error() string {
+	msg, ok := encapErrorCodes[e.Status]
+	if !ok {
+		msg = "Unknown error"
+	}
+	return fmt.Sprintf("eip: command 0x%02X: %s (0x%04X)", e.Command, msg, e.Status)
+}
+
+func (e *EncapError) Is(target error) bool {
+	//# the target forgot our session, it has to be registered again
+	return target == ErrConnectionLost && e.Status == 0x0064
+}
+
+type PCCCError struct {
+	Address string
+	Status byte
+	ExtStatus byte
+}
+
+func (e *PCCCError) Error() string {
+	var msg string
+	var ok bool
+	if e.Status == 0xF0 {
+		msg, ok = pcccExtErrorCodes[e.ExtStatus]
+	} else {
+		msg, ok = pcccErrorCodes[e.Status&0xF0]
+	}
+	if !ok {
+		msg = "Unknown error"
+	}
+	if e.Status == 0xF0 {
+		return fmt.Sprintf("pccc: %s: %s (STS 0x%02X, EXT STS 0x%02X)", e.Address, msg, e.Status, e.ExtStatus)
+	}
+	return fmt.Sprintf("pccc: %s: %s (STS 0x%02X)", e.Address, msg, e.Status)
+}
+
+func _replyError(reply []byte, class byte, path string) error {
+	/*
+	Returns a CIPError for a failed CIP reply
+	Partial transfer (0x06) isn't a failure, there's just more to read
+	*/
+	if len(reply) < 4 {
+		return fmt.Errorf("eip: %s: reply too short (%d bytes)", path, len(reply))
+	}
+	status := reply[2]
+	if status == 0x00 || status == 0x06 {
+		return nil
+	}
+	return _cipError(reply, class, path)
+}
+
+func _cipError(reply []byte, class byte, path string) *CIPError {
+	/*
+	Reply layout (Vol 1 2-4.2): service, reserved, general status,
+	size of the additional status in words, then the additional status
+	*/
+	e := &CIPError{
+		Service: reply[0] & 0x7F,
+		Class: class,
+		Path: path,
+		Status: reply[2],
+	}
+	extSize := int(reply[3])
+	for i := 0; i < extSize && 4+2*i+1 < len(reply); i++ {
+		e.ExtStatus = append(e.ExtStatus, binary.LittleEndian.Uint16(reply[4+2*i:]))
+	}
+	return e
+}
+
+func _replyData(reply []byte) []byte {
+	/*
+	Reply data follows the general status and the additional status words
+	*/
+	if len(reply) < 4 {
+		return nil
+	}
+	start := 4+2*int(reply[3])
+	if start > len(reply) {
+		return nil
+	}
+	return reply[start:]
+}
+
+func _ioError(op string, err error) error {
+	/*
+	Wraps socket errors so callers can match them with errors.Is
+	*/
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %s: %v", ErrTimeout, op, err)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrConnectionLost, op, err)
+}
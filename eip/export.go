@@ -0,0 +1,254 @@
+package eip
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+An inventory is the tag list in a form meant for people and other
+tools: type names instead of codes, every dimension, the access and,
+for structures, the members of the UDT down to the atomic types
+	err := plc.ExportTagList(f, eip.ExportCSV)
+Tags are sorted by path, not by instance, so that the inventories of
+two versions of a project can be compared line by line. JSON and YAML
+nest the members under their tag, CSV has a row per member with the
+full path to it
+*/
+
+type ExportFormat string
+
+const (
+	ExportCSV ExportFormat = "csv"
+	ExportJSON ExportFormat = "json"
+	ExportYAML ExportFormat = "yaml"
+)
+
+type TagInfo struct {
+	Path string `json:"path" yaml:"path"`
+	Type string `json:"type" yaml:"type"`
+	Dimensions []int `json:"dimensions,omitempty" yaml:"dimensions,omitempty,flow"`
+	Struct bool `json:"struct" yaml:"struct"`
+	System bool `json:"system" yaml:"system"`
+	Access string `json:"access" yaml:"access"`
+	Members []MemberInfo `json:"members,omitempty" yaml:"members,omitempty"`
+}
+
+type MemberInfo struct {
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+	Type string `json:"type" yaml:"type"`
+	Dimensions []int `json:"dimensions,omitempty" yaml:"dimensions,omitempty,flow"`
+	Struct bool `json:"struct" yaml:"struct"`
+	Members []MemberInfo `json:"members,omitempty" yaml:"members,omitempty"`
+}
+
+func (plc *PLC)Inventory() ([]TagInfo, error) {
+	return plc.InventoryContext(context.Background())
+}
+
+func (plc *PLC)InventoryContext(ctx context.Context) ([]TagInfo, error) {
+	/*
+	Uses the tag list already read, or reads it, and the templates of
+	every structure type in it
+	*/
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return nil, err
+	}
+	plc.cacheMu.RLock()
+	tagList := plc.TagList
+	plc.cacheMu.RUnlock()
+	if tagList == nil {
+		var err error
+		if tagList, err = plc._getTagList(); err != nil {
+			return nil, err
+		}
+	}
+
+	tags := make([]TagInfo, 0, len(tagList))
+	for _, t := range tagList {
+		info := TagInfo{
+			Path: t.TagName,
+			Type: plc._symbolTypeName(t.SymbolType),
+			Dimensions: _elementDims(t.DataType, t.Dimensions),
+			Struct: t.IsStruct,
+			System: t.IsSystem,
+			Access: t.ExternalAccess.String(),
+		}
+		if t.IsStruct {
+			tmpl, members, err := plc._inventoryMembers(t.TagName, t.TemplateInstance)
+			if err != nil {
+				return nil, err
+			}
+			if tmpl != nil {
+				info.Type = tmpl.Name
+			}
+			info.Members = members
+		}
+		tags = append(tags, info)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return strings.ToUpper(tags[i].Path) < strings.ToUpper(tags[j].Path)
+	})
+	return tags, nil
+}
+
+func (plc *PLC)ExportTagList(w io.Writer, format ExportFormat) error {
+	return plc.ExportTagListContext(context.Background(), w, format)
+}
+
+func (plc *PLC)ExportTagListContext(ctx context.Context, w io.Writer, format ExportFormat) error {
+	if err := _checkFormat(format); err != nil {
+		return err
+	}
+	tags, err := plc.InventoryContext(ctx)
+	if err != nil {
+		return err
+	}
+	return WriteInventory(w, format, tags)
+}
+
+func WriteInventory(w io.Writer, format ExportFormat, tags []TagInfo) error {
+	switch format {
+	case ExportCSV:
+		return _writeInventoryCSV(w, tags)
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tags)
+	case ExportYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(tags); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return _checkFormat(format)
+}
+
+func _checkFormat(format ExportFormat) error {
+	switch format {
+	case ExportCSV, ExportJSON, ExportYAML:
+		return nil
+	}
+	return fmt.Errorf("eip: unknown export format %q", format)
+}
+
+func (plc *PLC)_inventoryMembers(path string, instance uint16) (*Template, []MemberInfo, error) {
+	/*
+	The members of one structure, and theirs. A template that can't be
+	read leaves the members out rather than the whole inventory, unless
+	the call was cancelled
+	STRING types are a type of their own here, LEN and DATA aren't
+	listed
+	*/
+	tmpl, err := plc._getTemplate(instance)
+	if err != nil {
+		if plc._context().Err() != nil {
+			return nil, nil, err
+		}
+		plc._log().Warnf("%s: no members for %s: %v", plc._logContext(), path, err)
+		return nil, nil, nil
+	}
+	if tmpl.IsString() {
+		return tmpl, nil, nil
+	}
+
+	var members []MemberInfo
+	for _, m := range tmpl.Members {
+		if m.Hidden() {
+			continue
+		}
+		info := MemberInfo{
+			Name: m.Name,
+			Path: path + "." + m.Name,
+			Type: plc._symbolTypeName(m.Type),
+			Struct: m.IsStruct(),
+		}
+		if m.Count > 0 {
+			info.Dimensions = _elementDims(byte(m.Type), []int{m.Count})
+		}
+		if m.IsStruct() {
+			sub, subMembers, err := plc._inventoryMembers(info.Path, m.Type & 0x0FFF)
+			if err != nil {
+				return nil, nil, err
+			}
+			if sub != nil {
+				info.Type = sub.Name
+			}
+			info.Members = subMembers
+		}
+		members = append(members, info)
+	}
+	return tmpl, members, nil
+}
+
+func (plc *PLC)_symbolTypeName(symbolType uint16) string {
+	/*
+	Atomic types by name, structures are named after their template
+	by the caller once it's read. A BOOL array is held in DWORDs but
+	declared as BOOL
+	*/
+	if symbolType & 0x8000 > 0 {
+		return "STRUCT"
+	}
+	code := byte(symbolType)
+	if code == 0xD3 {
+		return "BOOL"
+	}
+	if t, ok := plc.CIPTypes[code]; ok {
+		return t.dataType
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
+
+func _elementDims(dataType byte, dims []int) []int {
+	//# dimensions as declared, BOOL arrays in bits
+	if len(dims) == 0 {
+		return nil
+	}
+	result := append([]int(nil), dims...)
+	if dataType == 0xD3 {
+		result[len(result)-1] *= 32
+	}
+	return result
+}
+
+func _writeInventoryCSV(w io.Writer, tags []TagInfo) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "type", "dimensions", "struct", "system", "access"})
+	var writeMembers func(tag TagInfo, members []MemberInfo)
+	writeMembers = func(tag TagInfo, members []MemberInfo) {
+		for _, m := range members {
+			cw.Write([]string{m.Path, m.Type, _dimsString(m.Dimensions), strconv.FormatBool(m.Struct),
+				strconv.FormatBool(tag.System), tag.Access})
+			writeMembers(tag, m.Members)
+		}
+	}
+	for _, t := range tags {
+		cw.Write([]string{t.Path, t.Type, _dimsString(t.Dimensions), strconv.FormatBool(t.Struct),
+			strconv.FormatBool(t.System), t.Access})
+		writeMembers(t, t.Members)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func _dimsString(dims []int) string {
+	//# the dimensions the way Logix lists them, 3,4 for DINT[3,4]
+	s := make([]string, len(dims))
+	for i, d := range dims {
+		s[i] = strconv.Itoa(d)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,219 @@
+package eip
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*
+Every encapsulation packet starts with the same 24 byte header
+(Vol 2 2-3.1), EIPLength tells how many bytes of command specific
+data follow it. TCP doesn't keep packet boundaries, so a reply can
+arrive in pieces or together with the next one, the length is the
+only way to know where it ends
+*/
+
+const encapHeaderSize = 24
+
+type Frame struct {
+	Command uint16
+	Length uint16
+	SessionHandle uint32
+	Status uint32
+	Context uint64
+	Options uint32
+	Data []byte //# command specific data, exactly Length bytes
+}
+
+type CPFItem struct {
+	TypeID uint16
+	Data []byte
+}
+
+func _parseFrameHeader(header []byte) Frame {
+	return Frame{
+		Command: binary.LittleEndian.Uint16(header[0:]),
+		Length: binary.LittleEndian.Uint16(header[2:]),
+		SessionHandle: binary.LittleEndian.Uint32(header[4:]),
+		Status: binary.LittleEndian.Uint32(header[8:]),
+		Context: binary.LittleEndian.Uint64(header[12:]),
+		Options: binary.LittleEndian.Uint32(header[20:]),
+	}
+}
+
+func (plc *PLC)_readFrame(command uint16) (*Frame, error) {
+	/*
+	Reads one whole encapsulation packet and checks it's the reply we
+	were waiting for
+	*/
+	frame, _, err := plc._nextFrame()
+	if err != nil {
+		return nil, plc._ioError("read", err)
+	}
+	if err := plc._checkFrame(frame, command); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (plc *PLC)_nextFrame() (*Frame, bool, error) {
+	/*
+	Reads the header, then exactly EIPLength bytes
+	idle is true when the read failed before any of it arrived, the
+	stream is still in step then and the socket can be read again
+	*/
+	header := make([]byte, encapHeaderSize)
+	if n, err := io.ReadFull(plc.Socket, header); err != nil {
+		return nil, n == 0, err
+	}
+	frame := _parseFrameHeader(header)
+	frame.Data = make([]byte, frame.Length)
+	if _, err := io.ReadFull(plc.Socket, frame.Data); err != nil {
+		return nil, false, err
+	}
+	plc._log().Debugf("%s: received %v", plc._logContext(), packetSummary(append(header, frame.Data...)))
+	return &frame, false, nil
+}
+
+func (plc *PLC)_checkFrame(frame *Frame, command uint16) error {
+	//# past this point the stream is out of step or the session is gone
+	if frame.Command != command {
+		err := fmt.Errorf("eip: expected reply to command 0x%02X, got 0x%02X", command, frame.Command)
+		plc._sessionLost(err)
+		return err
+	}
+	if frame.Status != 0 {
+		err := &EncapError{Command: frame.Command, Status: frame.Status}
+		if frame.Status == 0x64 || frame.Status == 0x65 {
+			plc._sessionLost(err)
+		}
+		return err
+	}
+	//# List Identity is sessionless and Register Session is where the handle comes from
+	if command != 0x63 && command != 0x65 && frame.SessionHandle != plc.SessionHandle {
+		err := fmt.Errorf("eip: reply for session 0x%08X, expected 0x%08X", frame.SessionHandle, plc.SessionHandle)
+		plc._sessionLost(err)
+		return err
+	}
+	return nil
+}
+
+func (plc *PLC)_transact(packet []byte) (*Frame, error) {
+	/*
+	Sends an encapsulation packet and waits for its reply, for no
+	longer than Timeout or the deadline of the call's context
+	*/
+	if len(packet) < encapHeaderSize {
+		return nil, fmt.Errorf("eip: packet too short (%d bytes)", len(packet))
+	}
+	ctx := plc._context()
+	if err := ctx.Err(); err != nil {
+		return nil, _contextError("send", err)
+	}
+	plc.Socket.SetDeadline(plc._deadline())
+	stop := _watchContext(ctx, plc.Socket)
+	defer stop()
+
+	plc._log().Debugf("%s: sent %v", plc._logContext(), packetSummary(packet))
+	if _, err := plc.Socket.Write(packet); err != nil {
+		return nil, plc._ioError("write", err)
+	}
+	return plc._readFrame(binary.LittleEndian.Uint16(packet))
+}
+
+func (f *Frame) Items() ([]CPFItem, error) {
+	/*
+	Splits the common packet format (Vol 2 2-6) used by SendRRData
+	and SendUnitData: interface handle(4) timeout(2) item count(2)
+	then type/length/data for every item
+	*/
+	if len(f.Data) < 8 {
+		return nil, fmt.Errorf("eip: command 0x%02X: reply too short (%d bytes)", f.Command, len(f.Data))
+	}
+	count := int(binary.LittleEndian.Uint16(f.Data[6:]))
+	items := make([]CPFItem, 0, count)
+	offset := 8
+	for i := 0; i < count; i++ {
+		if offset+4 > len(f.Data) {
+			return nil, fmt.Errorf("eip: command 0x%02X: truncated item %d", f.Command, i)
+		}
+		typeID := binary.LittleEndian.Uint16(f.Data[offset:])
+		length := int(binary.LittleEndian.Uint16(f.Data[offset+2:]))
+		offset += 4
+		if offset+length > len(f.Data) {
+			return nil, fmt.Errorf("eip: command 0x%02X: truncated item %d", f.Command, i)
+		}
+		items = append(items, CPFItem{TypeID: typeID, Data: f.Data[offset:offset+length]})
+		offset += length
+	}
+	return items, nil
+}
+
+func (f *Frame) Sequence() (uint16, bool) {
+	/*
+	The sequence count of a connected data item, false when there
+	isn't one
+	*/
+	items, err := f.Items()
+	if err != nil {
+		return 0, false
+	}
+	for _, item := range items {
+		if item.TypeID == 0xB1 && len(item.Data) >= 2 {
+			return binary.LittleEndian.Uint16(item.Data), true
+		}
+	}
+	return 0, false
+}
+
+func (f *Frame) CIPReply() ([]byte, error) {
+	/*
+	Returns the CIP reply in a SendRRData or SendUnitData frame,
+	starting at the reply service
+	Connected data items carry the sequence count in front of it
+	*/
+	items, err := f.Items()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		switch item.TypeID {
+		case 0xB2: //# unconnected data item
+			return item.Data, nil
+		case 0xB1: //# connected data item
+			if len(item.Data) < 2 {
+				return nil, fmt.Errorf("eip: connected data item too short (%d bytes)", len(item.Data))
+			}
+			return item.Data[2:], nil
+		}
+	}
+	return nil, fmt.Errorf("eip: command 0x%02X: reply has no data item", f.Command)
+}
+
+func (plc *PLC)_ioError(op string, err error) error {
+	/*
+	Whatever was in flight is lost, a late reply would be taken for
+	the answer to the next request, so the connection has to go
+	*/
+	plc._sessionLost(err)
+	ctx := plc._context()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return _contextError(op, ctxErr)
+	}
+	//# the socket deadline can go off a moment before the context's timer
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return _contextError(op, context.DeadlineExceeded)
+	}
+	return _ioError(op, err)
+}
+
+func (plc *PLC)_dropConnection() {
+	if plc.Socket != nil {
+		plc.Socket.Close()
+	}
+	plc.SocketConnected = false
+	plc.ForwardOpened = false
+}
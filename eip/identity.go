@@ -0,0 +1,342 @@
+package eip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Identity struct {
+	VendorID uint16 `json:"vendor_id"`
+	DeviceType uint16 `json:"device_type"` //product type, 0x0E for controllers
+	ProductCode uint16 `json:"product_code"`
+	Revision string `json:"revision"`
+	Status uint16 `json:"status"`
+	SerialNumber uint32 `json:"serial_number"`
+	ProductName string `json:"product_name"`
+	State byte `json:"state"`
+	IPAddress string `json:"ip_address,omitempty"` //# List Identity only
+}
+
+type ListIdentity struct {
+	EIPCommand uint16 //#(H)List Identity Command (Vol 2 2-4.2)
+	EIPLength uint16 //#(H)Always 0x00, no payload
+	EIPSessionHandle uint32 //#(I)Not needed for List Identity
+	EIPStatus uint32 //#(I)Status always 0x00
+	EIPContext uint64 //#(Q)
+	EIPOptions uint32 //#(I)Options always 0x00
+}
+
+type Module struct {
+	Slot int `json:"slot"`
+	Identity
+}
+
+const deviceTypePLC = 0x0E
+const maxSlots = 17 //# the largest ControlLogix chassis
+const defaultDiscoverWait = 2*time.Second
+
+func (id Identity) IsMicro800() bool {
+	/*
+	Micro800 catalog numbers all start with 2080
+	*/
+	return id.DeviceType == deviceTypePLC && strings.HasPrefix(id.ProductName, "2080")
+}
+
+func (plc *PLC)_buildListIdentity() []byte {
+	li := ListIdentity{
+		EIPCommand: 0x63,
+		EIPLength: 0x00,
+		EIPSessionHandle: 0x00,
+		EIPStatus: 0x00,
+		EIPContext: plc.Context,
+		EIPOptions: 0x00,
+	}
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, li); err != nil {
+		plc._log().Errorf("%s: %v", plc._logContext(), err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func _parseIdentity(data []byte) (Identity, bool) {
+	/*
+	Parses the data of a List Identity reply
+	item count(2), then the identity item (Vol 2 2-4.2.3):
+		item type(2) item length(2) protocol version(2) socket address(16)
+		vendor(2) device type(2) product code(2) revision(2) status(2)
+		serial(4) name length(1) name state(1)
+	*/
+	var id Identity
+	if len(data) < 2+4+33 {
+		return id, false
+	}
+	item := data[2:]
+	if binary.LittleEndian.Uint16(item[0:]) != 0x0C {
+		return id, false
+	}
+	item = item[4:]
+	id, ok := _parseIdentityAttributes(item[18:])
+	if !ok {
+		return id, false
+	}
+	//# socket address is big endian
+	id.IPAddress = net.IP(item[6:10]).String()
+	nameLen := int(item[32])
+	if len(item) > 33+nameLen {
+		id.State = item[33+nameLen]
+	}
+	return id, true
+}
+
+func _parseIdentityAttributes(data []byte) (Identity, bool) {
+	/*
+	The attributes of the Identity Object (Vol 1 5-2.2) the way both
+	List Identity and Get Attributes All have them:
+		vendor(2) device type(2) product code(2) revision(2) status(2)
+		serial(4) name length(1) name
+	*/
+	var id Identity
+	if len(data) < 15 {
+		return id, false
+	}
+	id.VendorID = binary.LittleEndian.Uint16(data[0:])
+	id.DeviceType = binary.LittleEndian.Uint16(data[2:])
+	id.ProductCode = binary.LittleEndian.Uint16(data[4:])
+	id.Revision = fmt.Sprintf("%d.%03d", data[6], data[7])
+	id.Status = binary.LittleEndian.Uint16(data[8:])
+	id.SerialNumber = binary.LittleEndian.Uint32(data[10:])
+	nameLen := int(data[14])
+	if len(data) < 15+nameLen {
+		return id, false
+	}
+	id.ProductName = string(data[15:15+nameLen])
+	return id, true
+}
+
+func (plc *PLC)_getIdentity() (Identity, error) {
+	/*
+	Asks the module we're connected to who it is
+	*/
+	frame, err := plc._transact(plc._buildListIdentity())
+	if err != nil {
+		return Identity{}, err
+	}
+	id, ok := _parseIdentity(frame.Data)
+	if !ok {
+		return id, fmt.Errorf("eip: invalid List Identity reply (%d bytes)", len(frame.Data))
+	}
+	return id, nil
+}
+
+func (plc *PLC)_controllerIdentity() (Identity, error) {
+	/*
+	Get Attributes All of the Identity Object at the end of the route,
+	which is the controller even when List Identity answers for the
+	communication module in front of it
+	*/
+	retData, err := plc._request([]byte{0x01, 0x02, 0x20, 0x01, 0x24, 0x01})
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := _replyError(retData, 0x01, "controller"); err != nil {
+		return Identity{}, err
+	}
+	id, ok := _parseIdentityAttributes(_replyData(retData))
+	if !ok {
+		return id, fmt.Errorf("eip: controller: invalid identity (%d bytes)", len(_replyData(retData)))
+	}
+	return id, nil
+}
+
+func (plc *PLC)GetIdentity() (Identity, error) {
+	return plc.GetIdentityContext(context.Background())
+}
+
+func (plc *PLC)GetIdentityContext(ctx context.Context) (Identity, error) {
+	/*
+	Asks again rather than returning what the connection found, the
+	state and status change
+	*/
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return Identity{}, err
+	}
+	return plc._getIdentity()
+}
+
+func (plc *PLC)GetModuleProperties(slot int) (Identity, error) {
+	return plc.GetModulePropertiesContext(context.Background(), slot)
+}
+
+func (plc *PLC)GetModulePropertiesContext(ctx context.Context, slot int) (Identity, error) {
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return Identity{}, err
+	}
+	return plc._getModuleProperties(slot)
+}
+
+func (plc *PLC)GetModules() ([]Module, error) {
+	return plc.GetModulesContext(context.Background())
+}
+
+func (plc *PLC)GetModulesContext(ctx context.Context) ([]Module, error) {
+	/*
+	Every module in the controller's chassis, slots that don't answer
+	are taken to be empty
+	*/
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return nil, err
+	}
+	var modules []Module
+	for slot := 0; slot < maxSlots; slot++ {
+		id, err := plc._getModuleProperties(slot)
+		var cipErr *CIPError
+		if errors.As(err, &cipErr) {
+			plc._log().Debugf("%s: slot %d: %v", plc._logContext(), slot, err)
+			continue
+		}
+		if err != nil {
+			return modules, err
+		}
+		modules = append(modules, Module{Slot: slot, Identity: id})
+	}
+	return modules, nil
+}
+
+func (plc *PLC)_moduleRoute(slot int) ([]byte, error) {
+	/*
+	The route to the controller with its slot swapped for the one
+	asked for, so the modules are those of the controller's chassis
+	*/
+	if plc.Micro800 {
+		return nil, fmt.Errorf("eip: Micro800 has no backplane")
+	}
+	if slot < 0 || slot > 0xFF {
+		return nil, fmt.Errorf("eip: invalid slot %d", slot)
+	}
+	route := plc.Route
+	if len(strings.TrimSpace(route)) == 0 && plc.Protocol != "pccc" {
+		route = "1," + strconv.Itoa(int(plc.ProcessorSlot))
+	}
+	hops, err := ParseRoute(route)
+	if err != nil {
+		return nil, err
+	}
+	if len(hops) > 0 && hops[len(hops)-1].Port == 1 {
+		hops = hops[:len(hops)-1]
+	}
+	hops = append(hops, RouteHop{Port: 1, Link: strconv.Itoa(slot)})
+	return EncodeRoute(hops), nil
+}
+
+func (plc *PLC)_getModuleProperties(slot int) (Identity, error) {
+	/*
+	Get Attributes All of the module's Identity Object, always
+	unconnected since the connection we have goes to the controller
+	A connection failure here is about the slot, not our session
+	*/
+	routePath, err := plc._moduleRoute(slot)
+	if err != nil {
+		return Identity{}, err
+	}
+	message, err := plc._unconnectedSendTo([]byte{0x01, 0x02, 0x20, 0x01, 0x24, 0x01}, routePath)
+	if err != nil {
+		return Identity{}, err
+	}
+	frame, err := plc._transact(append(plc._buildEIPSendRRDataHeader(message), message...))
+	if err != nil {
+		return Identity{}, err
+	}
+	reply, err := frame.CIPReply()
+	if err != nil {
+		return Identity{}, err
+	}
+	path := fmt.Sprintf("slot %d", slot)
+	if err := _replyError(reply, 0x01, path); err != nil {
+		return Identity{}, err
+	}
+	id, ok := _parseIdentityAttributes(_replyData(reply))
+	if !ok {
+		return id, fmt.Errorf("eip: %s: invalid identity (%d bytes)", path, len(_replyData(reply)))
+	}
+	return id, nil
+}
+
+func Discover(address string, wait time.Duration) ([]Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return DiscoverContext(ctx, address)
+}
+
+func DiscoverContext(ctx context.Context, address string) ([]Identity, error) {
+	/*
+	Sends List Identity over UDP and collects the replies until ctx is
+	done, defaultDiscoverWait when it has no deadline. address is where
+	to send it: empty for the limited broadcast, a subnet's broadcast
+	address, or one host, the port defaults to 44818
+	Devices answer once each, whatever answered by then is the result
+	*/
+	if len(address) == 0 {
+		address = "255.255.255.255"
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "44818")
+	}
+	to, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("eip: discover: %w", err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDiscoverWait)
+		defer cancel()
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("eip: discover: %w", err)
+	}
+	defer conn.Close()
+	defer _watchContext(ctx, conn)()
+
+	if _, err := conn.WriteTo((&PLC{})._buildListIdentity(), to); err != nil {
+		return nil, fmt.Errorf("eip: discover: %w", err)
+	}
+	var found []Identity
+	seen := make(map[string]bool)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return found, nil
+			}
+			return found, fmt.Errorf("eip: discover: %w", err)
+		}
+		if n < encapHeaderSize || seen[from.String()] {
+			continue
+		}
+		frame := _parseFrameHeader(buf[:encapHeaderSize])
+		if frame.Command != 0x63 || frame.Status != 0 || encapHeaderSize+int(frame.Length) > n {
+			continue
+		}
+		id, ok := _parseIdentity(buf[encapHeaderSize:encapHeaderSize+int(frame.Length)])
+		if !ok {
+			continue
+		}
+		if addr, ok := from.(*net.UDPAddr); ok && (id.IPAddress == "0.0.0.0" || len(id.IPAddress) == 0) {
+			id.IPAddress = addr.IP.String()
+		}
+		seen[from.String()] = true
+		found = append(found, id)
+	}
+}
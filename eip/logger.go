@@ -0,0 +1,140 @@
+package eip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+Telegraf hands the plugin its logger through the Log field, so messages
+land wherever the agent is configured to log and honour --debug. The
+standalone client sets Logger instead, to anything with the four
+methods of Logger, which every telegraf.Logger has whatever else a
+Telegraf release adds to it. StdLogger adapts the standard library
+logger. Without either the client stays quiet
+Messages start with key=value pairs for the controller and, where it
+applies, the tag, service and CIP status so they can be grepped
+*/
+
+type Logger interface {
+	Errorf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarn
+	LogInfo
+	LogDebug
+)
+
+type StdLogger struct {
+	Logger *log.Logger //# log.Default() when nil
+	Level LogLevel
+}
+
+func NewStdLogger(logger *log.Logger, level LogLevel) *StdLogger {
+	return &StdLogger{Logger: logger, Level: level}
+}
+
+func (l *StdLogger) _output(level LogLevel, prefix string, msg string) {
+	if level > l.Level {
+		return
+	}
+	logger := l.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Output(3, prefix + msg)
+}
+
+func (l *StdLogger) Errorf(format string, args ...interface{}) { l._output(LogError, "E! ", fmt.Sprintf(format, args...)) }
+func (l *StdLogger) Error(args ...interface{}) { l._output(LogError, "E! ", fmt.Sprint(args...)) }
+func (l *StdLogger) Warnf(format string, args ...interface{}) { l._output(LogWarn, "W! ", fmt.Sprintf(format, args...)) }
+func (l *StdLogger) Warn(args ...interface{}) { l._output(LogWarn, "W! ", fmt.Sprint(args...)) }
+func (l *StdLogger) Infof(format string, args ...interface{}) { l._output(LogInfo, "I! ", fmt.Sprintf(format, args...)) }
+func (l *StdLogger) Info(args ...interface{}) { l._output(LogInfo, "I! ", fmt.Sprint(args...)) }
+func (l *StdLogger) Debugf(format string, args ...interface{}) { l._output(LogDebug, "D! ", fmt.Sprintf(format, args...)) }
+func (l *StdLogger) Debug(args ...interface{}) { l._output(LogDebug, "D! ", fmt.Sprint(args...)) }
+
+type nopLogger struct{}
+
+func (nopLogger) Errorf(string, ...interface{}) {}
+func (nopLogger) Warnf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{}) {}
+func (nopLogger) Debugf(string, ...interface{}) {}
+
+func (plc *PLC)_log() Logger {
+	switch {
+	case plc.Log != nil:
+		return plc.Log
+	case plc.Logger != nil:
+		return plc.Logger
+	}
+	return nopLogger{}
+}
+
+func (plc *PLC)_logContext(pairs ...interface{}) string {
+	/*
+	controller=... route=... followed by the extra key value pairs
+	*/
+	var b strings.Builder
+	fmt.Fprintf(&b, "controller=%s", plc.IPAddress)
+	if len(plc.Route) > 0 {
+		fmt.Fprintf(&b, " route=%s", plc.Route)
+	} else if !plc.Micro800 {
+		fmt.Fprintf(&b, " slot=%d", plc.ProcessorSlot)
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", pairs[i], pairs[i+1])
+	}
+	return b.String()
+}
+
+func (plc *PLC)_logTagError(tag string, err error) {
+	pairs := []interface{}{"tag", tag}
+	var cipErr *CIPError
+	if errors.As(err, &cipErr) {
+		pairs = append(pairs, "service", fmt.Sprintf("0x%02X", cipErr.Service), "status", fmt.Sprintf("0x%02X", cipErr.Status))
+	}
+	plc._log().Debugf("%s: %v", plc._logContext(pairs...), err)
+}
+
+type packetSummary []byte
+
+func (p packetSummary) String() string {
+	/*
+	One line for a packet at debug level: the encapsulation command,
+	session and length, then the CIP service and status when there is
+	a CIP message inside
+	*/
+	if len(p) < encapHeaderSize {
+		return fmt.Sprintf("short packet (%d bytes)", len(p))
+	}
+	frame := _parseFrameHeader(p)
+	frame.Data = p[encapHeaderSize:]
+	var b strings.Builder
+	fmt.Fprintf(&b, "command=0x%02X session=0x%08X length=%d", frame.Command, frame.SessionHandle, frame.Length)
+	if frame.Status != 0 {
+		fmt.Fprintf(&b, " encap_status=0x%02X", frame.Status)
+	}
+	if frame.Command != 0x6F && frame.Command != 0x70 {
+		return b.String()
+	}
+	cip, err := frame.CIPReply()
+	if err != nil || len(cip) == 0 {
+		return b.String()
+	}
+	if cip[0] & 0x80 == 0 {
+		fmt.Fprintf(&b, " service=0x%02X", cip[0])
+	} else if len(cip) >= 3 {
+		fmt.Fprintf(&b, " service=0x%02X status=0x%02X", cip[0] & 0x7F, cip[2])
+	}
+	return b.String()
+}
@@ -0,0 +1,544 @@
+package eip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+PCCC is the command set of the SLC 500, PLC-5 and MicroLogix families
+It is carried over CIP with the Execute PCCC service of the PCCC object (class 0x67)
+and addresses data table files (N7:0, F8:10, B3:0/5, T4:2.ACC, ST9:1) instead of tags
+*/
+
+type PCCC struct {
+	PLC *PLC
+	TransactionNumber uint16
+}
+
+type PCCCAddress struct {
+	Address string
+	FileType string
+	FileNumber uint16
+	Element uint16
+	SubElement uint16
+	Bit int //# -1 when the whole word is addressed
+}
+
+type pcccFileType struct {
+	code byte
+	size int //# bytes per element
+}
+
+var pcccFileTypes = map[string]pcccFileType{
+	"O": {code: 0x8B, size: 2},
+	"I": {code: 0x8C, size: 2},
+	"S": {code: 0x84, size: 2},
+	"B": {code: 0x85, size: 2},
+	"T": {code: 0x86, size: 6},
+	"C": {code: 0x87, size: 6},
+	"R": {code: 0x88, size: 6},
+	"N": {code: 0x89, size: 2},
+	"F": {code: 0x8A, size: 4},
+	"ST": {code: 0x8D, size: 84},
+	"A": {code: 0x8E, size: 2},
+	"L": {code: 0x91, size: 4},
+}
+
+//# O, I and S always live in the same files, so the number can be left out
+var pcccDefaultFiles = map[string]uint16{
+	"O": 0,
+	"I": 1,
+	"S": 2,
+}
+
+//# sub-elements of timers, counters and control words are either words or bits of the first word
+var pcccSubElements = map[string]map[string]uint16{
+	"T": {"PRE": 1, "ACC": 2},
+	"C": {"PRE": 1, "ACC": 2},
+	"R": {"LEN": 1, "POS": 2},
+}
+
+var pcccStatusBits = map[string]map[string]int{
+	"T": {"EN": 15, "TT": 14, "DN": 13},
+	"C": {"CU": 15, "CD": 14, "DN": 13, "OV": 12, "UN": 11, "UA": 10},
+	"R": {"EN": 15, "EU": 14, "DN": 13, "EM": 12, "ER": 11, "UL": 10, "IN": 9, "FD": 8},
+}
+
+var pcccErrorCodes = map[byte]string{
+	0x10: "Illegal command or format",
+	0x20: "Host has a problem and will not communicate",
+	0x30: "Remote node host is missing, disconnected, or shut down",
+	0x40: "Host could not complete function due to hardware fault",
+	0x50: "Addressing problem or memory protect rungs",
+	0x60: "Function not allowed due to command protection selection",
+	0x70: "Processor is in Program mode",
+	0x80: "Compatibility mode file missing or communication zone problem",
+	0x90: "Remote node cannot buffer command",
+	0xA0: "Wait ACK (1775-KA buffer full)",
+	0xB0: "Remote node problem due to download",
+	0xC0: "Wait ACK (1775-KA buffer full)",
+}
+
+var pcccExtErrorCodes = map[byte]string{
+	0x01: "A field has an illegal value",
+	0x02: "Less levels specified in address than minimum for any address",
+	0x03: "More levels specified in address than system supports",
+	0x04: "Symbol not found",
+	0x05: "Symbol is of improper format",
+	0x06: "Address doesn't point to something usable",
+	0x07: "File is wrong size",
+	0x08: "Cannot complete request, situation has changed since the start of the command",
+	0x09: "Data or file is too large",
+	0x0A: "Transaction size plus word address is too large",
+	0x0B: "Access denied, improper privilege",
+	0x0C: "Condition cannot be generated, resource is not available",
+	0x0D: "Condition already exists, resource is already available",
+	0x0E: "Command cannot be executed",
+	0x0F: "Histogram overflow",
+	0x10: "No access",
+	0x11: "Illegal data type",
+	0x12: "Invalid parameter or invalid data",
+	0x13: "Address reference exists to deleted area",
+	0x14: "Command execution failure for unknown reason",
+	0x15: "Data conversion error",
+	0x16: "Scanner not able to communicate with 1771 rack adapter",
+	0x17: "Type mismatch",
+	0x18: "1771 module response was not valid",
+	0x19: "Duplicated label",
+	0x1A: "File is open, another node owns it",
+	0x1B: "Another node is the program owner",
+	0x1E: "Data table element protection violation",
+	0x1F: "Temporary internal problem",
+	0x22: "Remote rack fault",
+	0x23: "Timeout",
+	0x24: "Unknown error",
+}
+
+//# SLC 5/05 replies carry at most 236 bytes of data
+const pcccMaxData = 236
+
+var pcccAddressPattern = regexp.MustCompile(`^([A-Z]+)(\d*)(?::(\d+))?(?:\.([A-Z]+))?(?:/(\d+))?$`)
+
+func ParsePCCCAddress(address string) (PCCCAddress, error) {
+	/*
+	Parses a data table address: file type, file number, element,
+	then an optional sub-element name and bit number
+		N7:0  F8:10  B3:0/5  B3/21  T4:2.ACC  C5:0.DN  ST9:1  O:0/3
+	*/
+	addr := PCCCAddress{Address: address, Bit: -1}
+	m := pcccAddressPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(address)))
+	if m == nil {
+		return addr, fmt.Errorf("pccc address %q: invalid format", address)
+	}
+	fileType, fileNumber, element, subElement, bit := m[1], m[2], m[3], m[4], m[5]
+
+	ft, ok := pcccFileTypes[fileType]
+	if !ok {
+		return addr, fmt.Errorf("pccc address %q: unknown file type %q", address, fileType)
+	}
+	addr.FileType = fileType
+
+	if len(fileNumber) > 0 {
+		n, err := strconv.ParseUint(fileNumber, 10, 16)
+		if err != nil {
+			return addr, fmt.Errorf("pccc address %q: invalid file number", address)
+		}
+		addr.FileNumber = uint16(n)
+	} else if n, ok := pcccDefaultFiles[fileType]; ok {
+		addr.FileNumber = n
+	} else {
+		return addr, fmt.Errorf("pccc address %q: missing file number", address)
+	}
+
+	if len(element) > 0 {
+		n, err := strconv.ParseUint(element, 10, 16)
+		if err != nil {
+			return addr, fmt.Errorf("pccc address %q: invalid element", address)
+		}
+		addr.Element = uint16(n)
+	} else if fileType != "B" || len(bit) == 0 {
+		//# only bit files can be addressed by bit number alone (B3/21)
+		return addr, fmt.Errorf("pccc address %q: missing element", address)
+	}
+
+	if len(bit) > 0 {
+		n, err := strconv.ParseUint(bit, 10, 16)
+		if err != nil {
+			return addr, fmt.Errorf("pccc address %q: invalid bit", address)
+		}
+		if len(element) == 0 {
+			addr.Element = uint16(n/16)
+			n = n%16
+		}
+		if n > 15 {
+			return addr, fmt.Errorf("pccc address %q: bit %d is out of range", address, n)
+		}
+		if ft.size != 2 {
+			return addr, fmt.Errorf("pccc address %q: bits can only be addressed in word files", address)
+		}
+		addr.Bit = int(n)
+	}
+
+	if len(subElement) > 0 {
+		if len(bit) > 0 {
+			return addr, fmt.Errorf("pccc address %q: sub-element and bit can't be combined", address)
+		}
+		if n, ok := pcccSubElements[fileType][subElement]; ok {
+			addr.SubElement = n
+		} else if n, ok := pcccStatusBits[fileType][subElement]; ok {
+			addr.Bit = n
+		} else {
+			return addr, fmt.Errorf("pccc address %q: unknown sub-element %q", address, subElement)
+		}
+	} else if _, ok := pcccSubElements[fileType]; ok {
+		return addr, fmt.Errorf("pccc address %q: %s files need a sub-element", address, fileType)
+	}
+
+	return addr, nil
+}
+
+func (addr PCCCAddress) _elementSize() int {
+	/*
+	Timer, counter and control sub-elements are single words
+	*/
+	if _, ok := pcccSubElements[addr.FileType]; ok {
+		return 2
+	}
+	return pcccFileTypes[addr.FileType].size
+}
+
+func _pcccAddressField(buf *bytes.Buffer, value uint16) {
+	//# values above 254 are flagged with 0xFF and follow as a word
+	if value < 255 {
+		buf.WriteByte(byte(value))
+	} else {
+		buf.WriteByte(0xFF)
+		binary.Write(buf, binary.LittleEndian, value)
+	}
+}
+
+func (addr PCCCAddress) _encode(element uint16, size int) []byte {
+	/*
+	Byte size followed by the three address fields of the
+	typed logical commands: file number, file type, element, sub-element
+	*/
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(size))
+	_pcccAddressField(buf, addr.FileNumber)
+	buf.WriteByte(pcccFileTypes[addr.FileType].code)
+	_pcccAddressField(buf, element)
+	_pcccAddressField(buf, addr.SubElement)
+	return buf.Bytes()
+}
+
+func (p *PCCC)_buildExecutePCCC(function byte, data []byte) []byte {
+	/*
+	Execute PCCC (0x4B) to the PCCC object, the requestor ID
+	identifies us to the controller
+	*/
+	p.TransactionNumber += 1
+	buf := new(bytes.Buffer)
+
+	buf.Write([]byte{0x4B, 0x02, 0x20, 0x67, 0x24, 0x01})
+	buf.WriteByte(0x07) //# requestor ID length
+	binary.Write(buf, binary.LittleEndian, p.PLC.VendorID)
+	binary.Write(buf, binary.LittleEndian, uint32(p.PLC.OriginatorSerialNumber))
+
+	buf.WriteByte(0x0F) //# CMD
+	buf.WriteByte(0x00) //# STS
+	binary.Write(buf, binary.LittleEndian, p.TransactionNumber)
+	buf.WriteByte(function)
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+func (p *PCCC)_execute(address string, function byte, data []byte) ([]byte, error) {
+	/*
+	Sends a PCCC command and returns the reply data after CMD/STS/TNS
+	*/
+	if err := p.PLC._connect(); err != nil {
+		return nil, err
+	}
+
+	retData, err := p.PLC._request(p._buildExecutePCCC(function, data))
+	if err != nil {
+		return nil, err
+	}
+	if err := _replyError(retData, 0x67, address); err != nil {
+		return nil, err
+	}
+
+	//# skip the requestor ID that comes back in front of the reply
+	body := _replyData(retData)
+	if len(body) < 1 || len(body) < int(body[0])+4 {
+		return nil, &CIPError{Service: 0x4B, Class: 0x67, Path: address, Status: 0x13}
+	}
+	pccc := body[int(body[0]):]
+	sts := pccc[1]
+	if sts == 0xF0 && len(pccc) > 4 {
+		return nil, &PCCCError{Address: address, Status: sts, ExtStatus: pccc[4]}
+	}
+	if sts != 0 {
+		return nil, &PCCCError{Address: address, Status: sts}
+	}
+	return pccc[4:], nil
+}
+
+func (p *PCCC)_readWords(addr PCCCAddress, elements int) ([]byte, error) {
+	/*
+	Protected typed logical read with three address fields (0xA2),
+	split into several requests when it doesn't fit a single reply
+	*/
+	var data []byte
+	size := addr._elementSize()
+	perRequest := pcccMaxData / size
+
+	for done := 0; done < elements; {
+		count := elements-done
+		if count > perRequest {
+			count = perRequest
+		}
+		reply, err := p._execute(addr.Address, 0xA2, addr._encode(addr.Element+uint16(done), count*size))
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) < count*size {
+			return nil, &CIPError{Service: 0x4B, Class: 0x67, Path: addr.Address, Status: 0x13}
+		}
+		data = append(data, reply[:count*size]...)
+		done += count
+	}
+	return data, nil
+}
+
+func (addr PCCCAddress) _decode(data []byte, elements int) []interface{} {
+	var vals []interface{}
+	size := addr._elementSize()
+
+	for i := 0; i < elements; i++ {
+		element := data[i*size:]
+		if addr.Bit >= 0 {
+			vals = append(vals, BitValue(binary.LittleEndian.Uint16(element), uint16(addr.Bit)))
+			continue
+		}
+		switch {
+		case addr.FileType == "F":
+			vals = append(vals, math.Float32frombits(binary.LittleEndian.Uint32(element)))
+		case addr.FileType == "L":
+			vals = append(vals, int32(binary.LittleEndian.Uint32(element)))
+		case addr.FileType == "ST":
+			vals = append(vals, _pcccString(element[:size]))
+		default:
+			vals = append(vals, int16(binary.LittleEndian.Uint16(element)))
+		}
+	}
+	return vals
+}
+
+func _pcccString(data []byte) string {
+	/*
+	ST elements are a length word and 82 characters,
+	stored with the bytes of every word swapped
+	*/
+	length := int(binary.LittleEndian.Uint16(data))
+	if length > len(data)-2 {
+		length = len(data)-2
+	}
+	chars := make([]byte, len(data)-2)
+	for i := 2; i+1 < len(data); i += 2 {
+		chars[i-2] = data[i+1]
+		chars[i-1] = data[i]
+	}
+	return string(chars[:length])
+}
+
+func _pcccStringBytes(value string) []byte {
+	chars := make([]byte, 82)
+	copy(chars, value)
+	buf := new(bytes.Buffer)
+	length := len(value)
+	if length > 82 {
+		length = 82
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(length))
+	for i := 0; i < len(chars); i += 2 {
+		buf.WriteByte(chars[i+1])
+		buf.WriteByte(chars[i])
+	}
+	return buf.Bytes()
+}
+
+func (p *PCCC)_readAddress(address string, elements int) ([]interface{}, error) {
+	addr, err := ParsePCCCAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if elements < 1 {
+		elements = 1
+	}
+	if addr.Bit >= 0 && elements > 1 {
+		return nil, fmt.Errorf("pccc address %q: bits can only be read one at a time", address)
+	}
+	data, err := p._readWords(addr, elements)
+	if err != nil {
+		return nil, err
+	}
+	return addr._decode(data, elements), nil
+}
+
+func (p *PCCC)Read(address string, elements int) ([]interface{}, error) {
+	/*
+	Reads elements starting at a data table address
+	*/
+	return p.ReadContext(context.Background(), address, elements)
+}
+
+func (p *PCCC)ReadContext(ctx context.Context, address string, elements int) ([]interface{}, error) {
+	defer p.PLC._withContext(ctx)()
+	return p._readAddress(address, elements)
+}
+
+func (p *PCCC)MultiRead(addresses []string) ([]interface{}, error) {
+	/*
+	Reads one element of each address, in order
+	Like PLC.MultiRead an address that fails has its error in place of the value,
+	losing the connection fails the whole read
+	*/
+	return p.MultiReadContext(context.Background(), addresses)
+}
+
+func (p *PCCC)MultiReadContext(ctx context.Context, addresses []string) ([]interface{}, error) {
+	defer p.PLC._withContext(ctx)()
+	var result []interface{}
+	for _, address := range addresses {
+		vals, err := p._readAddress(address, 1)
+		if errors.Is(err, ErrConnectionLost) || errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, _contextError("pccc multi read", ctx.Err())
+		}
+		if err != nil {
+			result = append(result, err)
+		} else {
+			result = append(result, vals[0])
+		}
+	}
+	return result, nil
+}
+
+func (p *PCCC)Write(address string, value interface{}) error {
+	/*
+	Writes one element with the protected typed logical write (0xAA),
+	bits use the masked write (0xAB) so the rest of the word is kept
+	*/
+	return p.WriteContext(context.Background(), address, value)
+}
+
+func (p *PCCC)WriteContext(ctx context.Context, address string, value interface{}) error {
+	defer p.PLC._withContext(ctx)()
+	addr, err := ParsePCCCAddress(address)
+	if err != nil {
+		return err
+	}
+
+	var request []byte
+	var function byte
+	if addr.Bit >= 0 {
+		on, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("pccc address %q: bit values must be bool, got %T", address, value)
+		}
+		mask := uint16(1) << uint(addr.Bit)
+		bits := uint16(0)
+		if on {
+			bits = mask
+		}
+		function = 0xAB
+		request = addr._encode(addr.Element, 2)
+		request = append(request, byte(mask), byte(mask>>8), byte(bits), byte(bits>>8))
+	} else {
+		data, err := addr._encodeValue(value)
+		if err != nil {
+			return err
+		}
+		function = 0xAA
+		request = append(addr._encode(addr.Element, len(data)), data...)
+	}
+
+	_, err = p._execute(address, function, request)
+	return err
+}
+
+func (addr PCCCAddress) _encodeValue(value interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	switch addr.FileType {
+	case "F":
+		f, ok := _toFloat64(value)
+		if !ok {
+			return nil, fmt.Errorf("pccc address %q: expected a number, got %T", addr.Address, value)
+		}
+		binary.Write(buf, binary.LittleEndian, math.Float32bits(float32(f)))
+	case "L":
+		n, ok := _toInt64(value)
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("pccc address %q: expected a 32 bit integer, got %v", addr.Address, value)
+		}
+		binary.Write(buf, binary.LittleEndian, int32(n))
+	case "ST":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("pccc address %q: expected a string, got %T", addr.Address, value)
+		}
+		buf.Write(_pcccStringBytes(s))
+	default:
+		n, ok := _toInt64(value)
+		if !ok || n < math.MinInt16 || n > math.MaxUint16 {
+			return nil, fmt.Errorf("pccc address %q: expected a 16 bit integer, got %v", addr.Address, value)
+		}
+		binary.Write(buf, binary.LittleEndian, uint16(n))
+	}
+	return buf.Bytes(), nil
+}
+
+func _toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func _toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	n, ok := _toInt64(value)
+	return float64(n), ok
+}
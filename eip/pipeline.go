@@ -0,0 +1,139 @@
+package eip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*
+Over a class 3 connection every SendUnitData carries a sequence count
+and the reply echoes it, so there's no need to wait for one reply
+before sending the next request. Up to Window requests go out back to
+back and the replies are matched up by their sequence count, in
+whatever order they come. Each request gets its own Timeout, a reply
+that turns up after its request gave up is recognized and thrown away
+instead of being taken for the answer to something else
+Unconnected messages have no sequence count and go one at a time
+*/
+
+const defaultWindow = 1
+
+func (plc *PLC)_window() int {
+	if plc.Window <= 0 {
+		return defaultWindow
+	}
+	return plc.Window
+}
+
+func (plc *PLC)_pipeline(requests [][]byte) ([][]byte, []error, error) {
+	/*
+	Sends the CIP requests and returns their replies in the same order
+	A request that timed out has its error in errs, the error returned
+	last means the whole lot failed
+	*/
+	replies := make([][]byte, len(requests))
+	errs := make([]error, len(requests))
+	if !plc.ForwardOpened {
+		for i, request := range requests {
+			reply, err := plc._request(request)
+			if err != nil {
+				return nil, nil, err
+			}
+			replies[i] = reply
+		}
+		return replies, errs, nil
+	}
+
+	ctx := plc._context()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, _contextError("send", err)
+	}
+	stop := _watchContext(ctx, plc.Socket)
+	defer stop()
+
+	inflight := make(map[uint16]int) //# sequence count -> request
+	deadlines := make([]time.Time, len(requests))
+	window := plc._window()
+	next, done, answered := 0, 0, 0
+	var timeoutErr error
+
+	for done < len(requests) {
+		for next < len(requests) && len(inflight) < window {
+			sequence := plc.SequenceCounter
+			packet := append(plc._buildEIPHeader(len(requests[next])), requests[next]...)
+			deadlines[next] = plc._deadline()
+			plc.Socket.SetWriteDeadline(deadlines[next])
+			plc._log().Debugf("%s: sent %v", plc._logContext("sequence", sequence), packetSummary(packet))
+			if _, err := plc.Socket.Write(packet); err != nil {
+				return nil, nil, plc._ioError("write", err)
+			}
+			inflight[sequence] = next
+			next++
+		}
+
+		earliest := time.Time{}
+		for _, i := range inflight {
+			if earliest.IsZero() || deadlines[i].Before(earliest) {
+				earliest = deadlines[i]
+			}
+		}
+		plc.Socket.SetReadDeadline(earliest)
+		//# a cancel that came in before the deadline was set would be lost
+		if err := ctx.Err(); err != nil {
+			return nil, nil, plc._ioError("read", err)
+		}
+
+		frame, idle, err := plc._nextFrame()
+		if err != nil {
+			var netErr net.Error
+			lapsed := ctx.Err() != nil
+			if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+				lapsed = true
+			}
+			if !idle || lapsed || !errors.As(err, &netErr) || !netErr.Timeout() {
+				return nil, nil, plc._ioError("read", err)
+			}
+			//# nothing came, give up on the requests that are due
+			timeoutErr = err
+			now := time.Now()
+			for sequence, i := range inflight {
+				if !now.Before(deadlines[i]) {
+					errs[i] = fmt.Errorf("%w: no reply to sequence %d", ErrTimeout, sequence)
+					delete(inflight, sequence)
+					done++
+				}
+			}
+			continue
+		}
+		if err := plc._checkFrame(frame, 0x70); err != nil {
+			return nil, nil, err
+		}
+		sequence, _ := frame.Sequence()
+		i, ok := inflight[sequence]
+		if !ok {
+			plc._log().Debugf("%s: discarding reply to a request that timed out", plc._logContext("sequence", sequence))
+			continue
+		}
+		reply, err := frame.CIPReply()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _connectionFailed(reply, true) {
+			err := _cipError(reply, 0, "connection")
+			plc._sessionLost(err)
+			return nil, nil, err
+		}
+		delete(inflight, sequence)
+		replies[i] = reply
+		done++
+		answered++
+	}
+
+	//# not a single reply, the connection is as good as dead
+	if answered == 0 && timeoutErr != nil {
+		return nil, nil, plc._ioError("read", timeoutErr)
+	}
+	return replies, errs, nil
+}
@@ -0,0 +1,428 @@
+package eip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+A MultiRead is planned before it's sent, tags that can share a read
+service do:
+	Temps[0] Temps[1] ... Temps[99]    one Read Tag of 100 elements
+	M1.Speed M1.Running M1.Counts[2]   one read of the whole of M1
+and every tag gets its value out of the shared reply, the same value
+it would have had read on its own. Runs of array elements need the
+element type, which comes from KnownTags or the tag list, or a read of
+the first element when neither has it. A structure is only read whole
+when the tag list has it, it fits in a reply and it's fewer bytes on
+the wire than its members one by one
+Bits, BOOL arrays, STRING arrays and the same tag asked for twice keep
+a read of their own
+A shared read that fails, say with an index past the end of the array,
+is planned again one tag per read, so only the tags that are really
+wrong fail
+*/
+
+//# a whole Multiple Service Packet reply, kept below the 500 byte connection size asked for in the Forward Open
+const maxReplySize = 480
+
+type plannedRead struct {
+	service []byte //# the read service, as it goes in a Multiple Service Packet
+	replySize int //# bytes the reply takes in the packet reply, its offset included
+	byInstance bool
+	shared bool //# reads more than the one tag
+	tmpl *Template //# the structure read whole, nil otherwise
+	targets []readTarget
+}
+
+type readTarget struct {
+	arg int //# index of the tag in the MultiRead
+	whole bool //# the reply is the tag's own
+	offset int //# where the value starts in the reply data, after the type
+	typ uint16 //# type of the value
+	bit int //# bit of the host SINT for BOOL members
+	str *Template //# STRING members
+}
+
+func (plc *PLC)_planReads(args []string, indexes []int, result []Response, useInstances bool, coalesce bool) []plannedRead {
+	/*
+	Plans the reads of args[i] for every i in indexes, in the order
+	the tags were asked for. Tags that can't be read fail in result
+	and aren't planned
+	*/
+	paths := make(map[int]*TagPath)
+	var order []int
+	for _, i := range indexes {
+		path, err := ParseTagPath(args[i])
+		if err == nil {
+			err = plc._checkSymbol(path, 1)
+		}
+		if err != nil {
+			result[i] = Response{TagName: args[i], Err: err}
+			continue
+		}
+		paths[i] = path
+		order = append(order, i)
+	}
+
+	var reads []plannedRead
+	planned := make(map[int]bool)
+	if coalesce {
+		reads = append(reads, plc._structReads(paths, order, planned, useInstances)...)
+		reads = append(reads, plc._arrayReads(paths, order, planned, useInstances)...)
+	}
+	for _, i := range order {
+		if planned[i] {
+			continue
+		}
+		tagIOI, byInstance := plc._planIOI(paths[i], useInstances)
+		reads = append(reads, plannedRead{
+			service: plc._addReadIOI(tagIOI, 1),
+			replySize: 2+4+plc._replyEstimate(paths[i]),
+			byInstance: byInstance,
+			targets: []readTarget{{arg: i, whole: true}},
+		})
+	}
+	sort.SliceStable(reads, func(a, b int) bool {
+		return reads[a].targets[0].arg < reads[b].targets[0].arg
+	})
+	return reads
+}
+
+func (plc *PLC)_planIOI(path *TagPath, useInstances bool) ([]byte, bool) {
+	if useInstances {
+		return plc._tagIOI(path, false)
+	}
+	return path._ioi(false), false
+}
+
+func (plc *PLC)_replyEstimate(path *TagPath) int {
+	/*
+	Bytes of data, type included, a one element read of path replies
+	with. Tags that were never read and aren't in the tag list are
+	taken for the largest atomic type
+	*/
+	if tm, ok := plc._lookupTag(path._base().String()); ok {
+		return 2+tm.dataLen
+	}
+	if symbol, ok := plc._symbol(path); ok && len(path.Segments) == 1 {
+		if symbol.IsStruct {
+			return 4+symbol.ElementSize
+		}
+		return 2+symbol.ElementSize
+	}
+	return 2+8
+}
+
+func (plc *PLC)_structReads(paths map[int]*TagPath, order []int, planned map[int]bool, useInstances bool) []plannedRead {
+	/*
+	Members of one structure, or of one element of a structure array,
+	read whole when that's fewer bytes than reading them one by one
+	*/
+	groups := make(map[string][]int)
+	roots := make(map[string]*TagPath)
+	var keys []string
+	for _, i := range order {
+		path := paths[i]
+		if len(path.Segments) < 2 || path.Bit >= 0 {
+			continue
+		}
+		symbol, ok := plc._symbol(path)
+		if !ok || !symbol.IsStruct || len(path.Segments[0].Indexes) != len(symbol.Dimensions) {
+			continue
+		}
+		root := &TagPath{Program: path.Program, Segments: path.Segments[:1], Bit: -1}
+		key := strings.ToUpper(root.String())
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			roots[key] = root
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	var reads []plannedRead
+	for _, key := range keys {
+		if len(groups[key]) < 2 {
+			continue
+		}
+		symbol, _ := plc._symbol(roots[key])
+		tmpl, err := plc._getTemplate(symbol.TemplateInstance)
+		if err != nil || 4+2+2+4+4+tmpl.Size > maxReplySize {
+			continue
+		}
+		tagIOI, byInstance := plc._planIOI(roots[key], useInstances)
+		read := plannedRead{
+			service: plc._addReadIOI(tagIOI, 1),
+			replySize: 2+4+4+tmpl.Size,
+			byInstance: byInstance,
+			shared: true,
+			tmpl: tmpl,
+		}
+		separate := 0
+		for _, i := range groups[key] {
+			target, size, ok := plc._memberTarget(tmpl, paths[i].Segments[1:])
+			if !ok {
+				continue
+			}
+			target.arg = i
+			read.targets = append(read.targets, target)
+			memberIOI, _ := plc._planIOI(paths[i], useInstances)
+			separate += 2+len(plc._addReadIOI(memberIOI, 1)) + 2+4+2+size
+		}
+		if len(read.targets) < 2 || 2+len(read.service)+read.replySize > separate {
+			continue
+		}
+		for _, t := range read.targets {
+			planned[t.arg] = true
+		}
+		reads = append(reads, read)
+	}
+	return reads
+}
+
+func (plc *PLC)_memberTarget(tmpl *Template, segments []PathSegment) (readTarget, int, bool) {
+	/*
+	Where a member is in the data of the structure and what it is
+	Returns its size too, 0 and false for members that can't be taken
+	out of the structure the way the controller would reply them
+	*/
+	var target readTarget
+	for k, s := range segments {
+		m, ok := tmpl.Member(s.Name)
+		if !ok || m.Hidden() || m.Type == 0xD3 {
+			return target, 0, false
+		}
+		target.offset += m.Offset
+		if m.Count > 0 {
+			if len(s.Indexes) != 1 || s.Indexes[0] >= m.Count {
+				return target, 0, false
+			}
+			size, err := plc._elementSize(m.Type)
+			if err != nil {
+				return target, 0, false
+			}
+			target.offset += s.Indexes[0]*size
+		} else if len(s.Indexes) > 0 {
+			return target, 0, false
+		}
+		last := k == len(segments)-1
+
+		if m.IsStruct() {
+			sub, err := plc._getTemplate(m.Type & 0x0FFF)
+			if err != nil {
+				return target, 0, false
+			}
+			if last {
+				//# a structure member only comes back as a value when it's a string
+				if !sub.IsString() {
+					return target, 0, false
+				}
+				target.typ, target.str = m.Type, sub
+				return target, 2+sub.Size, true
+			}
+			tmpl = sub
+			continue
+		}
+		cipType, ok := plc.CIPTypes[byte(m.Type)]
+		if !last || !ok || cipType.dataLen == 0 || m.Type > 0xFF {
+			return target, 0, false
+		}
+		target.typ, target.bit = m.Type, m.Bit
+		return target, cipType.dataLen, true
+	}
+	return target, 0, false
+}
+
+func (plc *PLC)_arrayReads(paths map[int]*TagPath, order []int, planned map[int]bool, useInstances bool) []plannedRead {
+	/*
+	Elements next to each other in one array, or one row of it, read
+	as a run of elements from the first of them, in as many reads as
+	it takes to fit them in replies
+	*/
+	groups := make(map[string][]int)
+	var keys []string
+	seen := make(map[string]bool)
+	for _, i := range order {
+		path := paths[i]
+		if planned[i] || path.Bit >= 0 || len(path.Segments[len(path.Segments)-1].Indexes) == 0 {
+			continue
+		}
+		//# an element asked for again is left to a read of its own
+		if name := strings.ToUpper(path.String()); seen[name] {
+			continue
+		} else {
+			seen[name] = true
+		}
+		key := strings.ToUpper(path._withLastIndex(0).String())
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	var reads []plannedRead
+	for _, key := range keys {
+		group := groups[key]
+		sort.SliceStable(group, func(a, b int) bool {
+			return paths[group[a]]._lastIndex() < paths[group[b]]._lastIndex()
+		})
+		first := paths[group[0]]._lastIndex()
+		if paths[group[len(group)-1]]._lastIndex() == first {
+			continue
+		}
+		dataType, size, ok := plc._elementType(paths[group[0]])
+		if !ok {
+			continue
+		}
+		maxElements := (maxReplySize-4-2-2-4-2)/size
+		if maxElements > 0xFFFF {
+			maxElements = 0xFFFF
+		}
+
+		//# a run ends at a gap or when its reply would be full
+		var run []int
+		flush := func() {
+			if len(run) < 2 {
+				run = nil
+				return
+			}
+			start := paths[run[0]]._lastIndex()
+			count := paths[run[len(run)-1]]._lastIndex() - start + 1
+			if count < 2 {
+				run = nil
+				return
+			}
+			tagIOI, byInstance := plc._planIOI(paths[run[0]], useInstances)
+			read := plannedRead{
+				service: plc._addReadIOI(tagIOI, uint16(count)),
+				replySize: 2+4+2+count*size,
+				byInstance: byInstance,
+				shared: true,
+			}
+			for _, i := range run {
+				read.targets = append(read.targets, readTarget{
+					arg: i,
+					offset: (paths[i]._lastIndex()-start)*size,
+					typ: uint16(dataType),
+				})
+				planned[i] = true
+			}
+			reads = append(reads, read)
+			run = nil
+		}
+		for _, i := range group {
+			index := paths[i]._lastIndex()
+			if len(run) > 0 {
+				prev := paths[run[len(run)-1]]._lastIndex()
+				if index > prev+1 || index-paths[run[0]]._lastIndex() >= maxElements {
+					flush()
+				}
+			}
+			run = append(run, i)
+		}
+		flush()
+	}
+	return reads
+}
+
+func (plc *PLC)_elementType(path *TagPath) (byte, int, bool) {
+	/*
+	The type of the elements of the array path is in, when it's one
+	that can be read as a run of elements
+	*/
+	base := path._base().String()
+	tm, ok := plc._lookupTag(base)
+	if !ok {
+		if symbol, found := plc._symbol(path); found && len(path.Segments) == 1 && !symbol.IsStruct {
+			tm, ok = TagMap{dataType: symbol.DataType, dataLen: symbol.ElementSize}, true
+		}
+	}
+	if !ok {
+		plc.Offset = 0
+		if err := plc._initialRead(path); err != nil {
+			return 0, 0, false
+		}
+		tm, ok = plc._lookupTag(base)
+	}
+	cipType := plc.CIPTypes[tm.dataType]
+	if !ok || cipType.dataLen == 0 || tm.dataType == 0xD3 {
+		return 0, 0, false
+	}
+	return tm.dataType, cipType.dataLen, true
+}
+
+func (plc *PLC)_scatter(read plannedRead, service []byte, args []string, result []Response) bool {
+	/*
+	Hands out the reply of one read to the tags read with it
+	Returns false when the read failed
+	*/
+	status := service[2]
+	if status != 0 || service[3] != 0 {
+		for _, t := range read.targets {
+			result[t.arg] = Response{TagName: args[t.arg], Status: status, Err: _cipError(service, 0x6B, args[t.arg])}
+		}
+		return false
+	}
+	ok := true
+	for _, t := range read.targets {
+		response := Response{TagName: args[t.arg]}
+		var value interface{}
+		var dataType byte
+		var err error
+		if t.whole {
+			value, dataType, err = plc._multiValue(service)
+		} else {
+			value, dataType, err = plc._sharedValue(read, t, _replyData(service))
+		}
+		if err != nil {
+			response.Err = fmt.Errorf("eip: %s: %w", args[t.arg], err)
+			ok = false
+		} else {
+			response.Value = value
+			response.CIPType = dataType
+			response.Elements = 1
+		}
+		result[t.arg] = response
+	}
+	return ok
+}
+
+func (plc *PLC)_sharedValue(read plannedRead, t readTarget, data []byte) (interface{}, byte, error) {
+	/*
+	Takes one value out of the data of a run of elements or of a whole
+	structure, which has to be the type it was planned for
+	*/
+	if read.tmpl != nil {
+		if len(data) < 4+read.tmpl.Size || data[0] != 0xA0 || data[1] != 0x02 ||
+			binary.LittleEndian.Uint16(data[2:]) != read.tmpl.Handle {
+			return nil, 0, fmt.Errorf("%w: reply doesn't match the %s template", ErrTypeMismatch, read.tmpl.Name)
+		}
+		data = data[4:]
+	} else {
+		if len(data) < 2 || uint16(data[0]) != t.typ {
+			return nil, 0, fmt.Errorf("%w: reply isn't a 0x%02X", ErrTypeMismatch, t.typ)
+		}
+		data = data[2:]
+	}
+
+	if t.str != nil {
+		var s string
+		if err := _decodeString("", t.str, data[t.offset:], reflect.ValueOf(&s).Elem()); err != nil {
+			return nil, 0, fmt.Errorf("reply too short")
+		}
+		return s, 0xA0, nil
+	}
+	cipType := plc.CIPTypes[byte(t.typ)]
+	if t.offset+cipType.dataLen > len(data) {
+		return nil, byte(t.typ), fmt.Errorf("reply too short")
+	}
+	if read.tmpl != nil && t.typ == 0xC1 {
+		//# BOOL members are bits of a hidden SINT
+		return data[t.offset] & (1 << uint(t.bit)) > 0, 0xC1, nil
+	}
+	value, _ := _decodeAtomic(cipType.format, data[t.offset:])
+	return value, byte(t.typ), nil
+}
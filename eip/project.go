@@ -0,0 +1,146 @@
+package eip
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+/*
+A download replaces the tags, their types, instances and structure
+definitions, and everything cached about them goes stale: reads fail,
+or worse, decode to the wrong type. The controller object (class 0xAC)
+has change detection attributes that move with every edit or download.
+They're read when the first session opens, every
+ChangeDetectionInterval during collections and when a lost session is
+recovered. When they moved the caches are flushed, the tag list read
+again if there was one, and a project_changed metric is emitted
+Controllers without them (Micro800) get their caches flushed on every
+reconnect, as there's no telling what came back
+*/
+
+const defaultChangeDetectionInterval = time.Minute
+
+type projectEvent struct {
+	at time.Time
+	detectedBy string //# poll, reconnect or symbol_instances
+}
+
+func (plc *PLC)_projectIdentity() (string, error) {
+	/*
+	Get Attribute List on the controller object, attributes 1-4 and 10
+	The raw reply is compared as a whole so the sizes of the
+	attributes don't matter
+	*/
+	request := []byte{0x03, 0x02, 0x20, 0xAC, 0x24, 0x01}
+	request = append(request, 0x05, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00, 0x0A, 0x00)
+	retData, err := plc._request(request)
+	if err != nil {
+		return "", err
+	}
+	if err := _replyError(retData, 0xAC, "change detection"); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(_replyData(retData)), nil
+}
+
+func (plc *PLC)_noChangeDetection(err error) {
+	//# the controller said no, as opposed to the request getting lost
+	var cipErr *CIPError
+	if errors.As(err, &cipErr) {
+		plc.changeDetection = false
+	}
+	plc._log().Debugf("%s: no change detection: %v", plc._logContext(), err)
+}
+
+func (plc *PLC)_changeDetectionInterval() time.Duration {
+	if plc.ChangeDetectionInterval > 0 {
+		return time.Duration(plc.ChangeDetectionInterval)
+	}
+	return defaultChangeDetectionInterval
+}
+
+func (plc *PLC)_checkProject(detectedBy string) {
+	/*
+	Compares the change detection attributes with the ones seen last,
+	the first look only remembers them
+	*/
+	plc.lastProjectCheck = time.Now()
+	if !plc.changeDetection {
+		if detectedBy == "reconnect" {
+			plc._clearCaches()
+		}
+		return
+	}
+	project, err := plc._projectIdentity()
+	if err != nil {
+		plc._noChangeDetection(err)
+		if detectedBy == "reconnect" {
+			plc._clearCaches()
+		}
+		return
+	}
+	plc.cacheMu.Lock()
+	previous := plc.project
+	plc.project = project
+	plc.cacheMu.Unlock()
+	switch {
+	case previous == project:
+		return
+	case len(previous) == 0:
+		//# nothing to compare with
+		if detectedBy == "reconnect" {
+			plc._clearCaches()
+		}
+		return
+	}
+	plc._projectChanged(detectedBy)
+}
+
+func (plc *PLC)_pollProject(ctx context.Context) {
+	/*
+	The periodic check, only over a session that's already open, a
+	new one gets checked when it opens
+	With symbol instances in use it's every collection: a download can
+	renumber them onto other tags, and a read by a stale instance would
+	come back with another tag's value rather than fail
+	*/
+	defer plc._withContext(ctx)()
+	if !plc.SocketConnected {
+		return
+	}
+	if time.Since(plc.lastProjectCheck) < plc._changeDetectionInterval() && !plc._instancesInUse() {
+		return
+	}
+	plc._checkProject("poll")
+}
+
+func (plc *PLC)_projectChanged(detectedBy string) {
+	/*
+	Flushes the caches, reads the tag list again when one was in use
+	and records the change for the next collection to report
+	*/
+	plc._log().Infof("%s: project changed, flushing the caches", plc._logContext("detected_by", detectedBy))
+	plc.cacheMu.RLock()
+	hadTagList := plc.TagList != nil
+	plc.cacheMu.RUnlock()
+	plc._clearCaches()
+	plc.projectEvents = append(plc.projectEvents, projectEvent{at: time.Now(), detectedBy: detectedBy})
+	if hadTagList || plc.SymbolInstances {
+		if _, err := plc._getTagList(); err != nil {
+			plc._log().Warnf("%s: reloading the tag list: %v", plc._logContext(), err)
+		}
+	}
+	plc.cacheMu.Lock()
+	plc.cacheDirty = true
+	plc.cacheMu.Unlock()
+}
+
+func (plc *PLC)_takeProjectEvents() []projectEvent {
+	plc.mu.Lock()
+	defer plc.mu.Unlock()
+	events := plc.projectEvents
+	plc.projectEvents = nil
+	return events
+}
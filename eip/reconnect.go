@@ -0,0 +1,149 @@
+package eip
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+/*
+A session dies when the controller reboots, the cable is pulled or the
+connection times out on the PLC side. Whatever notices it (an I/O error,
+a connection failure status, a reply that doesn't belong to us) drops
+the connection, and the next request opens a new one. Attempts that
+fail push the next one out, twice as far every time with some jitter
+so a rack of collectors doesn't hammer a controller in step
+*/
+
+const (
+	defaultReconnectMinDelay = 500*time.Millisecond
+	defaultReconnectMaxDelay = 30*time.Second
+)
+
+func (plc *PLC)_connect() error {
+	if plc.SocketConnected {
+		return nil
+	}
+	if err := plc._waitReconnect(); err != nil {
+		return err
+	}
+	recovering := plc.sessionLost
+	if err := plc._openSession(); err != nil {
+		plc.failures++
+		delay := plc._reconnectDelay()
+		plc.nextAttempt = time.Now().Add(delay)
+		plc._log().Warnf("%s: connecting failed, next attempt in %v: %v", plc._logContext("failures", plc.failures), delay.Round(time.Millisecond), err)
+		return err
+	}
+	if recovering {
+		plc.Reconnects++
+		plc._log().Infof("%s: session recovered after %d failed attempts", plc._logContext("reconnects", plc.Reconnects), plc.failures)
+	}
+	plc._log().Debugf("%s: connected, session 0x%08X, connection 0x%08X, serial 0x%04X, forward open %v",
+		plc._logContext(), plc.SessionHandle, plc.OTNetworkConnectionID, plc.SerialNumber, plc.ForwardOpened)
+	plc.sessionLost = false
+	plc.failures = 0
+	switch {
+	case recovering:
+		//# the controller may have come back with another project
+		plc._checkProject("reconnect")
+	case len(plc.CacheDir) > 0 && !plc.cacheLoaded && plc.Protocol != "pccc":
+		plc._loadCache()
+	case len(plc.project) == 0 && plc.changeDetection:
+		plc._checkProject("connect")
+	}
+	return nil
+}
+
+func (plc *PLC)_waitReconnect() error {
+	/*
+	Sits out the backoff after a failed attempt, or fails straight away
+	when the call's deadline comes before the next attempt is due
+	*/
+	wait := time.Until(plc.nextAttempt)
+	if plc.failures == 0 || wait <= 0 {
+		return nil
+	}
+	ctx := plc._context()
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(plc.nextAttempt) {
+		return fmt.Errorf("%w: %s: next attempt in %v", ErrConnectionLost, plc.IPAddress, wait.Round(time.Millisecond))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return _contextError("reconnect", ctx.Err())
+	}
+}
+
+func (plc *PLC)_reconnectDelay() time.Duration {
+	/*
+	ReconnectMinDelay doubled for every failure after the first, capped
+	at ReconnectMaxDelay, then somewhere between half and all of it
+	*/
+	min := time.Duration(plc.ReconnectMinDelay)
+	if min <= 0 {
+		min = defaultReconnectMinDelay
+	}
+	max := time.Duration(plc.ReconnectMaxDelay)
+	if max <= 0 {
+		max = defaultReconnectMaxDelay
+	}
+	if min > max {
+		min = max
+	}
+	delay := min
+	for i := 1; i < plc.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (plc *PLC)_sessionLost(reason error) {
+	/*
+	Gives up on the current session, the next request reconnects
+	*/
+	if plc.SocketConnected {
+		plc._log().Warnf("%s: session lost: %v", plc._logContext("session", fmt.Sprintf("0x%08X", plc.SessionHandle)), reason)
+		plc.sessionLost = true
+	}
+	plc._dropConnection()
+}
+
+func (plc *PLC)_clearCaches() {
+	plc.cacheMu.Lock()
+	plc.KnownTags = make(map[string]TagMap)
+	plc.templates = make(map[uint16]*Template)
+	plc.TagList = nil
+	plc.ProgramNames = nil
+	plc.symbols = nil
+	plc.cacheMu.Unlock()
+}
+
+//# extended statuses of 0x01 that are about the connection itself, the
+//# others (unconnected send timed out, port not available...) fail one
+//# request and leave the session alone
+var connectionLostStatus = map[uint16]bool{
+	0x0100: true, //# connection in use or duplicate Forward Open
+	0x0107: true, //# target connection not found
+	0x0203: true, //# connection timed out
+}
+
+func _connectionFailed(reply []byte, connected bool) bool {
+	/*
+	0x07 is a lost connection, 0x01 only when it came back on the
+	connection and names it, either way the session has to be built
+	up again. Unconnected messages have no connection to lose
+	*/
+	if len(reply) < 4 || (reply[2] == 0x01 && !connected) {
+		return false
+	}
+	return errors.Is(_cipError(reply, 0, "connection"), ErrConnectionLost)
+}
+
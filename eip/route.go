@@ -0,0 +1,143 @@
+package eip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+Route strings describe the hops between the module we open the TCP
+connection to and the controller, as comma separated port/link pairs.
+	1,0                     backplane, slot 0
+	1,0,2,192.168.5.10,1,3  backplane slot 0, out its Ethernet port
+	                        to 192.168.5.10, then backplane slot 3
+Every pair becomes one CIP port segment (Vol 1 C-1.4.2)
+*/
+
+type RouteHop struct {
+	Port uint16
+	Link string
+}
+
+func ParseRoute(route string) ([]RouteHop, error) {
+	/*
+	Splits a route string into its port/link pairs
+	An empty route is valid and means the target is the module itself
+	*/
+	var hops []RouteHop
+	route = strings.TrimSpace(route)
+	if len(route) == 0 {
+		return hops, nil
+	}
+
+	parts := strings.Split(route, ",")
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("route %q: expected port,link pairs", route)
+	}
+
+	for i := 0; i < len(parts); i += 2 {
+		p := strings.TrimSpace(parts[i])
+		l := strings.TrimSpace(parts[i+1])
+
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("route %q: invalid port %q", route, p)
+		}
+		if len(l) == 0 {
+			return nil, fmt.Errorf("route %q: missing link address for port %d", route, port)
+		}
+		if _, err := strconv.ParseUint(l, 10, 8); err != nil && net.ParseIP(l) == nil {
+			return nil, fmt.Errorf("route %q: invalid link address %q", route, l)
+		}
+		hops = append(hops, RouteHop{Port: uint16(port), Link: l})
+	}
+	return hops, nil
+}
+
+func EncodeRoute(hops []RouteHop) []byte {
+	/*
+	Builds the port segments for a route
+	Numeric links fit in one byte, anything else (IP addresses) is
+	sent as an extended link address
+	*/
+	buf := new(bytes.Buffer)
+
+	for _, hop := range hops {
+		segment := new(bytes.Buffer)
+		var link []byte
+		extended := false
+
+		if n, err := strconv.ParseUint(hop.Link, 10, 8); err == nil {
+			link = []byte{byte(n)}
+		} else {
+			link = []byte(hop.Link)
+			extended = true
+		}
+
+		//# port identifier lives in the low nibble, 15 means the port follows as a word
+		segmentType := byte(0x00)
+		if extended {
+			segmentType |= 0x10
+		}
+		if hop.Port < 15 {
+			segmentType |= byte(hop.Port)
+		} else {
+			segmentType |= 0x0F
+		}
+		segment.WriteByte(segmentType)
+
+		if extended {
+			segment.WriteByte(byte(len(link)))
+		}
+		if hop.Port >= 15 {
+			binary.Write(segment, binary.LittleEndian, hop.Port)
+		}
+		segment.Write(link)
+
+		//# segments are padded to an even number of bytes
+		if segment.Len()%2 > 0 {
+			segment.WriteByte(0x00)
+		}
+		buf.Write(segment.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func (plc *PLC)_routePath() ([]byte, error) {
+	/*
+	Returns the port segments to reach the controller, defaults to
+	the local backplane and ProcessorSlot when no route is configured
+	Micro800 is always the module we're connected to, and so
+	are PCCC controllers unless they sit behind a bridge
+	*/
+	if plc.Micro800 {
+		return []byte{}, nil
+	}
+	route := plc.Route
+	if len(strings.TrimSpace(route)) == 0 {
+		if plc.Protocol == "pccc" {
+			return []byte{}, nil
+		}
+		route = "1," + strconv.Itoa(int(plc.ProcessorSlot))
+	}
+	hops, err := ParseRoute(route)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeRoute(hops), nil
+}
+
+func (plc *PLC)_connectionPath() ([]byte, error) {
+	/*
+	Route to the controller followed by its message router (class 0x02, instance 1)
+	*/
+	path, err := plc._routePath()
+	if err != nil {
+		return nil, err
+	}
+	return append(path, 0x20, 0x02, 0x24, 0x01), nil
+}
@@ -0,0 +1,816 @@
+package eip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+/*
+A structure tag (UDT) is one block of bytes laid out by its template
+(class 0x6C), which lists every member with its type, array size and
+byte offset. ReadInto reads the block and copies the members into the
+fields of a Go struct, WriteFrom builds the block from one and writes
+it back in a single Write Tag:
+	type Batch struct {
+		ID int32 `cip:"BatchID"`
+		Product string `cip:"Product"`
+		Running bool `cip:"Running"`
+		Weights [10]float32 `cip:"Weights"`
+		Header Header `cip:"Header"`
+		Comment string `cip:"-"`
+	}
+	err := plc.ReadInto("Line1Batch", &batch)
+Fields without a cip tag go by their own name, names are matched
+without regard to case like the controller does. Every member has to
+have a field and every field a member, anything else is
+ErrTypeMismatch, so a UDT that was changed in the project doesn't go
+half read
+*/
+
+type Template struct {
+	Instance uint16
+	Handle uint16 //# what the controller identifies the type by in reads and writes
+	Name string
+	Size int //# bytes of one structure
+	Members []TemplateMember
+}
+
+type TemplateMember struct {
+	Name string
+	Type uint16 //# atomic type code, or 0x8000 | template instance for structures
+	Count int //# array elements, 0 when it isn't an array
+	Bit int //# which bit of the host SINT a BOOL member is
+	Offset int
+}
+
+func (m TemplateMember) IsStruct() bool {
+	return m.Type & 0x8000 > 0
+}
+
+func (m TemplateMember) Hidden() bool {
+	//# the SINTs that packed BOOLs live in
+	return strings.HasPrefix(m.Name, "ZZZZZZZZZZ") || strings.HasPrefix(m.Name, "__")
+}
+
+func (t *Template) Member(name string) (TemplateMember, bool) {
+	for _, m := range t.Members {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return TemplateMember{}, false
+}
+
+func (t *Template) IsString() bool {
+	/*
+	STRING and user defined string types are a DINT LEN and a SINT
+	array DATA
+	*/
+	if len(t.Members) != 2 {
+		return false
+	}
+	length, ok := t.Member("LEN")
+	if !ok || length.Type != 0xC4 {
+		return false
+	}
+	data, ok := t.Member("DATA")
+	return ok && data.Type == 0xC2 && data.Count > 0
+}
+
+func (plc *PLC)ReadInto(tag string, v interface{}) error {
+	/*
+	Reads a structure tag into the struct v points to
+	*/
+	return plc.ReadIntoContext(context.Background(), tag, v)
+}
+
+func (plc *PLC)ReadIntoContext(ctx context.Context, tag string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("eip: ReadInto needs a pointer to a struct, got %T", v)
+	}
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return err
+	}
+	tmpl, err := plc._tagTemplate(tag)
+	if err != nil {
+		return err
+	}
+	data, handle, err := plc._readStruct(tag)
+	if err != nil {
+		return err
+	}
+	if handle != tmpl.Handle || len(data) < tmpl.Size {
+		return fmt.Errorf("%w: %s: reply doesn't match the %s template", ErrTypeMismatch, tag, tmpl.Name)
+	}
+	return plc._decodeStruct(tag, tmpl, data, rv.Elem())
+}
+
+func (plc *PLC)WriteFrom(tag string, v interface{}) error {
+	/*
+	Writes the struct v, or the struct it points to, to a structure tag
+	*/
+	return plc.WriteFromContext(context.Background(), tag, v)
+}
+
+func (plc *PLC)WriteFromContext(ctx context.Context, tag string, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("eip: WriteFrom needs a struct, got %T", v)
+	}
+	defer plc._withContext(ctx)()
+	if err := plc._connect(); err != nil {
+		return err
+	}
+	tmpl, err := plc._tagTemplate(tag)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, tmpl.Size)
+	if err := plc._encodeStruct(tag, tmpl, data, rv); err != nil {
+		return err
+	}
+	return plc._writeStruct(tag, tmpl.Handle, data)
+}
+
+func (plc *PLC)_tagTemplate(tag string) (*Template, error) {
+	/*
+	The template of a structure tag, its elements or members: the tag
+	list has the template of the tag, member types lead the way from
+	there
+	*/
+	path, err := ParseTagPath(tag)
+	if err != nil {
+		return nil, err
+	}
+	if path.Bit >= 0 {
+		return nil, fmt.Errorf("%w: %s is a bit", ErrTypeMismatch, tag)
+	}
+	root := path.Root()
+
+	plc.cacheMu.RLock()
+	found := len(plc.TagList) > 0
+	var symbolType uint16
+	var ok bool
+	for _, t := range plc.TagList {
+		if strings.EqualFold(t.TagName, root) {
+			symbolType, ok = t.SymbolType, true
+			break
+		}
+	}
+	plc.cacheMu.RUnlock()
+	if !found {
+		tagList, err := plc._getTagList()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tagList {
+			if strings.EqualFold(t.TagName, root) {
+				symbolType, ok = t.SymbolType, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTagNotFound, root)
+	}
+
+	for i := 0; ; i++ {
+		if symbolType & 0x8000 == 0 {
+			return nil, fmt.Errorf("%w: %s isn't a structure", ErrTypeMismatch, tag)
+		}
+		tmpl, err := plc._getTemplate(symbolType & 0x0FFF)
+		if err != nil {
+			return nil, err
+		}
+		if i+1 == len(path.Segments) {
+			return tmpl, nil
+		}
+		m, ok := tmpl.Member(path.Segments[i+1].Name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no member %s", ErrTagNotFound, tmpl.Name, path.Segments[i+1].Name)
+		}
+		symbolType = m.Type
+	}
+}
+
+func (plc *PLC)_getTemplate(instance uint16) (*Template, error) {
+	plc.cacheMu.RLock()
+	tmpl, ok := plc.templates[instance]
+	plc.cacheMu.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	path := fmt.Sprintf("template 0x%04X", instance)
+	//# Get Attribute List: handle(1), member count(2), definition words(4), structure size(5)
+	request := []byte{0x03, 0x03, 0x20, 0x6C, 0x25, 0x00, byte(instance), byte(instance >> 8)}
+	request = append(request, 0x04, 0x00, 0x01, 0x00, 0x02, 0x00, 0x04, 0x00, 0x05, 0x00)
+	retData, err := plc._request(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := _replyError(retData, 0x6C, path); err != nil {
+		return nil, err
+	}
+	attributes, err := _attributeList(_replyData(retData))
+	if err != nil {
+		return nil, fmt.Errorf("eip: %s: %w", path, err)
+	}
+	tmpl = &Template{
+		Instance: instance,
+		Handle: uint16(attributes[0x01]),
+		Size: int(attributes[0x05]),
+	}
+	memberCount := int(attributes[0x02])
+	definitionSize := int(attributes[0x04])*4 - 23
+
+	var definition []byte
+	for {
+		request := []byte{0x4C, 0x03, 0x20, 0x6C, 0x25, 0x00, byte(instance), byte(instance >> 8)}
+		request = binary.LittleEndian.AppendUint32(request, uint32(len(definition)))
+		request = binary.LittleEndian.AppendUint16(request, uint16(definitionSize-len(definition)))
+		retData, err := plc._request(request)
+		if err != nil {
+			return nil, err
+		}
+		if err := _replyError(retData, 0x6C, path); err != nil {
+			return nil, err
+		}
+		definition = append(definition, _replyData(retData)...)
+		if retData[2] != 0x06 || len(_replyData(retData)) == 0 {
+			break
+		}
+	}
+	if err := tmpl._parseDefinition(definition, memberCount); err != nil {
+		return nil, fmt.Errorf("eip: %s: %w", path, err)
+	}
+
+	plc.cacheMu.Lock()
+	if plc.templates == nil {
+		plc.templates = make(map[uint16]*Template)
+	}
+	plc.templates[instance] = tmpl
+	plc.cacheDirty = true
+	plc.cacheMu.Unlock()
+	return tmpl, nil
+}
+
+func _attributeList(data []byte) (map[uint16]uint32, error) {
+	/*
+	count(2), then id(2) status(2) value for every attribute, the
+	values being UINTs or UDINTs depending on the attribute
+	*/
+	sizes := map[uint16]int{0x01: 2, 0x02: 2, 0x04: 4, 0x05: 4}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("attribute list too short")
+	}
+	values := make(map[uint16]uint32)
+	count := int(binary.LittleEndian.Uint16(data))
+	offset := 2
+	for i := 0; i < count; i++ {
+		if offset+4 > len(data) {
+			return nil, fmt.Errorf("attribute list too short")
+		}
+		id := binary.LittleEndian.Uint16(data[offset:])
+		status := binary.LittleEndian.Uint16(data[offset+2:])
+		offset += 4
+		if status != 0 {
+			return nil, fmt.Errorf("attribute %d failed with status 0x%02X", id, status)
+		}
+		size, ok := sizes[id]
+		if !ok || offset+size > len(data) {
+			return nil, fmt.Errorf("unexpected attribute %d", id)
+		}
+		if size == 2 {
+			values[id] = uint32(binary.LittleEndian.Uint16(data[offset:]))
+		} else {
+			values[id] = binary.LittleEndian.Uint32(data[offset:])
+		}
+		offset += size
+	}
+	return values, nil
+}
+
+func (t *Template) _parseDefinition(definition []byte, memberCount int) error {
+	/*
+	8 bytes per member: info(2) type(2) offset(4), info is the array
+	size or a BOOL's bit number. Then "TemplateName;n...", and the
+	member names, all null terminated
+	*/
+	if len(definition) < 8*memberCount {
+		return fmt.Errorf("definition too short (%d bytes)", len(definition))
+	}
+	names := bytes.Split(definition[8*memberCount:], []byte{0x00})
+	if len(names) < memberCount+1 {
+		return fmt.Errorf("definition is missing member names")
+	}
+	t.Name = string(names[0])
+	if pos := strings.IndexAny(t.Name, ";:"); pos >= 0 {
+		t.Name = t.Name[:pos]
+	}
+	for i := 0; i < memberCount; i++ {
+		info := binary.LittleEndian.Uint16(definition[8*i:])
+		typ := binary.LittleEndian.Uint16(definition[8*i+2:])
+		m := TemplateMember{
+			Name: string(names[i+1]),
+			Type: typ &^ 0x6000, //# bits 13 and 14 are array dimensions
+			Offset: int(binary.LittleEndian.Uint32(definition[8*i+4:])),
+		}
+		if typ & 0x6000 > 0 {
+			m.Count = int(info)
+		} else if m.Type == 0xC1 {
+			m.Bit = int(info)
+		}
+		t.Members = append(t.Members, m)
+	}
+	return nil
+}
+
+func (plc *PLC)_readStruct(tag string) ([]byte, uint16, error) {
+	/*
+	Reads one whole structure, with fragmented reads when it doesn't
+	fit in one reply
+	Returns the structure bytes and the handle the reply came with
+	*/
+	tagIOI, err := plc._buildTagIOI(tag, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	var data []byte
+	var handle uint16
+	plc.Offset = 0
+	for {
+		retData, err := plc._request(plc._addPartialReadIOI(tagIOI, 1))
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := _replyError(retData, 0x6B, tag); err != nil {
+			return nil, 0, err
+		}
+		reply := _replyData(retData)
+		if len(reply) < 4 || reply[0] != 0xA0 || reply[1] != 0x02 {
+			return nil, 0, fmt.Errorf("%w: %s isn't a structure", ErrTypeMismatch, tag)
+		}
+		handle = binary.LittleEndian.Uint16(reply[2:])
+		data = append(data, reply[4:]...)
+		if retData[2] != 0x06 || len(reply) == 4 {
+			return data, handle, nil
+		}
+		plc.Offset = uint16(len(data))
+	}
+}
+
+func (plc *PLC)_writeStruct(tag string, handle uint16, data []byte) error {
+	//# the whole structure, with the handle of its template as the type
+	tagIOI, err := plc._buildTagIOI(tag, false)
+	if err != nil {
+		return err
+	}
+	typ := []byte{0xA0, 0x02, byte(handle), byte(handle >> 8)}
+	return plc._writeData(tag, tagIOI, typ, 1, data)
+}
+
+func _structFields(path string, tmpl *Template, v reflect.Value) (map[string]reflect.Value, error) {
+	/*
+	Pairs the template's members with the struct's fields, every
+	member and every field has to find its match
+	*/
+	fields := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("cip")
+		if name == "-" || (len(f.PkgPath) > 0 && len(name) == 0) {
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			//# reflect can't set or read it, binding it would panic later
+			return nil, fmt.Errorf("%w: %s: field %s is unexported, it can't hold member %s", ErrTypeMismatch, path, f.Name, name)
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if _, ok := tmpl.Member(name); !ok {
+			return nil, fmt.Errorf("%w: %s: %s has no member %s for field %s", ErrTypeMismatch, path, tmpl.Name, name, f.Name)
+		}
+		fields[strings.ToUpper(name)] = v.Field(i)
+	}
+	for _, m := range tmpl.Members {
+		if m.Hidden() {
+			continue
+		}
+		if _, ok := fields[strings.ToUpper(m.Name)]; !ok {
+			return nil, fmt.Errorf("%w: %s: no field for member %s of %s", ErrTypeMismatch, path, m.Name, tmpl.Name)
+		}
+	}
+	return fields, nil
+}
+
+func (plc *PLC)_decodeStruct(path string, tmpl *Template, data []byte, v reflect.Value) error {
+	if tmpl.IsString() && v.Kind() == reflect.String {
+		return _decodeString(path, tmpl, data, v)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %s: a %s doesn't go into a %s", ErrTypeMismatch, path, tmpl.Name, v.Type())
+	}
+	fields, err := _structFields(path, tmpl, v)
+	if err != nil {
+		return err
+	}
+	for _, m := range tmpl.Members {
+		if m.Hidden() {
+			continue
+		}
+		if err := plc._decodeMember(path + "." + m.Name, m, data, fields[strings.ToUpper(m.Name)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (plc *PLC)_decodeMember(path string, m TemplateMember, data []byte, field reflect.Value) error {
+	if m.Type == 0xC1 && m.Count == 0 {
+		if m.Offset >= len(data) {
+			return fmt.Errorf("eip: %s: member is beyond the structure", path)
+		}
+		return _setField(path, field, data[m.Offset] & (1 << uint(m.Bit)) > 0)
+	}
+	if m.Count == 0 {
+		return plc._decodeElement(path, m.Type, data[m.Offset:], field)
+	}
+
+	size, err := plc._elementSize(m.Type)
+	if err != nil {
+		return fmt.Errorf("eip: %s: %w", path, err)
+	}
+	if m.Offset + m.Count*size > len(data) {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	//# BOOL arrays are DWORDs, one bit per BOOL
+	if m.Type == 0xD3 && _elemKind(field) == reflect.Bool {
+		bits := m.Count*32
+		if err := _sizeList(path, field, bits); err != nil {
+			return err
+		}
+		for i := 0; i < bits; i++ {
+			word := binary.LittleEndian.Uint32(data[m.Offset + 4*(i/32):])
+			if err := _setField(path, field.Index(i), word & (1 << uint(i%32)) > 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := _sizeList(path, field, m.Count); err != nil {
+		return err
+	}
+	for i := 0; i < m.Count; i++ {
+		element := fmt.Sprintf("%s[%d]", path, i)
+		if err := plc._decodeElement(element, m.Type, data[m.Offset + i*size:], field.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (plc *PLC)_decodeElement(path string, typ uint16, data []byte, field reflect.Value) error {
+	if typ & 0x8000 > 0 {
+		tmpl, err := plc._getTemplate(typ & 0x0FFF)
+		if err != nil {
+			return err
+		}
+		if len(data) < tmpl.Size {
+			return fmt.Errorf("eip: %s: member is beyond the structure", path)
+		}
+		return plc._decodeStruct(path, tmpl, data[:tmpl.Size], field)
+	}
+	cipType, ok := plc.CIPTypes[byte(typ)]
+	if !ok || cipType.dataLen == 0 || typ > 0xFF {
+		return fmt.Errorf("eip: %s: unsupported data type 0x%02X", path, typ)
+	}
+	if len(data) < cipType.dataLen {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	value, ok := _decodeAtomic(cipType.format, data)
+	if !ok {
+		return fmt.Errorf("eip: %s: unsupported data type 0x%02X", path, typ)
+	}
+	return _setField(path, field, value)
+}
+
+func _decodeString(path string, tmpl *Template, data []byte, v reflect.Value) error {
+	length, _ := tmpl.Member("LEN")
+	chars, _ := tmpl.Member("DATA")
+	if length.Offset+4 > len(data) || chars.Offset+chars.Count > len(data) {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	n := int(int32(binary.LittleEndian.Uint32(data[length.Offset:])))
+	if n < 0 || n > chars.Count {
+		n = chars.Count
+	}
+	v.SetString(string(data[chars.Offset:chars.Offset+n]))
+	return nil
+}
+
+func (plc *PLC)_encodeStruct(path string, tmpl *Template, data []byte, v reflect.Value) error {
+	if tmpl.IsString() && v.Kind() == reflect.String {
+		return _encodeString(path, tmpl, data, v.String())
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %s: a %s doesn't come from a %s", ErrTypeMismatch, path, tmpl.Name, v.Type())
+	}
+	fields, err := _structFields(path, tmpl, v)
+	if err != nil {
+		return err
+	}
+	for _, m := range tmpl.Members {
+		if m.Hidden() {
+			continue
+		}
+		if err := plc._encodeMember(path + "." + m.Name, m, data, fields[strings.ToUpper(m.Name)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (plc *PLC)_encodeMember(path string, m TemplateMember, data []byte, field reflect.Value) error {
+	if m.Type == 0xC1 && m.Count == 0 {
+		if field.Kind() != reflect.Bool {
+			return fmt.Errorf("%w: %s: a BOOL doesn't come from a %s", ErrTypeMismatch, path, field.Type())
+		}
+		if m.Offset >= len(data) {
+			return fmt.Errorf("eip: %s: member is beyond the structure", path)
+		}
+		if field.Bool() {
+			data[m.Offset] |= 1 << uint(m.Bit)
+		} else {
+			data[m.Offset] &^= 1 << uint(m.Bit)
+		}
+		return nil
+	}
+	if m.Count == 0 {
+		return plc._encodeElement(path, m.Type, data[m.Offset:], field)
+	}
+
+	size, err := plc._elementSize(m.Type)
+	if err != nil {
+		return fmt.Errorf("eip: %s: %w", path, err)
+	}
+	if m.Offset + m.Count*size > len(data) {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	if m.Type == 0xD3 && _elemKind(field) == reflect.Bool {
+		bits := m.Count*32
+		if err := _checkLength(path, field, bits); err != nil {
+			return err
+		}
+		for i := 0; i < field.Len(); i++ {
+			if field.Index(i).Bool() {
+				data[m.Offset + 4*(i/32) + (i%32)/8] |= 1 << uint(i%8)
+			}
+		}
+		return nil
+	}
+	if err := _checkLength(path, field, m.Count); err != nil {
+		return err
+	}
+	for i := 0; i < field.Len(); i++ {
+		element := fmt.Sprintf("%s[%d]", path, i)
+		if err := plc._encodeElement(element, m.Type, data[m.Offset + i*size:], field.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (plc *PLC)_encodeElement(path string, typ uint16, data []byte, field reflect.Value) error {
+	if typ & 0x8000 > 0 {
+		tmpl, err := plc._getTemplate(typ & 0x0FFF)
+		if err != nil {
+			return err
+		}
+		if len(data) < tmpl.Size {
+			return fmt.Errorf("eip: %s: member is beyond the structure", path)
+		}
+		return plc._encodeStruct(path, tmpl, data[:tmpl.Size], field)
+	}
+	cipType, ok := plc.CIPTypes[byte(typ)]
+	if !ok || cipType.dataLen == 0 || typ > 0xFF {
+		return fmt.Errorf("eip: %s: unsupported data type 0x%02X", path, typ)
+	}
+	if len(data) < cipType.dataLen {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	return _encodeAtomic(path, cipType, data, field)
+}
+
+func _encodeString(path string, tmpl *Template, data []byte, s string) error {
+	length, _ := tmpl.Member("LEN")
+	chars, _ := tmpl.Member("DATA")
+	if len(s) > chars.Count {
+		return fmt.Errorf("%w: %s: %d characters don't fit in a %s", ErrOverflow, path, len(s), tmpl.Name)
+	}
+	if length.Offset+4 > len(data) || chars.Offset+chars.Count > len(data) {
+		return fmt.Errorf("eip: %s: member is beyond the structure", path)
+	}
+	binary.LittleEndian.PutUint32(data[length.Offset:], uint32(len(s)))
+	copy(data[chars.Offset:chars.Offset+chars.Count], make([]byte, chars.Count))
+	copy(data[chars.Offset:], s)
+	return nil
+}
+
+func (plc *PLC)_elementSize(typ uint16) (int, error) {
+	if typ & 0x8000 > 0 {
+		tmpl, err := plc._getTemplate(typ & 0x0FFF)
+		if err != nil {
+			return 0, err
+		}
+		return tmpl.Size, nil
+	}
+	cipType, ok := plc.CIPTypes[byte(typ)]
+	if !ok || cipType.dataLen == 0 || typ > 0xFF {
+		return 0, fmt.Errorf("unsupported data type 0x%02X", typ)
+	}
+	return cipType.dataLen, nil
+}
+
+func _elemKind(field reflect.Value) reflect.Kind {
+	if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
+		return field.Type().Elem().Kind()
+	}
+	return reflect.Invalid
+}
+
+func _sizeList(path string, field reflect.Value, count int) error {
+	/*
+	Slices are made to fit, arrays have to be the member's size
+	*/
+	if field.Kind() == reflect.Slice {
+		field.Set(reflect.MakeSlice(field.Type(), count, count))
+		return nil
+	}
+	return _checkLength(path, field, count)
+}
+
+func _checkLength(path string, field reflect.Value, count int) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		if field.Len() > count {
+			return fmt.Errorf("%w: %s: %d elements don't fit in %d", ErrOverflow, path, field.Len(), count)
+		}
+		return nil
+	case reflect.Array:
+		if field.Len() != count {
+			return fmt.Errorf("%w: %s: the member has %d elements, the field %d", ErrTypeMismatch, path, count, field.Len())
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s: an array doesn't go with a %s", ErrTypeMismatch, path, field.Type())
+}
+
+func _setField(path string, field reflect.Value, value interface{}) error {
+	/*
+	Sets an atomic value with the same rules as Read[T]
+	*/
+	mismatch := fmt.Errorf("%w: %s: a %s doesn't go into a %s", ErrTypeMismatch, path, _goTypeName(value), field.Type())
+	overflow := fmt.Errorf("%w: %s: %v doesn't fit in a %s", ErrOverflow, path, value, field.Type())
+	switch field.Kind() {
+	case reflect.Interface:
+		field.Set(reflect.ValueOf(value))
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch
+		}
+		field.SetBool(b)
+	case reflect.Float32, reflect.Float64:
+		f, ok := _floatValue(value)
+		if !ok {
+			return mismatch
+		}
+		if field.OverflowFloat(f) {
+			return overflow
+		}
+		field.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := _toInt64(value)
+		if u, isU64 := value.(uint64); isU64 {
+			if u > math.MaxInt64 {
+				return overflow
+			}
+			n, ok = int64(u), true
+		}
+		if !ok {
+			return mismatch
+		}
+		if field.OverflowInt(n) {
+			return overflow
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if v, isU64 := value.(uint64); isU64 {
+			u = v
+		} else {
+			n, ok := _toInt64(value)
+			if !ok {
+				return mismatch
+			}
+			if n < 0 {
+				return overflow
+			}
+			u = uint64(n)
+		}
+		if field.OverflowUint(u) {
+			return overflow
+		}
+		field.SetUint(u)
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+func _encodeAtomic(path string, cipType CIPTypesStruct, data []byte, field reflect.Value) error {
+	/*
+	Puts a field into the member's type, the value has to fit
+	*/
+	mismatch := fmt.Errorf("%w: %s: a %s doesn't come from a %s", ErrTypeMismatch, path, cipType.dataType, field.Type())
+	overflow := fmt.Errorf("%w: %s: %v doesn't fit in a %s", ErrOverflow, path, field.Interface(), cipType.dataType)
+	if field.Kind() == reflect.Interface {
+		field = field.Elem()
+	}
+
+	switch cipType.format {
+	case '?':
+		if field.Kind() != reflect.Bool {
+			return mismatch
+		}
+		if field.Bool() {
+			data[0] = 0xFF
+		} else {
+			data[0] = 0x00
+		}
+		return nil
+	case 'f', 'd':
+		var f float64
+		switch field.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f = field.Float()
+		default:
+			return mismatch
+		}
+		if cipType.format == 'f' {
+			if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+				return overflow
+			}
+			binary.LittleEndian.PutUint32(data, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+		}
+		return nil
+	}
+
+	//# integers, as a signed value with the range of the member's type
+	var n int64
+	var u uint64
+	negative := false
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = field.Int()
+		negative = n < 0
+		u = uint64(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u = field.Uint()
+		n = int64(u)
+	default:
+		return mismatch
+	}
+	bits := uint(cipType.dataLen*8)
+	switch cipType.format {
+	case 'b', 'h', 'i', 'q':
+		if !negative && u > uint64(1)<<(bits-1)-1 {
+			return overflow
+		}
+		if negative && n < -int64(uint64(1)<<(bits-1)) {
+			return overflow
+		}
+	case 'B', 'H', 'I', 'Q':
+		if negative || (bits < 64 && u > uint64(1)<<bits-1) {
+			return overflow
+		}
+	default:
+		return mismatch
+	}
+	for i := 0; i < cipType.dataLen; i++ {
+		data[i] = byte(u >> (8*uint(i)))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package eip
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+With SymbolInstances set, reads address controller scoped tags by their
+Symbol Object instance once the tag list has been fetched:
+	20 6B 25 00 <instance>   instead of   91 <length> <name> [pad]
+That's 6 bytes however long the name is, so a Multiple Service Packet
+has room for more tags. Program scoped tags keep their names, their
+instances only mean something within the program
+A download can renumber the instances. Every collection checks the
+controller's change detection attributes first, and a change reloads
+the tag list; a read that fails by instance in between is retried by
+name, and when that works the tag list is reloaded too
+Structure reads and writes always go by name, a stale instance must
+never write to the wrong tag
+*/
+
+func (plc *PLC)_tagIOI(path *TagPath, isBoolArray bool) ([]byte, bool) {
+	/*
+	Returns the IOI for a read and whether it uses the symbol instance
+	*/
+	if plc.SymbolInstances && len(path.Program) == 0 {
+		plc.cacheMu.RLock()
+		symbol, ok := plc.symbols[strings.ToUpper(path.Segments[0].Name)]
+		plc.cacheMu.RUnlock()
+		if ok {
+			return path._instanceIOI(symbol.InstanceID, isBoolArray), true
+		}
+	}
+	return path._ioi(isBoolArray), false
+}
+
+func (plc *PLC)_instancesInUse() bool {
+	if !plc.SymbolInstances {
+		return false
+	}
+	plc.cacheMu.RLock()
+	defer plc.cacheMu.RUnlock()
+	return plc.symbols != nil
+}
+
+func _symbolTable(tagList []LGXTag) map[string]LGXTag {
+	//# tag names aren't case sensitive
+	symbols := make(map[string]LGXTag)
+	for _, t := range tagList {
+		symbols[strings.ToUpper(t.TagName)] = t
+	}
+	return symbols
+}
+
+func (plc *PLC)_symbol(path *TagPath) (LGXTag, bool) {
+	//# the tag list entry of the tag a path starts at, when there's a tag list
+	plc.cacheMu.RLock()
+	defer plc.cacheMu.RUnlock()
+	symbol, ok := plc.symbols[strings.ToUpper(path.Root())]
+	return symbol, ok
+}
+
+func (plc *PLC)_checkSymbol(path *TagPath, elements int) error {
+	/*
+	Requests that can only fail aren't sent when the tag list says so:
+	tags without external access, indexes past the end of the array
+	and more elements than there are from the index on
+	Members are left to the controller
+	*/
+	symbol, ok := plc._symbol(path)
+	if !ok {
+		return nil
+	}
+	if !symbol.Readable() {
+		return fmt.Errorf("%w: %s", ErrNoAccess, symbol.TagName)
+	}
+	if len(path.Segments) > 1 || path.Bit >= 0 {
+		return nil
+	}
+	indexes := path.Segments[0].Indexes
+	dims := append([]int(nil), symbol.Dimensions...)
+	if symbol.DataType == 0xD3 && len(dims) == 1 {
+		//# BOOL arrays are listed in DWORDs but indexed by bit
+		dims[0] *= 32
+	}
+	if len(indexes) > 0 && len(indexes) != len(dims) {
+		return fmt.Errorf("%w: %s has %d dimensions, not %d", ErrInvalidTag, symbol.TagName, len(dims), len(indexes))
+	}
+	linear, total := 0, 1
+	for k, d := range dims {
+		index := 0
+		if k < len(indexes) {
+			index = indexes[k]
+		}
+		if index >= d {
+			return fmt.Errorf("%w: %s: index %d of dimension %d is past its %d elements", ErrOverflow, path, index, k+1, d)
+		}
+		linear = linear*d + index
+		total *= d
+	}
+	if elements > total-linear {
+		return fmt.Errorf("%w: %s: %d elements asked for, %d from there on", ErrOverflow, path, elements, total-linear)
+	}
+	return nil
+}
+
+func (plc *PLC)_loadSymbols(ctx context.Context) {
+	/*
+	Fetches the tag list when there are no instances to use, on the
+	first collection and again after a reconnect
+	*/
+	plc.cacheMu.RLock()
+	loaded := plc.symbols != nil
+	plc.cacheMu.RUnlock()
+	if loaded {
+		return
+	}
+	if _, err := plc.GetTagListContext(ctx); err != nil {
+		plc._log().Warnf("%s: loading symbol instances: %v", plc._logContext(), err)
+	}
+}
+
+func (plc *PLC)_symbolsChanged() {
+	/*
+	A tag read by name that its instance couldn't find: the project
+	changed, whether or not change detection noticed yet
+	*/
+	plc._log().Infof("%s: symbol instances are stale, reloading the tag list", plc._logContext())
+	if plc.changeDetection {
+		if project, err := plc._projectIdentity(); err == nil {
+			plc.cacheMu.Lock()
+			plc.project = project
+			plc.cacheMu.Unlock()
+		}
+	}
+	plc._projectChanged("symbol_instances")
+}
+
@@ -0,0 +1,397 @@
+package eip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Tag names are parsed once into a TagPath and every IOI is encoded from
+that:
+	Program:MainProgram.Batch[2].Steps[1,3].Done
+	Local:1:I.Data[0].5
+The program scope is a symbol of its own, every name after the first is
+a member of the one before it, indexes belong to the name they follow
+(one per dimension) and a number at the end is a bit of the word the
+path points at
+*/
+
+const (
+	maxNameLength = 40 //# Logix limit for tag and member names
+	maxDimensions = 3
+	maxBit = 63 //# bits of a LINT
+)
+
+type TagPath struct {
+	Program string //# empty for controller scope
+	Segments []PathSegment
+	Bit int //# -1 when the path doesn't end on a bit number
+}
+
+type PathSegment struct {
+	Name string
+	Indexes []int //# one per dimension, none for the whole tag or member
+}
+
+func ParseTagPath(tag string) (*TagPath, error) {
+	p := pathParser{src: tag}
+	return p._parse()
+}
+
+func (t *TagPath)String() string {
+	var sb strings.Builder
+	if len(t.Program) > 0 {
+		sb.WriteString("Program:")
+		sb.WriteString(t.Program)
+		sb.WriteByte('.')
+	}
+	for i, s := range t.Segments {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(s.Name)
+		if len(s.Indexes) > 0 {
+			sb.WriteByte('[')
+			for k, index := range s.Indexes {
+				if k > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(strconv.Itoa(index))
+			}
+			sb.WriteByte(']')
+		}
+	}
+	if t.Bit >= 0 {
+		fmt.Fprintf(&sb, ".%d", t.Bit)
+	}
+	return sb.String()
+}
+
+func (t *TagPath)Root() string {
+	/*
+	The tag the path starts at, program scope included, which is how
+	the tag list names it
+	*/
+	if len(t.Program) > 0 {
+		return "Program:" + t.Program + "." + t.Segments[0].Name
+	}
+	return t.Segments[0].Name
+}
+
+func (t *TagPath)_base() *TagPath {
+	/*
+	The path without its bit number or the index of its last name:
+	Counts[5] is Counts, Motor.Status.3 is Motor.Status
+	The data type is looked up (and cached) for this one
+	*/
+	base := &TagPath{Program: t.Program, Bit: -1}
+	base.Segments = append([]PathSegment(nil), t.Segments...)
+	last := len(base.Segments)-1
+	base.Segments[last] = PathSegment{Name: base.Segments[last].Name}
+	return base
+}
+
+func (t *TagPath)_withLastIndex(index int) *TagPath {
+	//# the same element of another row: Temps[1,3] with 0 is Temps[1,0]
+	p := &TagPath{Program: t.Program, Bit: t.Bit}
+	p.Segments = append([]PathSegment(nil), t.Segments...)
+	last := &p.Segments[len(p.Segments)-1]
+	last.Indexes = append([]int(nil), last.Indexes...)
+	last.Indexes[len(last.Indexes)-1] = index
+	return p
+}
+
+func (t *TagPath)_lastIndex() int {
+	//# the index of a single dimension array, the last one for more
+	indexes := t.Segments[len(t.Segments)-1].Indexes
+	if len(indexes) == 0 {
+		return 0
+	}
+	return indexes[len(indexes)-1]
+}
+
+func (t *TagPath)_ioi(isBoolArray bool) []byte {
+	/*
+	Encodes the path as symbolic segments (0x91), each followed by
+	element segments for its indexes. A BOOL array is read as DWORDs,
+	so the last index is the word holding the bit
+	The bit number isn't part of the request, the whole word is read
+	*/
+	buf := new(bytes.Buffer)
+	if len(t.Program) > 0 {
+		_symbolSegment(buf, "Program:" + t.Program)
+	}
+	_symbolSegment(buf, t.Segments[0].Name)
+	t._encodeRest(buf, isBoolArray)
+	return buf.Bytes()
+}
+
+func (t *TagPath)_instanceIOI(instance uint32, isBoolArray bool) []byte {
+	/*
+	The same path with the tag addressed by its Symbol Object
+	instance (class 0x6B) instead of its name, members and indexes
+	follow as usual
+	*/
+	buf := new(bytes.Buffer)
+	buf.WriteByte(0x20)
+	buf.WriteByte(0x6B)
+	if instance < 0x10000 {
+		buf.WriteByte(0x25)
+		buf.WriteByte(0x00)
+		binary.Write(buf, binary.LittleEndian, uint16(instance))
+	} else {
+		buf.WriteByte(0x26)
+		buf.WriteByte(0x00)
+		binary.Write(buf, binary.LittleEndian, instance)
+	}
+	t._encodeRest(buf, isBoolArray)
+	return buf.Bytes()
+}
+
+func _ioiClass(ioi []byte) byte {
+	/*
+	The object a tag service request went to, for picking the extended
+	status table: the class of a leading logical class segment, the
+	Symbol Object (0x6B) that symbolic names are looked up in otherwise
+	*/
+	if len(ioi) >= 2 && ioi[0] == 0x20 {
+		return ioi[1]
+	}
+	return 0x6B
+}
+
+func (t *TagPath)_encodeRest(buf *bytes.Buffer, isBoolArray bool) {
+	//# everything after the tag name: its indexes, members and theirs
+	for i, s := range t.Segments {
+		if i > 0 {
+			_symbolSegment(buf, s.Name)
+		}
+		for k, index := range s.Indexes {
+			if isBoolArray && i == len(t.Segments)-1 && k == len(s.Indexes)-1 {
+				index = index/32
+			}
+			_elementSegment(buf, index)
+		}
+	}
+}
+
+func _symbolSegment(buf *bytes.Buffer, name string) {
+	buf.WriteByte(0x91)
+	buf.WriteByte(byte(len(name)))
+	buf.WriteString(name)
+	if len(name)%2 > 0 {
+		//# pad to an even number of bytes
+		buf.WriteByte(0x00)
+	}
+}
+
+func _elementSegment(buf *bytes.Buffer, index int) {
+	switch {
+	case index < 0x100:
+		buf.WriteByte(0x28)
+		buf.WriteByte(byte(index))
+	case index < 0x10000:
+		buf.WriteByte(0x29)
+		buf.WriteByte(0x00)
+		binary.Write(buf, binary.LittleEndian, uint16(index))
+	default:
+		buf.WriteByte(0x2A)
+		buf.WriteByte(0x00)
+		binary.Write(buf, binary.LittleEndian, uint32(index))
+	}
+}
+
+type pathParser struct {
+	src string
+	pos int
+}
+
+func (p *pathParser)_parse() (*TagPath, error) {
+	path := &TagPath{Bit: -1}
+	if len(p.src) == 0 {
+		return nil, p._error("empty tag name")
+	}
+
+	if len(p.src) >= 8 && strings.EqualFold(p.src[:8], "Program:") {
+		p.pos = 8
+		name, err := p._identifier()
+		if err != nil {
+			return nil, err
+		}
+		path.Program = name
+		if !p._accept('.') {
+			return nil, p._expected("'.' and a tag name after the program name")
+		}
+	}
+
+	for {
+		if len(path.Segments) > 0 && p._digit() {
+			bit, err := p._bit()
+			if err != nil {
+				return nil, err
+			}
+			path.Bit = bit
+			break
+		}
+
+		var s PathSegment
+		var err error
+		if len(path.Segments) == 0 && len(path.Program) == 0 {
+			s.Name, err = p._moduleName()
+		} else {
+			s.Name, err = p._identifier()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p._peek() == '[' {
+			if s.Indexes, err = p._indexes(); err != nil {
+				return nil, err
+			}
+		}
+		path.Segments = append(path.Segments, s)
+
+		if p.pos == len(p.src) {
+			break
+		}
+		if !p._accept('.') {
+			return nil, p._expected("'.', '[' or the end of the tag")
+		}
+		if p.pos == len(p.src) {
+			return nil, p._expected("a member name or bit number")
+		}
+	}
+	return path, nil
+}
+
+func (p *pathParser)_identifier() (string, error) {
+	/*
+	Letters, digits and underscores, not starting with a digit
+	*/
+	start := p.pos
+	if p.pos == len(p.src) || !(_isLetter(p.src[p.pos]) || p.src[p.pos] == '_') {
+		return "", p._expected("a name")
+	}
+	for p.pos < len(p.src) && (_isLetter(p.src[p.pos]) || _isDigit(p.src[p.pos]) || p.src[p.pos] == '_') {
+		p.pos++
+	}
+	if p.pos-start > maxNameLength {
+		return "", &PathError{Path: p.src, Pos: start, Msg: fmt.Sprintf("name longer than %d characters", maxNameLength)}
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *pathParser)_moduleName() (string, error) {
+	/*
+	I/O module tags are named after the module and its slot, with
+	colons: Local:1:I, Remote_Rack:3:O, Local:2:C
+	*/
+	start := p.pos
+	if _, err := p._identifier(); err != nil {
+		return "", err
+	}
+	for p._accept(':') {
+		if p._digit() {
+			for p._digit() {
+				p.pos++
+			}
+			continue
+		}
+		if _, err := p._identifier(); err != nil {
+			return "", err
+		}
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *pathParser)_indexes() ([]int, error) {
+	p.pos++ //# [
+	var indexes []int
+	for {
+		p._skipSpaces()
+		start := p.pos
+		if !p._digit() {
+			return nil, p._expected("an index")
+		}
+		for p._digit() {
+			p.pos++
+		}
+		index, err := strconv.ParseUint(p.src[start:p.pos], 10, 32)
+		if err != nil {
+			return nil, &PathError{Path: p.src, Pos: start, Msg: "index out of range"}
+		}
+		indexes = append(indexes, int(index))
+		if len(indexes) > maxDimensions {
+			return nil, &PathError{Path: p.src, Pos: start, Msg: fmt.Sprintf("more than %d dimensions", maxDimensions)}
+		}
+		p._skipSpaces()
+		if p._accept(']') {
+			return indexes, nil
+		}
+		if !p._accept(',') {
+			return nil, p._expected("',' or ']'")
+		}
+	}
+}
+
+func (p *pathParser)_bit() (int, error) {
+	start := p.pos
+	for p._digit() {
+		p.pos++
+	}
+	if p.pos < len(p.src) {
+		return 0, p._expected("the end of the tag after the bit number")
+	}
+	bit, err := strconv.Atoi(p.src[start:])
+	if err != nil || bit > maxBit {
+		return 0, &PathError{Path: p.src, Pos: start, Msg: fmt.Sprintf("bit number above %d", maxBit)}
+	}
+	return bit, nil
+}
+
+func (p *pathParser)_peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser)_accept(c byte) bool {
+	if p._peek() == c && p.pos < len(p.src) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pathParser)_digit() bool {
+	return p.pos < len(p.src) && _isDigit(p.src[p.pos])
+}
+
+func (p *pathParser)_skipSpaces() {
+	for p._peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *pathParser)_expected(what string) error {
+	if p.pos == len(p.src) {
+		return p._error("expected " + what + ", found the end")
+	}
+	return p._error(fmt.Sprintf("expected %s, found %q", what, p.src[p.pos]))
+}
+
+func (p *pathParser)_error(msg string) error {
+	return &PathError{Path: p.src, Pos: p.pos, Msg: msg}
+}
+
+func _isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func _isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,67 @@
+package eip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+The PLC only needs a net.Conn to talk to, where it comes from is up
+to the Dialer. net.Dialer, golang.org/x/net/proxy SOCKS dialers and
+golang.org/x/crypto/ssh clients all have a DialContext that fits, so
+a jump host is just:
+	plc.Dialer = sshClient
+and tests can hand out one end of a net.Pipe with DialerFunc
+*/
+
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+const dialTimeout = 5*time.Second
+
+func (plc *PLC)_dialer() (Dialer, error) {
+	/*
+	Returns the configured dialer, or a plain TCP one bound to
+	LocalAddress when the collector has more than one interface
+	*/
+	if plc.Dialer != nil {
+		return plc.Dialer, nil
+	}
+	d := &net.Dialer{Timeout: dialTimeout}
+	if len(plc.LocalAddress) > 0 {
+		ip := net.ParseIP(plc.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("eip: invalid local address %q", plc.LocalAddress)
+		}
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d, nil
+}
+
+func (plc *PLC)_dial() (net.Conn, error) {
+	dialer, err := plc._dialer()
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(plc.IPAddress, strconv.Itoa(int(plc.Port)))
+	ctx, cancel := context.WithTimeout(plc._context(), dialTimeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		if ctxErr := plc._context().Err(); ctxErr != nil {
+			return nil, _contextError("dial " + addr, ctxErr)
+		}
+		return nil, _ioError("dial " + addr, err)
+	}
+	return conn, nil
+}
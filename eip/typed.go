@@ -0,0 +1,277 @@
+package eip
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+/*
+Read hands back []interface{} because it can't know what the tag holds
+until the controller says so. When the caller does know, Read[T] and
+ReadArray[T] do the type switch for it:
+	speed, err := eip.Read[float32](ctx, plc, "Motor.Speed")
+	counts, err := eip.ReadArray[int64](ctx, plc, "Counts", 10)
+A value converts to T when it fits, a DINT of 100 reads fine as an
+int8, one of 1000 is ErrOverflow. Integers and floats don't mix,
+asking for the wrong kind of value is ErrTypeMismatch
+Methods can't have type parameters, hence functions taking the PLC,
+ReadDINT and friends cover the atomic types as methods
+*/
+
+func Read[T any](ctx context.Context, plc *PLC, tag string) (T, error) {
+	var zero T
+	values, err := plc.ReadContext(ctx, tag)
+	if err != nil {
+		return zero, err
+	}
+	if len(values) == 0 {
+		return zero, fmt.Errorf("eip: %s: no value in the reply", tag)
+	}
+	return _convert[T](tag, values[0])
+}
+
+func ReadArray[T any](ctx context.Context, plc *PLC, tag string, count int) ([]T, error) {
+	values, err := plc.ReadContext(ctx, tag, count)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]T, len(values))
+	for i, v := range values {
+		if result[i], err = _convert[T](fmt.Sprintf("%s element %d", tag, i), v); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (plc *PLC)ReadBOOL(tag string) (bool, error) {
+	return plc.ReadBOOLContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadBOOLContext(ctx context.Context, tag string) (bool, error) {
+	return Read[bool](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadSINT(tag string) (int8, error) {
+	return plc.ReadSINTContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadSINTContext(ctx context.Context, tag string) (int8, error) {
+	return Read[int8](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadINT(tag string) (int16, error) {
+	return plc.ReadINTContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadINTContext(ctx context.Context, tag string) (int16, error) {
+	return Read[int16](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadDINT(tag string) (int32, error) {
+	return plc.ReadDINTContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadDINTContext(ctx context.Context, tag string) (int32, error) {
+	return Read[int32](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadLINT(tag string) (int64, error) {
+	return plc.ReadLINTContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadLINTContext(ctx context.Context, tag string) (int64, error) {
+	return Read[int64](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadREAL(tag string) (float32, error) {
+	return plc.ReadREALContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadREALContext(ctx context.Context, tag string) (float32, error) {
+	return Read[float32](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadLREAL(tag string) (float64, error) {
+	return plc.ReadLREALContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadLREALContext(ctx context.Context, tag string) (float64, error) {
+	return Read[float64](ctx, plc, tag)
+}
+
+func (plc *PLC)ReadString(tag string) (string, error) {
+	return plc.ReadStringContext(context.Background(), tag)
+}
+
+func (plc *PLC)ReadStringContext(ctx context.Context, tag string) (string, error) {
+	return Read[string](ctx, plc, tag)
+}
+
+func _convert[T any](tag string, value interface{}) (T, error) {
+	var out T
+	mismatch := func() (T, error) {
+		var zero T
+		return zero, fmt.Errorf("%w: %s: a %s doesn't convert to %T", ErrTypeMismatch, tag, _goTypeName(value), zero)
+	}
+	overflow := func() (T, error) {
+		var zero T
+		return zero, fmt.Errorf("%w: %s: %v doesn't fit in %T", ErrOverflow, tag, value, zero)
+	}
+
+	switch p := any(&out).(type) {
+	case *interface{}:
+		*p = value
+	case *bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		*p = b
+	case *string:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		*p = s
+	case *float32:
+		f, ok := _floatValue(value)
+		if !ok {
+			return mismatch()
+		}
+		if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return overflow()
+		}
+		*p = float32(f)
+	case *float64:
+		f, ok := _floatValue(value)
+		if !ok {
+			return mismatch()
+		}
+		*p = f
+	case *int8, *int16, *int32, *int64, *int:
+		n, ok := _toInt64(value)
+		if u, isU64 := value.(uint64); isU64 {
+			if u > math.MaxInt64 {
+				return overflow()
+			}
+			n, ok = int64(u), true
+		}
+		if !ok {
+			return mismatch()
+		}
+		switch p := p.(type) {
+		case *int8:
+			if n < math.MinInt8 || n > math.MaxInt8 {
+				return overflow()
+			}
+			*p = int8(n)
+		case *int16:
+			if n < math.MinInt16 || n > math.MaxInt16 {
+				return overflow()
+			}
+			*p = int16(n)
+		case *int32:
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return overflow()
+			}
+			*p = int32(n)
+		case *int64:
+			*p = n
+		case *int:
+			if n < math.MinInt || n > math.MaxInt {
+				return overflow()
+			}
+			*p = int(n)
+		}
+	case *uint8, *uint16, *uint32, *uint64, *uint:
+		var u uint64
+		if v, isU64 := value.(uint64); isU64 {
+			u = v
+		} else {
+			n, ok := _toInt64(value)
+			if !ok {
+				return mismatch()
+			}
+			if n < 0 {
+				return overflow()
+			}
+			u = uint64(n)
+		}
+		switch p := p.(type) {
+		case *uint8:
+			if u > math.MaxUint8 {
+				return overflow()
+			}
+			*p = uint8(u)
+		case *uint16:
+			if u > math.MaxUint16 {
+				return overflow()
+			}
+			*p = uint16(u)
+		case *uint32:
+			if u > math.MaxUint32 {
+				return overflow()
+			}
+			*p = uint32(u)
+		case *uint64:
+			*p = u
+		case *uint:
+			if u > math.MaxUint {
+				return overflow()
+			}
+			*p = uint(u)
+		}
+	default:
+		v, ok := value.(T)
+		if !ok {
+			return mismatch()
+		}
+		out = v
+	}
+	return out, nil
+}
+
+func _floatValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func _goTypeName(value interface{}) string {
+	/*
+	The controller's name for the Go type a value was decoded into
+	*/
+	switch value.(type) {
+	case bool:
+		return "BOOL"
+	case int8:
+		return "SINT"
+	case int16:
+		return "INT"
+	case int32:
+		return "DINT"
+	case int64:
+		return "LINT"
+	case uint8:
+		return "USINT"
+	case uint16:
+		return "UINT"
+	case uint32:
+		return "UDINT"
+	case uint64:
+		return "LWORD"
+	case float32:
+		return "REAL"
+	case float64:
+		return "LREAL"
+	case string:
+		return "STRING"
+	}
+	return fmt.Sprintf("%T", value)
+}
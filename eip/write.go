@@ -0,0 +1,274 @@
+package eip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+Write puts values into a tag of an atomic type, one per element from
+the element the tag names on, a STRING, or a single bit:
+	err := plc.Write("Setpoints[2]", float32(12.5), float32(13))
+	err := plc.Write("Status.3", true)
+	err := plc.Write("Bits[70]", false)
+	err := plc.Write("Recipe", "Rye")
+The type comes from the controller, like for Read. Values have to fit
+it, strings are parsed for it, so "12.5" goes into a REAL and "0x1F"
+into a DINT. A bit is changed with Read Modify Write, the other bits
+of the word are left alone even when the program writes them at the
+same time
+Structures go through WriteFrom
+*/
+
+const writeChunkSize = 400
+
+func (plc *PLC)Write(tag string, values ...interface{}) error {
+	return plc.WriteContext(context.Background(), tag, values...)
+}
+
+func (plc *PLC)WriteContext(ctx context.Context, tag string, values ...interface{}) error {
+	if len(values) == 0 {
+		return fmt.Errorf("eip: %s: nothing to write", tag)
+	}
+	if len(values) > 0xFFFF {
+		return fmt.Errorf("eip: %s: too many values (%d)", tag, len(values))
+	}
+	defer plc._withContext(ctx)()
+	err := plc._writeTag(tag, values)
+	if err != nil {
+		plc._logTagError(tag, err)
+	}
+	return err
+}
+
+func (plc *PLC)_writeTag(tag string, values []interface{}) error {
+	plc.Offset = 0
+
+	if err := plc._connect(); err != nil {
+		return err
+	}
+	path, err := ParseTagPath(tag)
+	if err != nil {
+		return err
+	}
+	if err := plc._checkSymbol(path, len(values)); err != nil {
+		return err
+	}
+	if symbol, ok := plc._symbol(path); ok && symbol.ExternalAccess == AccessReadOnly {
+		return fmt.Errorf("%w: %s is read only", ErrNoAccess, symbol.TagName)
+	}
+	if err := plc._initialRead(path); err != nil {
+		return err
+	}
+	tm, _ := plc._lookupTag(path._base().String())
+
+	last := path.Segments[len(path.Segments)-1]
+	isBoolArray := tm.dataType == 0xD3 && !plc.Micro800 && len(last.Indexes) > 0 && path.Bit < 0
+	switch {
+	case path.Bit >= 0 || isBoolArray:
+		return plc._writeBit(tag, path, tm.dataType, isBoolArray, values)
+	case tm.dataType == 0xA0:
+		return plc._writeString(tag, values)
+	case tm.dataType == 0xDA:
+		return plc._writeShortString(tag, path, values)
+	}
+
+	cipType, ok := plc.CIPTypes[tm.dataType]
+	if !ok || cipType.dataLen == 0 {
+		return fmt.Errorf("%w: %s: can't write data type 0x%02X", ErrTypeMismatch, tag, tm.dataType)
+	}
+	data := make([]byte, len(values)*cipType.dataLen)
+	for i, v := range values {
+		field, err := _writeValue(tag, cipType, v)
+		if err != nil {
+			return err
+		}
+		if err := _encodeAtomic(tag, cipType, data[i*cipType.dataLen:], field); err != nil {
+			return err
+		}
+	}
+	return plc._writeData(tag, path._ioi(false), []byte{tm.dataType, 0x00}, len(values), data)
+}
+
+func (plc *PLC)_writeBit(tag string, path *TagPath, dataType byte, isBoolArray bool, values []interface{}) error {
+	/*
+	Read Modify Write Tag (0x4E): mask size(2), OR mask, AND mask
+	A BOOL array is written a DWORD at a time, like it's read
+	*/
+	if len(values) != 1 {
+		return fmt.Errorf("eip: %s: a bit takes one value, got %d", tag, len(values))
+	}
+	bit := path.Bit
+	if isBoolArray {
+		bit = path._lastIndex()%32
+	}
+	cipType, ok := plc.CIPTypes[dataType]
+	if !ok || cipType.dataLen == 0 || strings.ContainsRune("?fd", rune(cipType.format)) {
+		return fmt.Errorf("%w: %s: a %s has no bits to write", ErrTypeMismatch, tag, cipType.dataType)
+	}
+	if bit >= cipType.dataLen*8 {
+		return fmt.Errorf("%w: %s: a %s has %d bits", ErrOverflow, tag, cipType.dataType, cipType.dataLen*8)
+	}
+	value, err := _writeValue(tag, plc.CIPTypes[0xC1], values[0])
+	if err != nil {
+		return err
+	}
+	if value.Kind() != reflect.Bool {
+		return fmt.Errorf("%w: %s: a bit doesn't come from a %s", ErrTypeMismatch, tag, value.Type())
+	}
+
+	size := cipType.dataLen
+	orMask := make([]byte, size)
+	andMask := bytes.Repeat([]byte{0xFF}, size)
+	if value.Bool() {
+		orMask[bit/8] |= 1 << (bit%8)
+	} else {
+		andMask[bit/8] &^= 1 << (bit%8)
+	}
+	ioi := path._ioi(isBoolArray)
+	buf := new(bytes.Buffer)
+	buf.WriteByte(0x4E)
+	buf.WriteByte(byte(len(ioi)/2))
+	buf.Write(ioi)
+	binary.Write(buf, binary.LittleEndian, uint16(size))
+	buf.Write(orMask)
+	buf.Write(andMask)
+
+	retData, err := plc._request(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return _replyError(retData, _ioiClass(ioi), tag)
+}
+
+func (plc *PLC)_writeString(tag string, values []interface{}) error {
+	/*
+	STRING and user defined string types are structures, written whole
+	*/
+	tmpl, err := plc._tagTemplate(tag)
+	if err != nil {
+		return err
+	}
+	if !tmpl.IsString() {
+		return fmt.Errorf("%w: %s is a %s, structures are written with WriteFrom", ErrTypeMismatch, tag, tmpl.Name)
+	}
+	s, err := _stringValue(tag, values)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, tmpl.Size)
+	if err := _encodeString(tag, tmpl, data, s); err != nil {
+		return err
+	}
+	return plc._writeStruct(tag, tmpl.Handle, data)
+}
+
+func (plc *PLC)_writeShortString(tag string, path *TagPath, values []interface{}) error {
+	//# Micro800 strings are a length byte and the characters
+	s, err := _stringValue(tag, values)
+	if err != nil {
+		return err
+	}
+	if len(s) > 0xFF {
+		return fmt.Errorf("%w: %s: %d characters don't fit in a STRING", ErrOverflow, tag, len(s))
+	}
+	data := append([]byte{byte(len(s))}, s...)
+	return plc._writeData(tag, path._ioi(false), []byte{0xDA, 0x00}, 1, data)
+}
+
+func _stringValue(tag string, values []interface{}) (string, error) {
+	if len(values) != 1 {
+		return "", fmt.Errorf("eip: %s: a STRING takes one value, got %d", tag, len(values))
+	}
+	s, ok := values[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s: a STRING doesn't come from a %T", ErrTypeMismatch, tag, values[0])
+	}
+	return s, nil
+}
+
+func (plc *PLC)_writeData(tag string, ioi []byte, typ []byte, count int, data []byte) error {
+	/*
+	Write Tag when the data fits in one request, Write Tag Fragmented
+	a piece at a time when it doesn't. typ is the type as the
+	controller replies it, with the structure handle for structures
+	*/
+	for offset := 0; offset < len(data) || offset == 0; offset += writeChunkSize {
+		end := offset + writeChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		buf := new(bytes.Buffer)
+		if len(data) <= writeChunkSize {
+			buf.WriteByte(0x4D)
+		} else {
+			buf.WriteByte(0x53)
+		}
+		buf.WriteByte(byte(len(ioi)/2))
+		buf.Write(ioi)
+		buf.Write(typ)
+		binary.Write(buf, binary.LittleEndian, uint16(count))
+		if len(data) > writeChunkSize {
+			binary.Write(buf, binary.LittleEndian, uint32(offset))
+		}
+		buf.Write(data[offset:end])
+
+		retData, err := plc._request(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if err := _replyError(retData, _ioiClass(ioi), tag); err != nil {
+			return err
+		}
+		if end == len(data) {
+			break
+		}
+	}
+	return nil
+}
+
+func _writeValue(tag string, cipType CIPTypesStruct, v interface{}) (reflect.Value, error) {
+	/*
+	Strings are parsed for the tag's type, so values typed on a
+	command line go in as they are, and integers go into REALs too
+	*/
+	if v == nil {
+		return reflect.Value{}, fmt.Errorf("%w: %s: no value for a %s", ErrTypeMismatch, tag, cipType.dataType)
+	}
+	rv := reflect.ValueOf(v)
+	float := cipType.format == 'f' || cipType.format == 'd'
+	switch rv.Kind() {
+	case reflect.String:
+		s := strings.TrimSpace(rv.String())
+		var parsed interface{}
+		var err error
+		switch cipType.format {
+		case '?':
+			parsed, err = strconv.ParseBool(s)
+		case 'f', 'd':
+			parsed, err = strconv.ParseFloat(s, 64)
+		case 'B', 'H', 'I', 'Q':
+			parsed, err = strconv.ParseUint(s, 0, 64)
+		default:
+			parsed, err = strconv.ParseInt(s, 0, 64)
+		}
+		if err != nil {
+			return rv, fmt.Errorf("%w: %s: %q isn't a %s", ErrTypeMismatch, tag, s, cipType.dataType)
+		}
+		return reflect.ValueOf(parsed), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if float {
+			return reflect.ValueOf(float64(rv.Int())), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if float {
+			return reflect.ValueOf(float64(rv.Uint())), nil
+		}
+	}
+	return rv, nil
+}